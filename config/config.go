@@ -0,0 +1,215 @@
+// Package config loads the configuration of a service into a struct from
+// layered sources. In increasing order of precedence, the values are taken
+// from:
+//
+//   - the defaults, set with the "envDefault" struct tags;
+//   - a YAML or JSON file, see [WithFile];
+//   - the environment variables, named with the "env" struct tags;
+//   - the command-line flags, see [WithFlags].
+//
+// The configuration is a tree of structs. Every struct field is a section, and
+// the other fields hold the values. The sections of the framework are embedded
+// with [service.Config], and other sections, e.g. [rabbitmq.Config], can be
+// added next to the ones of the service itself:
+//
+//	type Config struct {
+//		service.Config
+//		RabbitMQ rabbitmq.Config `config:"rabbitmq"`
+//		Booking  BookingConfig   `config:"booking" envPrefix:"BOOKING_"`
+//	}
+//
+//	type BookingConfig struct {
+//		MaxSeats int `env:"MAX_SEATS" envDefault:"10" validate:"min=1"`
+//	}
+//
+// In files and flags the values are addressed by their path in the tree, which
+// is built from the "config" struct tags, or from the field names in snake
+// case. The value above is set with "booking: {max_seats: 20}" in a file, with
+// the BOOKING_MAX_SEATS environment variable, or with "-booking.max-seats=20".
+// Embedded structs do not add a level to the path.
+//
+// Confidential values, e.g. passwords and private keys, should be of type
+// [Secret]. Secrets can be read from files, and are redacted when formatted.
+//
+// Once loaded, the values are validated with the rules of the "validate" struct
+// tags, see [Load]. All problems are reported at once, see [Error].
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/eventscompass/service-framework/service"
+)
+
+// Option configures how [Load] reads the configuration.
+type Option func(*options)
+
+type options struct {
+	file  string
+	env   map[string]string
+	flags []string
+}
+
+// WithFile reads the configuration from the given YAML or JSON file, based on
+// its extension. The file can be overridden with the "-config" flag, see
+// [WithFlags].
+func WithFile(path string) Option {
+	return func(o *options) { o.file = path }
+}
+
+// WithEnv sets the environment from which the configuration is read, instead
+// of the environment variables of the process.
+func WithEnv(env map[string]string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// WithFlags reads the configuration from the command-line arguments, usually
+// os.Args[1:]. Every value can be set with a flag named by its path in the
+// tree, with dashes instead of underscores, e.g. "-rest.read-timeout=5s". The
+// "-config" flag sets the configuration file.
+func WithFlags(args []string) Option {
+	return func(o *options) { o.flags = args }
+}
+
+// Load loads the configuration into cfg, which must be a pointer to a struct.
+//
+// The values are validated with the comma separated rules of the "validate"
+// struct tags:
+//
+//   - required: the value must not be empty;
+//   - min=N and max=N: numbers and durations must be within the limits, and
+//     strings and lists must have as many elements;
+//   - oneof=a b c: the value must be one of the listed values;
+//   - url: the value must be an absolute url.
+//
+// This function returns [Error], which wraps [service.ErrInvalidConfig], if any
+// of the values cannot be parsed or is not valid. This function returns
+// [flag.ErrHelp] if the "-h" or "-help" flag is passed, after printing the
+// usage of the flags.
+func Load(cfg any, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	_, err := load(cfg, &o)
+	return err
+}
+
+// load loads the configuration into cfg, and returns the file from which it
+// was read, if any.
+func load(cfg any, o *options) (string, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("%w: expected a pointer to a struct, got %T", service.ErrInvalidConfig, cfg)
+	}
+	t := newTree(v.Elem())
+
+	// The flags are parsed first, as they may select the configuration file,
+	// but they are applied last.
+	file := o.file
+	var flags *flagSet
+	if o.flags != nil {
+		var err error
+		if flags, err = t.parseFlags(o.flags); err != nil {
+			return "", err
+		}
+		if flags.file != "" {
+			file = flags.file
+		}
+	}
+
+	t.applyDefaults()
+	if file != "" {
+		t.applyFile(file)
+	}
+	lookup := os.LookupEnv
+	if o.env != nil {
+		lookup = func(key string) (string, bool) {
+			v, ok := o.env[key]
+			return v, ok
+		}
+	}
+	t.applyEnv(lookup)
+	if flags != nil {
+		t.applyFlags(flags)
+	}
+	t.validate()
+
+	if len(t.problems) > 0 {
+		return file, &Error{Problems: t.problems}
+	}
+	return file, nil
+}
+
+// Problem is a problem with a value of the configuration.
+type Problem struct {
+	// Path is the path of the value in the tree, e.g. "rest.listen".
+	// It is empty if the problem is not related to a specific value.
+	Path string
+
+	// Source is the source of the value, e.g. "env HTTP_SERVER_LISTEN".
+	Source string
+
+	// Err describes the problem.
+	Err error
+}
+
+func (p *Problem) String() string {
+	switch {
+	case p.Path == "":
+		return fmt.Sprintf("%s: %v", p.Source, p.Err)
+	case p.Source == "":
+		return fmt.Sprintf("%s: %v", p.Path, p.Err)
+	default:
+		return fmt.Sprintf("%s (%s): %v", p.Path, p.Source, p.Err)
+	}
+}
+
+// Error is returned by [Load] and lists all problems with the configuration.
+// It wraps [service.ErrInvalidConfig].
+type Error struct {
+	Problems []*Problem
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.String()
+	}
+	return fmt.Sprintf("%v: %s", service.ErrInvalidConfig, strings.Join(msgs, "; "))
+}
+
+func (e *Error) Unwrap() error {
+	return service.ErrInvalidConfig
+}
+
+// errUnknownField is reported for values in the file without a matching field.
+var errUnknownField = errors.New("unknown field")
+
+// Dump returns the values of the configuration cfg, which must be a pointer to
+// a struct, by their path in the tree and formatted as text, e.g. for logging
+// the configuration on startup. The values of secrets are redacted.
+func Dump(cfg any) map[string]string {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	t := newTree(v.Elem())
+	values := make(map[string]string, len(t.fields))
+	for _, f := range t.fields {
+		values[f.path] = text(f.value)
+	}
+	return values
+}
+
+// text formats the value for dumps.
+func text(v reflect.Value) string {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(v.Interface())
+}
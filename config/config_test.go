@@ -0,0 +1,161 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/config"
+	"github.com/eventscompass/service-framework/service"
+)
+
+type testConfig struct {
+	Server  serverConfig  `config:"server" envPrefix:"SERVER_" reload:"restart"`
+	Booking bookingConfig `envPrefix:"BOOKING_"`
+}
+
+type serverConfig struct {
+	Listen  string        `env:"LISTEN" envDefault:":8080"`
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"5s" validate:"min=1s,max=1m" reload:"hot"`
+}
+
+type bookingConfig struct {
+	MaxSeats int           `env:"MAX_SEATS" envDefault:"10" validate:"min=1"`
+	Mode     string        `env:"MODE" envDefault:"open" validate:"oneof=open closed"`
+	Tags     []string      `env:"TAGS"`
+	Webhook  string        `env:"WEBHOOK" validate:"url"`
+	Password config.Secret `env:"PASSWORD"`
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+server:
+  listen: ":9090"
+  timeout: 10s
+booking:
+  max_seats: 20
+  tags: [a, b]
+`)
+	var cfg testConfig
+	err := config.Load(&cfg,
+		config.WithFile(file),
+		config.WithEnv(map[string]string{"BOOKING_MAX_SEATS": "30", "SERVER_TIMEOUT": "20s"}),
+		config.WithFlags([]string{"-server.timeout=30s"}),
+	)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if cfg.Server.Listen != ":9090" {
+		t.Errorf("got listen %q from the file, want :9090", cfg.Server.Listen)
+	}
+	if cfg.Booking.MaxSeats != 30 {
+		t.Errorf("got max seats %d from the env, want 30", cfg.Booking.MaxSeats)
+	}
+	if cfg.Server.Timeout != 30*time.Second {
+		t.Errorf("got timeout %v from the flags, want 30s", cfg.Server.Timeout)
+	}
+	if cfg.Booking.Mode != "open" {
+		t.Errorf("got mode %q, want the default open", cfg.Booking.Mode)
+	}
+	if got := strings.Join(cfg.Booking.Tags, ","); got != "a,b" {
+		t.Errorf("got tags %q, want a,b", got)
+	}
+}
+
+func TestLoadProblems(t *testing.T) {
+	file := writeFile(t, "config.json", `{"booking": {"unknown": 1}}`)
+	var cfg testConfig
+	err := config.Load(&cfg, config.WithFile(file), config.WithEnv(map[string]string{
+		"SERVER_TIMEOUT":    "2m",
+		"BOOKING_MAX_SEATS": "many",
+		"BOOKING_MODE":      "half",
+		"BOOKING_WEBHOOK":   "/hooks",
+	}))
+
+	if !errors.Is(err, service.ErrInvalidConfig) {
+		t.Fatalf("got error %v, want %v", err, service.ErrInvalidConfig)
+	}
+	var cfgErr *config.Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("got error %T, want %T", err, cfgErr)
+	}
+	got := make(map[string]string)
+	for _, p := range cfgErr.Problems {
+		got[p.Path] = p.String()
+	}
+	for path, want := range map[string]string{
+		"booking.unknown":   "unknown field",
+		"server.timeout":    "must be at most 1m",
+		"booking.max_seats": `(env BOOKING_MAX_SEATS)`,
+		"booking.mode":      "must be one of open, closed",
+		"booking.webhook":   "must be an absolute url",
+	} {
+		if !strings.Contains(got[path], want) {
+			t.Errorf("got problem %q for %s, want %q", got[path], path, want)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("got problems %v, want 5", got)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	type helpConfig struct {
+		User     string        `env:"USER" envDefault:"admin" validate:"min=2"`
+		Password config.Secret `env:"PASSWORD" envDefault:"hunter2" validate:"required"`
+	}
+
+	// The usage of the flags is written to stderr.
+	stderr := os.Stderr
+	out, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+	defer out.Close()
+	os.Stderr = out
+	err = config.Load(&helpConfig{}, config.WithEnv(map[string]string{}), config.WithFlags([]string{"-help"}))
+	os.Stderr = stderr
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("got error %v, want %v", err, flag.ErrHelp)
+	}
+
+	usage, _ := os.ReadFile(out.Name())
+	for _, want := range []string{
+		"string (env USER, default admin, min=2)",
+		"config.Secret (env PASSWORD, default [REDACTED], required)",
+	} {
+		if !strings.Contains(string(usage), want) {
+			t.Errorf("got usage %q, want %q", usage, want)
+		}
+	}
+	if strings.Contains(string(usage), "hunter2") {
+		t.Errorf("got usage %q with the default of the secret", usage)
+	}
+}
+
+func TestRequiredSecret(t *testing.T) {
+	type secretConfig struct {
+		Password config.Secret `env:"PASSWORD" validate:"required"`
+	}
+	err := config.Load(&secretConfig{}, config.WithEnv(map[string]string{"PASSWORD": ""}))
+	if !errors.Is(err, service.ErrInvalidConfig) || !strings.Contains(err.Error(), "must be set") {
+		t.Errorf("got error %v, want the password to be required", err)
+	}
+	if err := config.Load(&secretConfig{}, config.WithEnv(map[string]string{"PASSWORD": "x"})); err != nil {
+		t.Errorf("got error %v for a set password", err)
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyFile sets the values from the YAML or JSON file.
+func (t *tree) applyFile(path string) {
+	source := "file " + path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.problems = append(t.problems, &Problem{Source: source, Err: err})
+		return
+	}
+
+	var doc map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		err = fmt.Errorf("unsupported file extension %q", ext)
+	}
+	if err != nil {
+		t.problems = append(t.problems, &Problem{Source: source, Err: err})
+		return
+	}
+	t.applyMap(doc, "", source)
+}
+
+// applyMap sets the values of the section from the decoded document. Keys are
+// applied in order, so that problems are reported deterministically.
+func (t *tree) applyMap(doc map[string]any, prefix, source string) {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		value := doc[key]
+
+		if t.sections[path] {
+			section, ok := value.(map[string]any)
+			if !ok && value != nil {
+				t.problems = append(t.problems, &Problem{
+					Path: path, Source: source, Err: fmt.Errorf("expected a section, got %v", value),
+				})
+				continue
+			}
+			t.applyMap(section, path, source)
+			continue
+		}
+
+		f, ok := t.byPath[path]
+		if !ok {
+			t.problems = append(t.problems, &Problem{Path: path, Source: source, Err: errUnknownField})
+			continue
+		}
+		if value == nil {
+			continue // an empty value in yaml keeps the previous value
+		}
+		t.setValue(f, value, source)
+	}
+}
+
+// setValue sets the field from a value decoded from the file.
+func (t *tree) setValue(f *field, value any, source string) {
+	list, isList := value.([]any)
+	if !isList {
+		t.set(f, scalarText(value), source)
+		return
+	}
+
+	f.source = source
+	if f.value.Kind() != reflect.Slice {
+		f.failed = true
+		t.problems = append(t.problems, &Problem{
+			Path: f.path, Source: source, Err: fmt.Errorf("expected a single value, got a list"),
+		})
+		return
+	}
+	elems := make([]string, len(list))
+	for i, elem := range list {
+		elems[i] = scalarText(elem)
+	}
+	if err := setList(f.value, elems); err != nil {
+		f.failed = true
+		t.problems = append(t.problems, &Problem{Path: f.path, Source: source, Err: err})
+		return
+	}
+	f.failed = false
+}
+
+// scalarText formats the scalar value decoded from a file as text, so that it
+// can be parsed like the values of the other sources.
+func scalarText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		// JSON numbers are decoded as float64. Integral values are
+		// formatted without an exponent, so they parse as integers.
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
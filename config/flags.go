@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// flagSet holds the values of the flags, in the order in which they were
+// passed.
+type flagSet struct {
+	file   string
+	values []flagValue
+}
+
+type flagValue struct {
+	field *field
+	name  string
+	value string
+}
+
+// parseFlags parses the command-line arguments. Every field of the tree has a
+// flag named by its path, with dashes instead of underscores.
+func (t *tree) parseFlags(args []string) (*flagSet, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags := &flagSet{}
+	fs.StringVar(&flags.file, "config", "", "the YAML or JSON configuration `file`")
+
+	for _, f := range t.fields {
+		f := f
+		name := strings.ReplaceAll(f.path, "_", "-")
+		record := func(s string) error {
+			flags.values = append(flags.values, flagValue{field: f, name: name, value: s})
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage(f), record)
+		} else {
+			fs.Func(name, usage(f), record)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err //nolint:wrapcheck // documented
+		}
+		return nil, &Error{Problems: []*Problem{{Source: "flags", Err: err}}}
+	}
+	return flags, nil
+}
+
+// usage describes the field in the usage of the flags. The defaults of secrets
+// are redacted.
+func usage(f *field) string {
+	var details []string
+	if f.env != "" {
+		details = append(details, "env "+f.env)
+	}
+	if f.hasDef && f.def != "" {
+		def := f.def
+		if f.value.Type() == secretType {
+			def = redacted
+		}
+		details = append(details, "default "+def)
+	}
+	if f.validate != "" {
+		details = append(details, f.validate)
+	}
+	if len(details) == 0 {
+		return f.value.Type().String()
+	}
+	return fmt.Sprintf("%s (%s)", f.value.Type(), strings.Join(details, ", "))
+}
+
+func (t *tree) applyFlags(flags *flagSet) {
+	for _, v := range flags.values {
+		t.set(v.field, v.value, "flag -"+v.name)
+	}
+}
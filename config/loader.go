@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eventscompass/service-framework/service"
+)
+
+// errRestartRequired is reported for changes of values that cannot be applied
+// while the service is running.
+var errRestartRequired = errors.New("cannot be changed without a restart")
+
+// Loader loads a configuration of type T, which must be a struct, and reloads
+// it while the service is running. Pass the loader to [service.Start] with
+// [service.WithConfig], and the configuration is reloaded whenever the file
+// changes or the service receives SIGHUP:
+//
+//	loader, err := config.NewLoader[Config](config.WithFile("config.yaml"))
+//	...
+//	service.Start(newService(loader), service.WithConfig(loader))
+//
+// Some values cannot be changed while the service is running, e.g. the listen
+// addresses of the servers. These are marked with the reload:"restart" struct
+// tag, which applies to all values of a section, unless they are marked with
+// reload:"hot". A reload that changes any of them is rejected.
+type Loader[T any] struct {
+	opts []Option
+	file string
+	mu   sync.Mutex // serializes reloads
+	cur  atomic.Pointer[T]
+}
+
+var _ service.ConfigSource = (*Loader[struct{}])(nil)
+
+// NewLoader creates a [Loader] and loads the configuration with the given
+// options, see [Load]. This function returns the errors of [Load].
+func NewLoader[T any](opts ...Option) (*Loader[T], error) {
+	l := &Loader[T]{opts: opts}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cfg := new(T)
+	file, err := load(cfg, &o)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	l.cur.Store(cfg)
+	return l, nil
+}
+
+// Get returns the current configuration. The returned value must not be
+// modified. A reload replaces it with a new value, so callers should not keep
+// it around if they want to see the changes.
+func (l *Loader[T]) Get() *T {
+	return l.cur.Load()
+}
+
+// Current returns the current configuration, like [Loader.Get].
+func (l *Loader[T]) Current() any {
+	return l.cur.Load()
+}
+
+// File returns the file from which the configuration is loaded, if any.
+func (l *Loader[T]) File() string {
+	return l.file
+}
+
+// Reload loads the configuration again. If it is valid and does not change any
+// of the values that require a restart, it replaces the current configuration
+// and is returned. This function returns [Error] otherwise, and the current
+// configuration is kept.
+func (l *Loader[T]) Reload() (any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var o options
+	for _, opt := range l.opts {
+		opt(&o)
+	}
+	cfg := new(T)
+	if _, err := load(cfg, &o); err != nil {
+		return nil, err
+	}
+
+	cur := newTree(reflect.ValueOf(l.cur.Load()).Elem())
+	next := newTree(reflect.ValueOf(cfg).Elem())
+	var problems []*Problem
+	for _, f := range next.fields {
+		old := cur.byPath[f.path]
+		if f.restart && !equal(old.value, f.value) {
+			problems = append(problems, &Problem{Path: f.path, Err: errRestartRequired})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+
+	l.cur.Store(cfg)
+	return cfg, nil
+}
+
+// equal reports whether the values are equal. Secrets are compared by value.
+func equal(a, b reflect.Value) bool {
+	if a.Type() == secretType {
+		return a.Interface().(Secret).Value() == b.Interface().(Secret).Value() //nolint:forcetypeassert // checked
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/config"
+)
+
+func TestLoaderReload(t *testing.T) {
+	env := map[string]string{"BOOKING_MAX_SEATS": "20"}
+	loader, err := config.NewLoader[testConfig](config.WithEnv(env))
+	if err != nil {
+		t.Fatalf("new loader: %v", err)
+	}
+
+	// The values of the section are hot, unless marked otherwise.
+	env["BOOKING_MAX_SEATS"] = "30"
+	env["SERVER_TIMEOUT"] = "10s"
+	if _, err := loader.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if cfg := loader.Get(); cfg.Booking.MaxSeats != 30 || cfg.Server.Timeout != 10*time.Second {
+		t.Errorf("got max seats %d and timeout %v, want 30 and 10s", cfg.Booking.MaxSeats, cfg.Server.Timeout)
+	}
+
+	// The values of a restart section are kept.
+	env["SERVER_LISTEN"] = ":9090"
+	env["BOOKING_MAX_SEATS"] = "40"
+	_, err = loader.Reload()
+	var cfgErr *config.Error
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 || cfgErr.Problems[0].Path != "server.listen" {
+		t.Fatalf("got error %v, want a problem with server.listen", err)
+	}
+	if cfg := loader.Get(); cfg.Server.Listen != ":8080" || cfg.Booking.MaxSeats != 30 {
+		t.Errorf("got listen %q and max seats %d, want the previous values", cfg.Server.Listen, cfg.Booking.MaxSeats)
+	}
+}
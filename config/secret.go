@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eventscompass/service-framework/service"
+)
+
+// redacted replaces the values of secrets in logs and dumps.
+const redacted = "[REDACTED]"
+
+// Secret is a confidential value of the configuration, e.g. a password or a
+// private key. The value is never formatted, logged or dumped, see [Dump];
+// it is only returned by [Secret.Value].
+//
+// The value of a secret field is set like any other value, or is read from a
+// file, as done for Kubernetes and Docker secrets. The file is named by the
+// environment variable of the field with the "_FILE" suffix, e.g.
+// RABBITMQ_PASSWORD_FILE. When the file changes, e.g. because the secret is
+// rotated, the new value is returned by [Secret.Value]. Copies of a secret
+// share the value.
+type Secret struct {
+	s *secret
+}
+
+type secret struct {
+	mu      sync.Mutex
+	value   []byte
+	path    string    // the file from which the value is read, if any
+	modTime time.Time // the modification time of the file when last read
+	size    int64
+}
+
+// NewSecret creates a [Secret] with the given value.
+func NewSecret(value string) Secret {
+	if value == "" {
+		return Secret{}
+	}
+	return Secret{s: &secret{value: []byte(value)}}
+}
+
+// SecretFromFile creates a [Secret] whose value is read from the file, and
+// re-read whenever the file changes. Trailing newlines are removed from the
+// value. This function returns [service.ErrInvalidConfig] if the file cannot be
+// read.
+func SecretFromFile(path string) (Secret, error) {
+	s := &secret{path: path}
+	if err := s.load(); err != nil {
+		return Secret{}, fmt.Errorf("%w: read secret: %v", service.ErrInvalidConfig, err)
+	}
+	return Secret{s: s}, nil
+}
+
+// load reads the value from the file, if it changed since the last read.
+func (s *secret) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	if s.value != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+	value, err := os.ReadFile(s.path)
+	if err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	s.value = bytes.TrimRight(value, "\r\n")
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// Value returns the value of the secret. If the secret is read from a file
+// which changed, then the new value is returned. If the file cannot be read
+// anymore, the last value is returned.
+func (s Secret) Value() string {
+	return string(s.Bytes())
+}
+
+// Bytes returns the value of the secret, like [Secret.Value]. The returned
+// slice must not be modified.
+func (s Secret) Bytes() []byte {
+	if s.s == nil {
+		return nil
+	}
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	if s.s.path != "" {
+		_ = s.s.load() //nolint:errcheck // keep the last value
+	}
+	return s.s.value
+}
+
+// IsSet reports whether the secret has a value.
+func (s Secret) IsSet() bool {
+	return len(s.Bytes()) > 0
+}
+
+// String returns a placeholder instead of the value, or an empty string if the
+// secret has no value.
+func (s Secret) String() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return redacted
+}
+
+// GoString returns a placeholder instead of the value, for the %#v verb.
+func (s Secret) GoString() string {
+	return fmt.Sprintf("config.Secret(%q)", s.String())
+}
+
+// LogValue returns a placeholder instead of the value, see [slog.LogValuer].
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// MarshalText returns a placeholder instead of the value, so that the secret
+// is not leaked when the configuration is encoded, e.g. as json.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText sets the value of the secret.
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = NewSecret(string(text))
+	return nil
+}
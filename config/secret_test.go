@@ -0,0 +1,53 @@
+package config_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/config"
+	"github.com/eventscompass/service-framework/service"
+)
+
+func TestSecret(t *testing.T) {
+	file := writeFile(t, "password", "s3cret\n")
+	var cfg testConfig
+	if err := config.Load(&cfg, config.WithEnv(map[string]string{"BOOKING_PASSWORD_FILE": file})); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := cfg.Booking.Password.Value(); got != "s3cret" {
+		t.Errorf("got password %q, want s3cret", got)
+	}
+	for _, s := range []string{
+		fmt.Sprint(cfg.Booking.Password),
+		fmt.Sprintf("%#v", cfg.Booking.Password),
+		config.Dump(&cfg)["booking.password"],
+	} {
+		if strings.Contains(s, "s3cret") {
+			t.Errorf("secret leaked in %q", s)
+		}
+	}
+
+	// The rotated secret is read again.
+	later := time.Now().Add(time.Second)
+	if err := os.WriteFile(file, []byte("rotated"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("change file times: %v", err)
+	}
+	if got := cfg.Booking.Password.Value(); got != "rotated" {
+		t.Errorf("got password %q, want rotated", got)
+	}
+
+	err := config.Load(&cfg, config.WithEnv(map[string]string{
+		"BOOKING_PASSWORD":      "s3cret",
+		"BOOKING_PASSWORD_FILE": file,
+	}))
+	if !errors.Is(err, service.ErrInvalidConfig) {
+		t.Errorf("got error %v, want %v", err, service.ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// tree holds the values of a configuration struct, and the problems found
+// while loading them.
+type tree struct {
+	fields   []*field
+	byPath   map[string]*field
+	sections map[string]bool
+	problems []*Problem
+}
+
+// field is a value of the configuration.
+type field struct {
+	path     string // the dot separated path in the tree
+	env      string // the name of the environment variable
+	def      string // the default value
+	hasDef   bool
+	sep      string // the separator of list elements
+	validate string // the validation rules
+	value    reflect.Value
+
+	source  string // the source from which the value was set
+	failed  bool   // the value could not be parsed
+	restart bool   // changes require a restart, see [Loader]
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	secretType          = reflect.TypeOf(Secret{})
+)
+
+func newTree(v reflect.Value) *tree {
+	t := &tree{
+		byPath:   make(map[string]*field),
+		sections: make(map[string]bool),
+	}
+	t.walk(v, "", "", false)
+	return t
+}
+
+// walk adds the fields of the struct to the tree. Struct fields are sections,
+// unless they can be parsed from text, like [time.Time].
+func (t *tree) walk(v reflect.Value, prefix, envPrefix string, restart bool) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		key, tagged := sf.Tag.Lookup("config")
+		if !sf.IsExported() || key == "-" {
+			continue
+		}
+		if key == "" {
+			key = snakeCase(sf.Name)
+		}
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		fv := v.Field(i)
+		envPrefix := envPrefix + sf.Tag.Get("envPrefix")
+		restart := restart
+		switch sf.Tag.Get("reload") {
+		case "restart":
+			restart = true
+		case "hot":
+			restart = false
+		}
+
+		if fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct && !isText(fv.Type()) {
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && !isText(fv.Type()) {
+			if sf.Anonymous && !tagged {
+				t.walk(fv, prefix, envPrefix, restart)
+				continue
+			}
+			t.sections[path] = true
+			t.walk(fv, path, envPrefix, restart)
+			continue
+		}
+
+		f := &field{
+			path:     path,
+			validate: sf.Tag.Get("validate"),
+			value:    fv,
+			sep:      ",",
+			restart:  restart,
+		}
+		if name := sf.Tag.Get("env"); name != "" {
+			f.env = envPrefix + name
+		}
+		f.def, f.hasDef = sf.Tag.Lookup("envDefault")
+		if sep, ok := sf.Tag.Lookup("envSeparator"); ok {
+			f.sep = sep
+		}
+		t.fields = append(t.fields, f)
+		t.byPath[path] = f
+	}
+}
+
+// isText reports whether values of the type are parsed from text.
+func isText(typ reflect.Type) bool {
+	return typ.Implements(textUnmarshalerType) || reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
+
+// snakeCase converts a field name to snake case, keeping acronyms together,
+// e.g. "ReadHeaderTimeout" to "read_header_timeout" and "GRPCConfig" to
+// "grpc_config".
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// set parses the value of the field from text, and records the problem if it
+// cannot be parsed.
+func (t *tree) set(f *field, s, source string) {
+	f.source = source
+	if err := setText(f.value, s, f.sep); err != nil {
+		f.failed = true
+		t.problems = append(t.problems, &Problem{Path: f.path, Source: source, Err: err})
+		return
+	}
+	f.failed = false
+}
+
+func (t *tree) applyDefaults() {
+	for _, f := range t.fields {
+		if f.hasDef {
+			t.set(f, f.def, "default")
+		}
+	}
+}
+
+// applyEnv sets the values from the environment. The values of secrets can
+// also be read from the file named by the "_FILE" variable, see [Secret].
+func (t *tree) applyEnv(lookup func(string) (string, bool)) {
+	for _, f := range t.fields {
+		if f.env == "" {
+			continue
+		}
+		s, ok := lookup(f.env)
+		if f.value.Type() == secretType {
+			fileEnv := f.env + "_FILE"
+			if path, hasFile := lookup(fileEnv); hasFile {
+				if ok {
+					t.problems = append(t.problems, &Problem{
+						Path: f.path, Source: "env " + fileEnv,
+						Err: fmt.Errorf("both %s and %s are set", f.env, fileEnv),
+					})
+					continue
+				}
+				t.setSecretFile(f, path, "env "+fileEnv)
+				continue
+			}
+		}
+		if ok {
+			t.set(f, s, "env "+f.env)
+		}
+	}
+}
+
+// setSecretFile sets the secret field to be read from the file.
+func (t *tree) setSecretFile(f *field, path, source string) {
+	f.source = source
+	s := &secret{path: path}
+	if err := s.load(); err != nil {
+		f.failed = true
+		t.problems = append(t.problems, &Problem{Path: f.path, Source: source, Err: err})
+		return
+	}
+	f.value.Set(reflect.ValueOf(Secret{s: s}))
+	f.failed = false
+}
+
+// isDuration reports whether the value is a [time.Duration].
+func isDuration(v reflect.Value) bool {
+	return v.Type() == reflect.TypeOf(time.Duration(0))
+}
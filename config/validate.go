@@ -0,0 +1,16 @@
+package config
+
+import "github.com/eventscompass/service-framework/internal/validate"
+
+// validate checks the values against the rules of their fields, see
+// [validate.Rule]. Values that could not be parsed are not checked.
+func (t *tree) validate() {
+	for _, f := range t.fields {
+		if f.failed || f.validate == "" {
+			continue
+		}
+		if err := validate.Rules(f.value, f.validate); err != nil {
+			t.problems = append(t.problems, &Problem{Path: f.path, Source: f.source, Err: err})
+		}
+	}
+}
@@ -4,16 +4,18 @@ go 1.21.2
 
 require (
 	github.com/caarlos0/env/v6 v6.10.1
+	github.com/klauspost/compress v1.17.4
 	github.com/rabbitmq/amqp091-go v1.9.0
+	golang.org/x/net v0.14.0
 	golang.org/x/sync v0.4.0
 	golang.org/x/sys v0.13.0
 	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/golang/protobuf v1.5.3 // indirect
-	golang.org/x/net v0.14.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230822172742-b8732ec3820d // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
 )
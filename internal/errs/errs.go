@@ -0,0 +1,18 @@
+// Package errs defines the sentinel errors of the framework. They are exported
+// and documented by the service package, and live here so that the packages
+// imported by the service package, e.g. tracing, can return them as well.
+package errs
+
+import "errors"
+
+var (
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrBadRequest       = errors.New("bad request")
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrInvalidConfig    = errors.New("invalid config")
+	ErrNotAllowed       = errors.New("not allowed")
+	ErrNotFound         = errors.New("not found")
+	ErrSpaceFull        = errors.New("no space")
+	ErrTimeOut          = errors.New("time out")
+	ErrUnexpected       = errors.New("unexpected")
+)
@@ -0,0 +1,164 @@
+// Package validate checks configuration values against the rules of their
+// validate tags, e.g. `validate:"required,min=1"`. The rules are shared by the
+// service package, which validates the sections it parses from the
+// environment, and the config package, which validates the whole configuration
+// when it loads it.
+//
+// The supported rules are:
+//   - required: the value must not be zero, and secrets must be set
+//   - min=n, max=n: limits of numbers and durations, or of the length of
+//     strings, lists and maps
+//   - oneof=a b c: the value must be one of the space separated options
+//   - url: the value must be empty or an absolute url
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eventscompass/service-framework/internal/errs"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setter is implemented by values that are set without being non-zero, e.g.
+// config.Secret, whose value is hidden.
+type setter interface {
+	IsSet() bool
+}
+
+// Struct checks the fields of the struct to which cfg points against the rules
+// of their validate tags. The fields are named by their env tags in the
+// errors. This function returns [errs.ErrInvalidConfig] in case a value
+// breaks a rule.
+func Struct(cfg any) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		rules := sf.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		name := sf.Tag.Get("env")
+		if name == "" {
+			name = sf.Name
+		}
+		if err := Rules(v.Field(i), rules); err != nil {
+			return fmt.Errorf("%w: %s: %v", errs.ErrInvalidConfig, name, err)
+		}
+	}
+	return nil
+}
+
+// Rules checks the value against the comma separated rules, and returns the
+// error of the first rule that the value breaks.
+func Rules(v reflect.Value, rules string) error {
+	for _, rule := range strings.Split(rules, ",") {
+		if err := Rule(v, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rule checks the value against the rule, e.g. "min=1".
+func Rule(v reflect.Value, rule string) error {
+	name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+	switch name {
+	case "required":
+		if s, ok := v.Interface().(setter); (ok && !s.IsSet()) || v.IsZero() {
+			return errors.New("must be set")
+		}
+	case "min", "max":
+		return checkLimit(v, name, arg)
+	case "oneof":
+		s := text(v)
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if s == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(options, ", "), s)
+	case "url":
+		s := v.String()
+		if s == "" {
+			return nil
+		}
+		if u, err := url.Parse(s); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("must be an absolute url, got %q", s)
+		}
+	default:
+		return fmt.Errorf("unknown validation rule %q", name)
+	}
+	return nil
+}
+
+// checkLimit checks the value against the min or max limit. The limits of
+// strings, lists and maps apply to their length.
+func checkLimit(v reflect.Value, name, arg string) error {
+	var below, above bool
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			limit, err := time.ParseDuration(arg)
+			if err != nil {
+				return fmt.Errorf("invalid %s rule: %v", name, err)
+			}
+			below, above = v.Int() < int64(limit), v.Int() > int64(limit)
+			break
+		}
+		limit, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s rule: %v", name, err)
+		}
+		below, above = v.Int() < limit, v.Int() > limit
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		limit, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s rule: %v", name, err)
+		}
+		below, above = v.Uint() < limit, v.Uint() > limit
+	case reflect.Float32, reflect.Float64:
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s rule: %v", name, err)
+		}
+		below, above = v.Float() < limit, v.Float() > limit
+	case reflect.String, reflect.Slice, reflect.Map:
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid %s rule: %v", name, err)
+		}
+		if name == "min" && v.Len() < limit {
+			return fmt.Errorf("must have at least %d elements, got %d", limit, v.Len())
+		}
+		if name == "max" && v.Len() > limit {
+			return fmt.Errorf("must have at most %d elements, got %d", limit, v.Len())
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s rule not supported for %s", name, v.Type())
+	}
+
+	if name == "min" && below {
+		return fmt.Errorf("must be at least %s, got %s", arg, text(v))
+	}
+	if name == "max" && above {
+		return fmt.Errorf("must be at most %s, got %s", arg, text(v))
+	}
+	return nil
+}
+
+// text formats the value for the validation messages.
+func text(v reflect.Value) string {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,84 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/internal/errs"
+)
+
+// secret is set without being non-zero, like config.Secret.
+type secret struct {
+	value *string
+}
+
+func (s secret) IsSet() bool { return s.value != nil && *s.value != "" }
+
+func TestStruct(t *testing.T) {
+	type config struct {
+		Listen   string        `env:"LISTEN" validate:"required"`
+		Timeout  time.Duration `env:"TIMEOUT" validate:"min=1s,max=1m"`
+		Workers  int           `env:"WORKERS" validate:"min=1"`
+		Retries  uint          `env:"RETRIES" validate:"max=5"`
+		Ratio    float64       `validate:"max=1"`
+		Format   string        `env:"FORMAT" validate:"oneof=json text"`
+		Webhook  string        `env:"WEBHOOK" validate:"url"`
+		Name     string        `env:"NAME" validate:"min=2,max=4"`
+		Hosts    []string      `env:"HOSTS" validate:"min=1"`
+		Password secret        `env:"PASSWORD" validate:"required"`
+	}
+	password := "secret"
+	valid := config{
+		Listen:   ":8080",
+		Timeout:  time.Second,
+		Workers:  1,
+		Ratio:    0.5,
+		Format:   "json",
+		Name:     "abc",
+		Hosts:    []string{"a"},
+		Password: secret{value: &password},
+	}
+	if err := Struct(&valid); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+
+	empty := ""
+	for want, change := range map[string]func(*config){
+		"LISTEN: must be set":                 func(c *config) { c.Listen = "" },
+		"TIMEOUT: must be at least":           func(c *config) { c.Timeout = time.Millisecond },
+		"TIMEOUT: must be at most":            func(c *config) { c.Timeout = time.Hour },
+		"WORKERS: must be at least 1":         func(c *config) { c.Workers = 0 },
+		"RETRIES: must be at most 5":          func(c *config) { c.Retries = 6 },
+		"Ratio: must be at most 1":            func(c *config) { c.Ratio = 2 },
+		"FORMAT: must be one of":              func(c *config) { c.Format = "yaml" },
+		"WEBHOOK: must be an absolute":        func(c *config) { c.Webhook = "/hooks" },
+		"NAME: must have at least 2 elements": func(c *config) { c.Name = "a" },
+		"NAME: must have at most 4 elements":  func(c *config) { c.Name = "abcde" },
+		"HOSTS: must have at least 1":         func(c *config) { c.Hosts = nil },
+		"PASSWORD: must be set":               func(c *config) { c.Password = secret{value: &empty} },
+	} {
+		cfg := valid
+		change(&cfg)
+		err := Struct(&cfg)
+		if !errors.Is(err, errs.ErrInvalidConfig) || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+}
+
+func TestRuleErrors(t *testing.T) {
+	for rule, want := range map[string]string{
+		"min=x":   "invalid min rule",
+		"between": `unknown validation rule "between"`,
+	} {
+		if err := Rule(reflect.ValueOf(1), rule); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", rule, err, want)
+		}
+	}
+	if err := Rule(reflect.ValueOf(true), "min=1"); err == nil {
+		t.Error("min rule accepted for a bool")
+	}
+}
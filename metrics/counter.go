@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Counter is a metric whose value can only increase, e.g. the number of served
+// requests.
+type Counter struct {
+	desc
+	vec vec[*counterValue]
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.vec.with(labelValues).add(1)
+}
+
+// Add increases the counter by v. This function panics if v is negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease in value")
+	}
+	c.vec.with(labelValues).add(v)
+}
+
+func (c *Counter) collect(e *encoder) {
+	e.header(&c.desc)
+	c.vec.each(func(labelValues []string, v *counterValue) {
+		e.sample(c.name, c.labels, labelValues, "", "", v.load())
+	})
+}
+
+// counterValue is a float64 that can be updated atomically.
+type counterValue struct {
+	bits atomic.Uint64
+}
+
+func (v *counterValue) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if v.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func (v *counterValue) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteTo writes all the metrics of the registry to w using the Prometheus text
+// exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	e := &encoder{w: bufio.NewWriter(cw)}
+	for _, c := range collectors {
+		c.collect(e)
+	}
+	if err := e.w.Flush(); err != nil {
+		return cw.n, fmt.Errorf("write metrics: %w", err)
+	}
+	return cw.n, nil
+}
+
+// Handler returns an [http.Handler] that serves the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = r.WriteTo(w) //nolint:errcheck // the client went away
+	})
+}
+
+// Handler returns an [http.Handler] that serves the metrics of the
+// [DefaultRegistry].
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// encoder writes metrics in the Prometheus text exposition format.
+type encoder struct {
+	w *bufio.Writer
+}
+
+func (e *encoder) header(d *desc) {
+	if d.help != "" {
+		fmt.Fprintf(e.w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	}
+	fmt.Fprintf(e.w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// sample writes a single sample. The extra label is appended to the labels of
+// the metric, unless its name is empty.
+func (e *encoder) sample(
+	name string,
+	labels []string,
+	labelValues []string,
+	extraLabel string,
+	extraValue string,
+	v float64,
+) {
+	e.w.WriteString(name) //nolint:errcheck // checked on flush
+	if len(labels) > 0 || extraLabel != "" {
+		e.w.WriteByte('{') //nolint:errcheck // checked on flush
+		for i, l := range labels {
+			if i > 0 {
+				e.w.WriteByte(',') //nolint:errcheck // checked on flush
+			}
+			fmt.Fprintf(e.w, "%s=\"%s\"", l, valueEscaper.Replace(labelValues[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				e.w.WriteByte(',') //nolint:errcheck // checked on flush
+			}
+			fmt.Fprintf(e.w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		e.w.WriteByte('}') //nolint:errcheck // checked on flush
+	}
+	e.w.WriteByte(' ')              //nolint:errcheck // checked on flush
+	e.w.WriteString(formatFloat(v)) //nolint:errcheck // checked on flush
+	e.w.WriteByte('\n')             //nolint:errcheck // checked on flush
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err //nolint:wrapcheck // intentional
+}
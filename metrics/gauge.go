@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"math"
+)
+
+// Gauge is a metric whose value can go up and down, e.g. the number of open
+// connections.
+type Gauge struct {
+	desc
+	vec vec[*gaugeValue]
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.vec.with(labelValues).bits.Store(math.Float64bits(v))
+}
+
+// Add adds v to the gauge. The value of v can be negative.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.vec.with(labelValues).add(v)
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.vec.with(labelValues).add(1)
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.vec.with(labelValues).add(-1)
+}
+
+func (g *Gauge) collect(e *encoder) {
+	e.header(&g.desc)
+	g.vec.each(func(labelValues []string, v *gaugeValue) {
+		e.sample(g.name, g.labels, labelValues, "", "", v.load())
+	})
+}
+
+// gaugeValue shares the implementation of the counter value, but may also be
+// decreased.
+type gaugeValue = counterValue
+
+// gaugeFunc is a gauge without labels whose value is computed on collection.
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func (g *gaugeFunc) collect(e *encoder) {
+	e.header(&g.desc)
+	e.sample(g.name, nil, nil, "", "", g.fn())
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets. They are tailored to measure
+// the latency of network requests in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram is a metric that samples observations, e.g. request durations, and
+// counts them in configurable buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+	vec     vec[*histogramValue]
+}
+
+func newHistogram(d desc, buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of histogram %q are not sorted", d.name))
+	}
+	// The +Inf bucket is implicit.
+	if n := len(buckets); n > 0 && math.IsInf(buckets[n-1], +1) {
+		buckets = buckets[:n-1]
+	}
+
+	h := &Histogram{desc: d, buckets: buckets}
+	h.vec.init(len(d.labels), func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(buckets))}
+	})
+	return h
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	hv := h.vec.with(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if i < len(hv.counts) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) collect(e *encoder) {
+	e.header(&h.desc)
+	h.vec.each(func(labelValues []string, hv *histogramValue) {
+		hv.mu.Lock()
+		counts := append([]uint64(nil), hv.counts...)
+		count, sum := hv.count, hv.sum
+		hv.mu.Unlock()
+
+		// Buckets are cumulative in the exposition format.
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += counts[i]
+			e.sample(h.name+"_bucket", h.labels, labelValues,
+				"le", formatFloat(upper), float64(cumulative))
+		}
+		e.sample(h.name+"_bucket", h.labels, labelValues, "le", "+Inf", float64(count))
+		e.sample(h.name+"_sum", h.labels, labelValues, "", "", sum)
+		e.sample(h.name+"_count", h.labels, labelValues, "", "", float64(count))
+	})
+}
+
+type histogramValue struct {
+	mu     sync.Mutex
+	counts []uint64 // non-cumulative count per bucket
+	count  uint64
+	sum    float64
+}
@@ -0,0 +1,214 @@
+// Package metrics implements counters, gauges and histograms, which can be
+// exposed to a Prometheus server using the text exposition format.
+//
+// Metrics are usually created once during package initialization and
+// registered with the [DefaultRegistry]:
+//
+//	var requests = metrics.NewCounter(
+//		"http_requests_total", "Total number of http requests.", "method", "code")
+//
+//	requests.Inc(r.Method, "200")
+//
+// Every metric declares the names of its labels on creation. The label values
+// are passed, in the same order, whenever the metric is updated.
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultRegistry is the registry used by the package level constructors. The
+// framework registers the metrics of its own components with this registry.
+var DefaultRegistry = NewRegistry()
+
+// NewCounter creates a new [Counter] and registers it with the
+// [DefaultRegistry].
+func NewCounter(name, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewGauge creates a new [Gauge] and registers it with the [DefaultRegistry].
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labels...)
+}
+
+// NewGaugeFunc creates a new gauge whose value is computed by calling fn on
+// every collection, and registers it with the [DefaultRegistry].
+func NewGaugeFunc(name, help string, fn func() float64) {
+	DefaultRegistry.NewGaugeFunc(name, help, fn)
+}
+
+// NewHistogram creates a new [Histogram] and registers it with the
+// [DefaultRegistry]. If buckets is nil then [DefBuckets] are used.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+// collector is implemented by all metric types that can be registered.
+type collector interface {
+	// collect writes all the samples of the metric.
+	collect(e *encoder)
+}
+
+// Registry holds a set of metrics with unique names.
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]struct{}
+	collectors []collector
+}
+
+// NewRegistry creates a new empty [Registry].
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{})}
+}
+
+// NewCounter creates a new [Counter] and registers it with the registry. This
+// function panics if the name or the labels are invalid, or if a metric with
+// the same name is already registered.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: newDesc(name, help, "counter", labels)}
+	c.vec.init(len(labels), func() *counterValue { return new(counterValue) })
+	r.register(name, c)
+	return c
+}
+
+// NewGauge creates a new [Gauge] and registers it with the registry. This
+// function panics if the name or the labels are invalid, or if a metric with
+// the same name is already registered.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: newDesc(name, help, "gauge", labels)}
+	g.vec.init(len(labels), func() *gaugeValue { return new(gaugeValue) })
+	r.register(name, g)
+	return g
+}
+
+// NewGaugeFunc creates a new gauge whose value is computed by calling fn on
+// every collection, and registers it with the registry. This function panics if
+// the name is invalid, or if a metric with the same name is already registered.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{desc: newDesc(name, help, "gauge", nil), fn: fn})
+}
+
+// NewHistogram creates a new [Histogram] and registers it with the registry. If
+// buckets is nil then [DefBuckets] are used. This function panics if the name,
+// the labels or the buckets are invalid, or if a metric with the same name is
+// already registered.
+func (r *Registry) NewHistogram(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) *Histogram {
+	h := newHistogram(newDesc(name, help, "histogram", labels), buckets)
+	r.register(name, h)
+	return h
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.names[name]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.names[name] = struct{}{}
+	r.collectors = append(r.collectors, c)
+}
+
+var (
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// desc describes a metric.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func newDesc(name, help, typ string, labels []string) desc {
+	if !nameRe.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !labelRe.MatchString(l) || strings.HasPrefix(l, "__") || l == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q for metric %q", l, name))
+		}
+	}
+	return desc{name: name, help: help, typ: typ, labels: labels}
+}
+
+// vec holds the values of a metric for every combination of label values.
+type vec[T any] struct {
+	size  int
+	newFn func() T
+
+	mu     sync.RWMutex
+	values map[string]*labeled[T]
+}
+
+type labeled[T any] struct {
+	labelValues []string
+	value       T
+}
+
+func (v *vec[T]) init(size int, newFn func() T) {
+	v.size = size
+	v.newFn = newFn
+	v.values = make(map[string]*labeled[T])
+}
+
+// with returns the value for the given label values, creating it if needed.
+// This function panics if the number of label values is wrong, because that is
+// a programming error.
+func (v *vec[T]) with(labelValues []string) T {
+	if len(labelValues) != v.size {
+		panic(fmt.Sprintf(
+			"metrics: expected %d label values, got %d", v.size, len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	l, ok := v.values[key]
+	v.mu.RUnlock()
+	if ok {
+		return l.value
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if l, ok := v.values[key]; ok {
+		return l.value
+	}
+	l = &labeled[T]{
+		labelValues: append([]string(nil), labelValues...),
+		value:       v.newFn(),
+	}
+	v.values[key] = l
+	return l.value
+}
+
+// each calls fn for every set of label values in a deterministic order.
+func (v *vec[T]) each(fn func(labelValues []string, value T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]*labeled[T], 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, v.values[k])
+	}
+	v.mu.RUnlock()
+
+	for _, e := range entries {
+		fn(e.labelValues, e.value)
+	}
+}
@@ -0,0 +1,169 @@
+package metrics_test
+
+import (
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eventscompass/service-framework/metrics"
+)
+
+func expose(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("write metrics: %v", err)
+	}
+	if int(n) != b.Len() {
+		t.Errorf("got %d written bytes, want %d", n, b.Len())
+	}
+	return b.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounter("http_requests_total", "Total number of http requests.", "method", "code")
+	inflight := r.NewGauge("http_requests_inflight", "")
+	r.NewGaugeFunc("build_info", "Line one\nwith a \\ backslash.", func() float64 { return 1 })
+
+	requests.Inc("POST", "500")
+	requests.Add(2.5, "GET", "200")
+	requests.Inc("GET", "200")
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+	inflight.Add(-0.5)
+
+	// The metrics are written in the order of registration, and the samples
+	// of a metric are sorted by their label values.
+	want := `# HELP http_requests_total Total number of http requests.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",code="200"} 3.5
+http_requests_total{method="POST",code="500"} 1
+# TYPE http_requests_inflight gauge
+http_requests_inflight 0.5
+# HELP build_info Line one\nwith a \\ backslash.
+# TYPE build_info gauge
+build_info 1
+`
+	if got := expose(t, r); got != want {
+		t.Errorf("got exposition\n%s\nwant\n%s", got, want)
+	}
+
+	inflight.Set(math.Inf(+1))
+	if got := expose(t, r); !strings.Contains(got, "http_requests_inflight +Inf\n") {
+		t.Errorf("got exposition\n%s", got)
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.NewCounter("errors_total", "", "error")
+	c.Inc("a \"quoted\"\nmulti-line \\ value")
+
+	want := `# TYPE errors_total counter
+errors_total{error="a \"quoted\"\nmulti-line \\ value"} 1
+`
+	if got := expose(t, r); got != want {
+		t.Errorf("got exposition\n%s\nwant\n%s", got, want)
+	}
+
+	for name, fn := range map[string]func(){
+		"too few values":   func() { c.Inc() },
+		"too many values":  func() { c.Inc("a", "b") },
+		"negative counter": func() { c.Add(-1, "a") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogram("request_duration_seconds", "Request duration.",
+		[]float64{0.1, 1, math.Inf(+1)}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.Observe(v, "/events")
+	}
+
+	// The buckets are cumulative, an observation equal to an upper bound
+	// falls in that bucket, and the +Inf bucket is not duplicated.
+	want := `# HELP request_duration_seconds Request duration.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/events",le="0.1"} 2
+request_duration_seconds_bucket{route="/events",le="1"} 3
+request_duration_seconds_bucket{route="/events",le="+Inf"} 4
+request_duration_seconds_sum{route="/events"} 2.65
+request_duration_seconds_count{route="/events"} 4
+`
+	if got := expose(t, r); got != want {
+		t.Errorf("got exposition\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramDefBuckets(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewHistogram("latency_seconds", "", nil).Observe(0.3)
+
+	got := expose(t, r)
+	if n := strings.Count(got, "latency_seconds_bucket{"); n != len(metrics.DefBuckets)+1 {
+		t.Errorf("got %d buckets, want %d", n, len(metrics.DefBuckets)+1)
+	}
+	for _, line := range []string{
+		`latency_seconds_bucket{le="0.25"} 0`,
+		`latency_seconds_bucket{le="0.5"} 1`,
+		`latency_seconds_bucket{le="+Inf"} 1`,
+		`latency_seconds_sum 0.3`,
+		`latency_seconds_count 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %q in exposition\n%s", line, got)
+		}
+	}
+}
+
+func TestInvalidMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("registered_total", "")
+
+	for name, fn := range map[string]func(){
+		"duplicate name":   func() { r.NewGauge("registered_total", "") },
+		"invalid name":     func() { r.NewCounter("1st_total", "") },
+		"invalid label":    func() { r.NewCounter("a_total", "", "has-dash") },
+		"reserved label":   func() { r.NewCounter("b_total", "", "__name") },
+		"le label":         func() { r.NewHistogram("c_seconds", "", nil, "le") },
+		"unsorted buckets": func() { r.NewHistogram("d_seconds", "", []float64{1, 0.5}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("hits_total", "").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Errorf("got content type %q, want %q", got, metrics.ContentType)
+	}
+	if body, _ := io.ReadAll(rec.Body); !strings.Contains(string(body), "hits_total 1\n") {
+		t.Errorf("got body %q", body)
+	}
+}
@@ -0,0 +1,409 @@
+// Package envelope protects the messages of a [service.MessageBus] against
+// anyone with access to the message broker. Every message is wrapped in a
+// signed envelope, and its body is optionally encrypted:
+//
+//	bus, err := envelope.New(rabbitBus,
+//		envelope.WithSigningKeys(envelope.HMACKey("2024-06", cfg.SigningKey.Bytes())),
+//		envelope.WithEncryptionKeys(envelope.AESKey("2024-06", cfg.EncryptionKey.Bytes())),
+//	)
+//
+// The received messages are passed to the event handlers only if their
+// signature is valid and their body can be decrypted. Unsigned and tampered
+// messages are rejected and logged. The headers of the messages, see
+// [service.WithHeader], are signed as well. The handlers receive only the
+// signed headers, see [service.DeliveryFromContext].
+//
+// Keys are rotated by adding the new key after the current one on all
+// services, then moving it to the front once all services know it, and
+// finally removing the old key. The first key signs and encrypts the
+// published messages, while all keys are accepted for the received messages,
+// which name their keys by id in the header of the envelope.
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/eventscompass/service-framework/metrics"
+	"github.com/eventscompass/service-framework/service"
+)
+
+// ErrInvalidMessage is returned when a received message is not a valid
+// envelope, e.g. it is not signed or it was tampered with.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// version is the version of the format of the envelopes.
+const version = 1
+
+var rejectedMessages = metrics.NewCounter(
+	"envelope_rejected_messages_total",
+	"Total number of received messages that were rejected because they were not signed, or invalid.",
+	"topic",
+)
+
+// envelope is the message sent over the bus. The signature covers the header,
+// exactly as it was sent, and the payload.
+type envelope struct {
+	Header    json.RawMessage `json:"header"`
+	Payload   []byte          `json:"payload"`
+	Signature []byte          `json:"signature"`
+}
+
+// header describes how the payload of an envelope is protected.
+type header struct {
+	Version int `json:"v"`
+
+	// Topic is the topic to which the message was published,
+	// which prevents replaying the message to another topic.
+	Topic string `json:"topic"`
+
+	// Reply is set for the replies to requests, and Error if the
+	// payload is a [service.ReplyError].
+	Reply bool `json:"reply,omitempty"`
+	Error bool `json:"error,omitempty"`
+
+	// Headers are the headers set with [service.WithHeader].
+	Headers map[string]string `json:"headers,omitempty"`
+
+	SignatureAlg string `json:"sig_alg"`
+	SignatureKey string `json:"sig_kid"`
+
+	// Encryption is empty if the payload is not encrypted.
+	Encryption    string `json:"enc,omitempty"`
+	EncryptionKey string `json:"enc_kid,omitempty"`
+	Nonce         []byte `json:"nonce,omitempty"`
+}
+
+// Option configures a [Bus].
+type Option func(*Bus)
+
+// WithSigningKeys sets the keys with which the messages are signed. The first
+// key that can sign, i.e. that is not created with [Ed25519PublicKey], signs
+// the published messages. All keys verify the received messages.
+func WithSigningKeys(keys ...SigningKey) Option {
+	return func(b *Bus) {
+		b.signingKeys = append(b.signingKeys, keys...)
+	}
+}
+
+// WithEncryptionKeys enables the encryption of the bodies of the messages. The
+// first key encrypts the published messages. All keys decrypt the received
+// messages. Once enabled, received messages which are not encrypted are
+// rejected.
+func WithEncryptionKeys(keys ...EncryptionKey) Option {
+	return func(b *Bus) {
+		b.encryptionKeys = append(b.encryptionKeys, keys...)
+	}
+}
+
+// Bus is a [service.MessageBus] which wraps the messages of another bus in
+// signed, and optionally encrypted, envelopes.
+type Bus struct {
+	bus            service.MessageBus
+	signingKeys    []SigningKey
+	encryptionKeys []EncryptionKey
+
+	signer     *SigningKey // nil if no key can sign
+	verifiers  map[string]*SigningKey
+	decrypters map[string]*EncryptionKey
+}
+
+var (
+	_ service.MessageBus     = (*Bus)(nil)
+	_ service.Requester      = (*Bus)(nil)
+	_ service.BatchPublisher = (*Bus)(nil)
+)
+
+// New creates a [Bus] which sends the envelopes over bus. At least one signing
+// key is required. This function returns [service.ErrInvalidConfig] in case
+// there is no signing key, or any of the keys is not valid.
+func New(bus service.MessageBus, opts ...Option) (*Bus, error) {
+	b := &Bus{
+		bus:        bus,
+		verifiers:  make(map[string]*SigningKey),
+		decrypters: make(map[string]*EncryptionKey),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if len(b.signingKeys) == 0 {
+		return nil, fmt.Errorf("%w: no signing key", service.ErrInvalidConfig)
+	}
+	for i := range b.signingKeys {
+		k := &b.signingKeys[i]
+		if err := k.validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", service.ErrInvalidConfig, err)
+		}
+		if _, ok := b.verifiers[k.id]; ok || k.id == "" {
+			return nil, fmt.Errorf("%w: signing key id %q is empty or not unique", service.ErrInvalidConfig, k.id)
+		}
+		b.verifiers[k.id] = k
+		if b.signer == nil && k.canSign() {
+			b.signer = k
+		}
+	}
+	for i := range b.encryptionKeys {
+		k := &b.encryptionKeys[i]
+		if err := k.validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", service.ErrInvalidConfig, err)
+		}
+		if _, ok := b.decrypters[k.id]; ok || k.id == "" {
+			return nil, fmt.Errorf("%w: encryption key id %q is empty or not unique", service.ErrInvalidConfig, k.id)
+		}
+		b.decrypters[k.id] = k
+	}
+	return b, nil
+}
+
+// Publish wraps the message in an envelope and publishes it on the underlying
+// bus. This function returns [service.ErrNotAllowed] in case none of the
+// signing keys can sign. This function returns the errors of the underlying
+// bus.
+func (b *Bus) Publish(ctx context.Context, topic string, msg []byte, opts ...service.PublishOption) error {
+	hdr := header{Topic: topic, Headers: service.NewPublishOptions(opts...).Headers}
+	sealed, err := b.seal(hdr, msg)
+	if err != nil {
+		return err
+	}
+	return b.bus.Publish(ctx, topic, sealed, opts...) //nolint:wrapcheck // intentional
+}
+
+// PublishBatch wraps the messages in envelopes and publishes them on the
+// underlying bus, see [service.PublishBatch]. This function returns
+// [service.BatchError] in case any of the messages could not be published.
+func (b *Bus) PublishBatch(ctx context.Context, msgs []service.Message) error {
+	sealed := make([]service.Message, len(msgs))
+	for i, m := range msgs {
+		hdr := header{Topic: m.Topic, Headers: service.NewPublishOptions(m.Options...).Headers}
+		body, err := b.seal(hdr, m.Body)
+		if err != nil {
+			errs := make([]error, len(msgs))
+			for j := range errs {
+				errs[j] = err
+			}
+			return service.NewBatchError(errs)
+		}
+		sealed[i] = service.Message{Topic: m.Topic, Body: body, Options: m.Options}
+	}
+	return service.PublishBatch(ctx, b.bus, sealed)
+}
+
+// Subscribe subscribes to the given topic on the underlying bus. The messages
+// are passed to the event handler once their envelopes are opened. Messages
+// which are not signed with one of the signing keys, cannot be decrypted, or
+// whose headers differ from the signed headers, are logged and dropped. This
+// function returns the errors of the underlying bus.
+func (b *Bus) Subscribe(ctx context.Context, topic string, h service.EventHandler) error {
+	return b.bus.Subscribe(ctx, topic, func(ctx context.Context, data []byte) { //nolint:wrapcheck // intentional
+		// The topic and the headers of the message are checked if the bus
+		// provides them.
+		d, _ := service.DeliveryFromContext(ctx)
+		want := d.Topic
+		hdr, msg, err := b.open(data, want, false)
+		if err == nil {
+			err = checkHeaders(d.Headers, hdr.Headers)
+		}
+		if err != nil {
+			slog.Warn("rejected message",
+				slog.String("topic", want),
+				slog.String("error", err.Error()),
+			)
+			rejectedMessages.Inc(topic)
+			return
+		}
+		// Only the signed headers are passed on, so that headers added on
+		// the way cannot be mistaken for the headers of the publisher.
+		d.Topic, d.Headers = hdr.Topic, hdr.Headers
+		ctx = service.ContextWithDelivery(ctx, d)
+		if reply, ok := service.ReplyFromContext(ctx); ok {
+			ctx = service.ContextWithReply(ctx, b.replyFunc(hdr.Topic, reply))
+		}
+		h(ctx, msg)
+	})
+}
+
+// checkHeaders checks that the headers received with the message match the
+// signed headers. Headers which are not signed are ignored, as the underlying
+// bus may add headers of its own. This function returns [ErrInvalidMessage] in
+// case a signed header is missing or was changed.
+func checkHeaders(received, signed map[string]string) error {
+	if received == nil {
+		return nil // the underlying bus does not provide the headers
+	}
+	for k, v := range signed {
+		if received[k] != v {
+			return fmt.Errorf("%w: header %q does not match the signed header", ErrInvalidMessage, k)
+		}
+	}
+	return nil
+}
+
+// Request wraps the request in an envelope and sends it with the underlying
+// bus, and opens the envelope of the response. This function returns
+// [service.ErrNotAllowed] in case the underlying bus does not implement
+// [service.Requester], or none of the signing keys can sign. This function
+// returns [ErrInvalidMessage] in case the envelope of the response is not
+// valid. This function returns the errors of the underlying bus, and
+// [service.ReplyError] in case the request handler failed.
+func (b *Bus) Request(ctx context.Context, topic string, msg []byte) ([]byte, error) {
+	r, ok := b.bus.(service.Requester)
+	if !ok {
+		return nil, fmt.Errorf("%w: the bus does not support requests", service.ErrNotAllowed)
+	}
+	sealed, err := b.seal(header{Topic: topic}, msg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Request(ctx, topic, sealed)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // intentional
+	}
+
+	hdr, resp, err := b.open(resp, topic, true)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Error {
+		var replyErr service.ReplyError
+		if err := json.Unmarshal(resp, &replyErr); err != nil {
+			return nil, fmt.Errorf("%w: decode error: %v", ErrInvalidMessage, err)
+		}
+		return nil, &replyErr
+	}
+	return resp, nil
+}
+
+// replyFunc returns the function which wraps the replies to a request in
+// envelopes, before they are sent with reply. Errors are sent in the envelope
+// as well, so that they cannot be forged either.
+func (b *Bus) replyFunc(topic string, reply service.ReplyFunc) service.ReplyFunc {
+	return func(ctx context.Context, resp []byte, err error) error {
+		hdr := header{Topic: topic, Reply: true}
+		if err != nil {
+			hdr.Error = true
+			resp, _ = json.Marshal(service.NewReplyError(err)) //nolint:errcheck // strings only
+		}
+		sealed, err := b.seal(hdr, resp)
+		if err != nil {
+			return err
+		}
+		return reply(ctx, sealed, nil)
+	}
+}
+
+// Close closes the underlying bus.
+func (b *Bus) Close() error {
+	return b.bus.Close() //nolint:wrapcheck // intentional
+}
+
+// seal wraps the message in an envelope with the header. The payload is
+// encrypted first, and then signed together with the header.
+func (b *Bus) seal(hdr header, msg []byte) ([]byte, error) {
+	if b.signer == nil {
+		return nil, fmt.Errorf("%w: no signing key can sign", service.ErrNotAllowed)
+	}
+	hdr.Version = version
+	hdr.SignatureAlg = b.signer.alg
+	hdr.SignatureKey = b.signer.id
+
+	var enc *EncryptionKey
+	if len(b.encryptionKeys) > 0 {
+		enc = &b.encryptionKeys[0]
+		hdr.Encryption = algAESGCM
+		hdr.EncryptionKey = enc.id
+		hdr.Nonce = make([]byte, enc.aead.NonceSize())
+		if _, err := rand.Read(hdr.Nonce); err != nil {
+			return nil, fmt.Errorf("%w: generate nonce: %v", service.ErrUnexpected, err)
+		}
+	}
+	rawHeader, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: encode header: %v", service.ErrUnexpected, err)
+	}
+
+	payload := msg
+	if enc != nil {
+		// The header is authenticated with the payload.
+		payload = enc.aead.Seal(nil, hdr.Nonce, msg, rawHeader)
+	}
+	env := envelope{
+		Header:    rawHeader,
+		Payload:   payload,
+		Signature: b.signer.sign(signedData(rawHeader, payload)),
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("%w: encode envelope: %v", service.ErrUnexpected, err)
+	}
+	return data, nil
+}
+
+// open verifies the envelope and returns its header and the decrypted payload.
+// The topic is checked unless it is empty, and the envelope must be a reply if
+// and only if reply is set. This function returns [ErrInvalidMessage] in case
+// the envelope is not valid.
+func (b *Bus) open(data []byte, topic string, reply bool) (*header, []byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil || len(env.Header) == 0 {
+		return nil, nil, fmt.Errorf("%w: not an envelope", ErrInvalidMessage)
+	}
+	if len(env.Signature) == 0 {
+		return nil, nil, fmt.Errorf("%w: not signed", ErrInvalidMessage)
+	}
+	var hdr header
+	if err := json.Unmarshal(env.Header, &hdr); err != nil {
+		return nil, nil, fmt.Errorf("%w: decode header: %v", ErrInvalidMessage, err)
+	}
+
+	key, ok := b.verifiers[hdr.SignatureKey]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidMessage, hdr.SignatureKey)
+	}
+	if key.alg != hdr.SignatureAlg || !key.verify(signedData(env.Header, env.Payload), env.Signature) {
+		return nil, nil, fmt.Errorf("%w: invalid signature", ErrInvalidMessage)
+	}
+
+	// The header can be trusted from here on.
+	switch {
+	case hdr.Version != version:
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidMessage, hdr.Version)
+	case hdr.Reply != reply:
+		return nil, nil, fmt.Errorf("%w: unexpected reply", ErrInvalidMessage)
+	case topic != "" && hdr.Topic != topic:
+		return nil, nil, fmt.Errorf("%w: published to topic %q", ErrInvalidMessage, hdr.Topic)
+	}
+
+	if hdr.Encryption == "" {
+		if len(b.decrypters) > 0 {
+			return nil, nil, fmt.Errorf("%w: not encrypted", ErrInvalidMessage)
+		}
+		return &hdr, env.Payload, nil
+	}
+	enc, ok := b.decrypters[hdr.EncryptionKey]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown encryption key %q", ErrInvalidMessage, hdr.EncryptionKey)
+	}
+	if hdr.Encryption != algAESGCM || len(hdr.Nonce) != enc.aead.NonceSize() {
+		return nil, nil, fmt.Errorf("%w: unsupported encryption %q", ErrInvalidMessage, hdr.Encryption)
+	}
+	msg, err := enc.aead.Open(nil, hdr.Nonce, env.Payload, env.Header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: decrypt: %v", ErrInvalidMessage, err)
+	}
+	return &hdr, msg, nil
+}
+
+// signedData returns the data which is signed, i.e. the length of the header,
+// the header and the payload.
+func signedData(rawHeader, payload []byte) []byte {
+	data := make([]byte, 0, 8+len(rawHeader)+len(payload)) //nolint:gomnd // size of the length
+	data = binary.BigEndian.AppendUint64(data, uint64(len(rawHeader)))
+	data = append(data, rawHeader...)
+	return append(data, payload...)
+}
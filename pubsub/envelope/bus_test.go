@@ -0,0 +1,307 @@
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub/envelope"
+	"github.com/eventscompass/service-framework/pubsub/memory"
+	"github.com/eventscompass/service-framework/service"
+)
+
+var (
+	hmacSecret = bytes.Repeat([]byte("k"), 32)
+	aesKey     = bytes.Repeat([]byte("a"), 32)
+)
+
+type received struct {
+	msg     []byte
+	headers map[string]string
+}
+
+// tamperBus changes the messages published on the in-memory bus.
+type tamperBus struct {
+	*memory.Bus
+	tamper func(topic string, msg []byte, opts []service.PublishOption) (string, []byte, []service.PublishOption)
+}
+
+func (b *tamperBus) Publish(ctx context.Context, topic string, msg []byte, opts ...service.PublishOption) error {
+	topic, msg, opts = b.tamper(topic, msg, opts)
+	return b.Bus.Publish(ctx, topic, msg, opts...) //nolint:wrapcheck // intentional
+}
+
+func newBus(t *testing.T, bus service.MessageBus, opts ...envelope.Option) *envelope.Bus {
+	t.Helper()
+	b, err := envelope.New(bus, opts...)
+	if err != nil {
+		t.Fatalf("new bus: %v", err)
+	}
+	return b
+}
+
+// subscribe subscribes to the topic and waits until the subscription is
+// active. The received messages are sent to the returned channel.
+func subscribe(t *testing.T, mem *memory.Bus, bus service.MessageBus, topic string, h service.EventHandler) <-chan received {
+	t.Helper()
+	ch := make(chan received, 16)
+	if h == nil {
+		h = func(ctx context.Context, msg []byte) {
+			d, _ := service.DeliveryFromContext(ctx)
+			ch <- received{msg: msg, headers: d.Headers}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = bus.Subscribe(ctx, topic, h) }()
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+	if err := mem.WaitForSubscribers(waitCtx, topic, 1); err != nil {
+		t.Fatalf("wait for subscription: %v", err)
+	}
+	return ch
+}
+
+func wait(t *testing.T, mem *memory.Bus) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := mem.Wait(ctx); err != nil {
+		t.Fatalf("wait for handlers: %v", err)
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte("s"), ed25519.SeedSize))
+	pub, _ := priv.Public().(ed25519.PublicKey)
+	for name, tc := range map[string]struct {
+		publisher, subscriber []envelope.Option
+	}{
+		"hmac": {
+			publisher:  []envelope.Option{envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret))},
+			subscriber: []envelope.Option{envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret))},
+		},
+		"ed25519": {
+			publisher:  []envelope.Option{envelope.WithSigningKeys(envelope.Ed25519Key("1", priv))},
+			subscriber: []envelope.Option{envelope.WithSigningKeys(envelope.Ed25519PublicKey("1", pub))},
+		},
+		"encrypted": {
+			publisher: []envelope.Option{
+				envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret)),
+				envelope.WithEncryptionKeys(envelope.AESKey("1", aesKey)),
+			},
+			subscriber: []envelope.Option{
+				envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret)),
+				envelope.WithEncryptionKeys(envelope.AESKey("1", aesKey)),
+			},
+		},
+		"rotated": {
+			publisher: []envelope.Option{envelope.WithSigningKeys(envelope.HMACKey("2", bytes.Repeat([]byte("n"), 32)))},
+			subscriber: []envelope.Option{envelope.WithSigningKeys(
+				envelope.HMACKey("1", hmacSecret),
+				envelope.HMACKey("2", bytes.Repeat([]byte("n"), 32)),
+			)},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mem := memory.New()
+			defer mem.Close()
+			ch := subscribe(t, mem, newBus(t, mem, tc.subscriber...), "event.created", nil)
+
+			publisher := newBus(t, mem, tc.publisher...)
+			err := publisher.Publish(context.Background(), "event.created", []byte("secret event"), service.WithHeader("tenant", "a"))
+			if err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			wait(t, mem)
+
+			select {
+			case r := <-ch:
+				if string(r.msg) != "secret event" {
+					t.Errorf("got message %q", r.msg)
+				}
+				if r.headers["tenant"] != "a" {
+					t.Errorf("got headers %v, want tenant a", r.headers)
+				}
+			default:
+				t.Fatal("no message received")
+			}
+		})
+	}
+}
+
+func TestEncryptedBody(t *testing.T) {
+	mem := memory.New()
+	defer mem.Close()
+	ch := subscribe(t, mem, mem, "event.created", nil)
+	bus := newBus(t, mem,
+		envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret)),
+		envelope.WithEncryptionKeys(envelope.AESKey("1", aesKey)),
+	)
+	if err := bus.Publish(context.Background(), "event.created", []byte("secret event")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	wait(t, mem)
+	if r := <-ch; bytes.Contains(r.msg, []byte("secret event")) {
+		t.Errorf("the body is not encrypted: %s", r.msg)
+	}
+}
+
+func TestRejectedMessages(t *testing.T) {
+	signed := []envelope.Option{envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret))}
+	same := func(topic string, msg []byte, opts []service.PublishOption) (string, []byte, []service.PublishOption) {
+		return topic, msg, opts
+	}
+	for name, tc := range map[string]struct {
+		publisher  []envelope.Option // nil if the message is not wrapped
+		subscriber []envelope.Option // signed if nil
+		tamper     func(string, []byte, []service.PublishOption) (string, []byte, []service.PublishOption)
+	}{
+		"not an envelope": {tamper: same},
+		"unknown key": {
+			publisher: []envelope.Option{envelope.WithSigningKeys(envelope.HMACKey("2", hmacSecret))},
+			tamper:    same,
+		},
+		"wrong key": {
+			publisher: []envelope.Option{envelope.WithSigningKeys(envelope.HMACKey("1", bytes.Repeat([]byte("x"), 32)))},
+			tamper:    same,
+		},
+		"tampered payload": {
+			publisher: signed,
+			tamper: func(topic string, msg []byte, opts []service.PublishOption) (string, []byte, []service.PublishOption) {
+				var env map[string]any
+				_ = json.Unmarshal(msg, &env)
+				env["payload"] = []byte("forged event")
+				msg, _ = json.Marshal(env)
+				return topic, msg, opts
+			},
+		},
+		"other topic": {
+			publisher: signed,
+			tamper: func(_ string, msg []byte, opts []service.PublishOption) (string, []byte, []service.PublishOption) {
+				return "event.deleted", msg, opts
+			},
+		},
+		"changed header": {
+			publisher: signed,
+			tamper: func(topic string, msg []byte, opts []service.PublishOption) (string, []byte, []service.PublishOption) {
+				return topic, msg, append(opts, service.WithHeader("tenant", "b"))
+			},
+		},
+		"not encrypted": {
+			publisher: signed,
+			subscriber: append(signed[:1:1],
+				envelope.WithEncryptionKeys(envelope.AESKey("1", aesKey))),
+			tamper: same,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mem := memory.New()
+			defer mem.Close()
+			subscriber := tc.subscriber
+			if subscriber == nil {
+				subscriber = signed
+			}
+			ch := subscribe(t, mem, newBus(t, mem, subscriber...), "#", nil)
+
+			var publisher service.MessageBus = &tamperBus{Bus: mem, tamper: tc.tamper}
+			if tc.publisher != nil {
+				publisher = newBus(t, publisher, tc.publisher...)
+			}
+			err := publisher.Publish(context.Background(), "event.created", []byte("event"), service.WithHeader("tenant", "a"))
+			if err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			wait(t, mem)
+
+			select {
+			case r := <-ch:
+				t.Errorf("got message %q", r.msg)
+			default:
+			}
+		})
+	}
+}
+
+func TestUnsignedHeaders(t *testing.T) {
+	mem := memory.New()
+	defer mem.Close()
+	keys := envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret))
+	ch := subscribe(t, mem, newBus(t, mem, keys), "event.created", nil)
+
+	// Headers added on the way are not passed to the handler.
+	publisher := newBus(t, &tamperBus{Bus: mem, tamper: func(topic string, msg []byte, opts []service.PublishOption) (string, []byte, []service.PublishOption) {
+		return topic, msg, append(opts, service.WithHeader("role", "admin"))
+	}}, keys)
+	if err := publisher.Publish(context.Background(), "event.created", []byte("event"), service.WithHeader("tenant", "a")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	wait(t, mem)
+
+	r := <-ch
+	if len(r.headers) != 1 || r.headers["tenant"] != "a" {
+		t.Errorf("got headers %v, want only the tenant", r.headers)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	mem := memory.New()
+	defer mem.Close()
+	bus := newBus(t, mem,
+		envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret)),
+		envelope.WithEncryptionKeys(envelope.AESKey("1", aesKey)),
+	)
+	subscribe(t, mem, bus, "event.get", service.Responder(func(_ context.Context, msg []byte) ([]byte, error) {
+		if string(msg) == "missing" {
+			return nil, service.ErrNotFound
+		}
+		return append([]byte("event "), msg...), nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := bus.Request(ctx, "event.get", []byte("1"))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if string(resp) != "event 1" {
+		t.Errorf("got response %q", resp)
+	}
+
+	_, err = bus.Request(ctx, "event.get", []byte("missing"))
+	var replyErr *service.ReplyError
+	if !errors.As(err, &replyErr) || !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want a reply error wrapping %v", err, service.ErrNotFound)
+	}
+}
+
+func TestNew(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte("s"), ed25519.SeedSize))
+	pub, _ := priv.Public().(ed25519.PublicKey)
+	mem := memory.New()
+	defer mem.Close()
+
+	for name, opts := range map[string][]envelope.Option{
+		"no signing key":   nil,
+		"short hmac key":   {envelope.WithSigningKeys(envelope.HMACKey("1", []byte("short")))},
+		"short ed25519":    {envelope.WithSigningKeys(envelope.Ed25519PublicKey("1", pub[:16]))},
+		"empty id":         {envelope.WithSigningKeys(envelope.HMACKey("", hmacSecret))},
+		"duplicate id":     {envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret), envelope.Ed25519Key("1", priv))},
+		"invalid aes key":  {envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret)), envelope.WithEncryptionKeys(envelope.AESKey("1", []byte("short")))},
+		"duplicate aes id": {envelope.WithSigningKeys(envelope.HMACKey("1", hmacSecret)), envelope.WithEncryptionKeys(envelope.AESKey("1", aesKey), envelope.AESKey("1", aesKey))},
+	} {
+		if _, err := envelope.New(mem, opts...); !errors.Is(err, service.ErrInvalidConfig) {
+			t.Errorf("%s: got error %v, want %v", name, err, service.ErrInvalidConfig)
+		}
+	}
+
+	// A bus with public keys only verifies messages.
+	bus := newBus(t, mem, envelope.WithSigningKeys(envelope.Ed25519PublicKey("1", pub)))
+	if err := bus.Publish(context.Background(), "event.created", nil); !errors.Is(err, service.ErrNotAllowed) {
+		t.Errorf("got error %v, want %v", err, service.ErrNotAllowed)
+	}
+}
@@ -0,0 +1,127 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// The algorithms named in the headers of the envelopes.
+const (
+	algHMAC    = "hmac-sha256"
+	algEd25519 = "ed25519"
+	algAESGCM  = "aes-gcm"
+)
+
+// minHMACKeySize is the minimum size of HMAC keys in bytes, the size of the
+// SHA-256 hash.
+const minHMACKeySize = sha256.Size
+
+// SigningKey signs the published messages, or verifies the signatures of the
+// received messages. Create it with [HMACKey], [Ed25519Key] or
+// [Ed25519PublicKey].
+type SigningKey struct {
+	id     string
+	alg    string
+	secret []byte             // the HMAC key
+	priv   ed25519.PrivateKey // nil if the key only verifies
+	pub    ed25519.PublicKey
+}
+
+// HMACKey returns a key which signs messages with HMAC-SHA256. The secret is
+// shared by the publishers and the subscribers, and must have at least 32
+// bytes, e.g. config.Secret.Bytes() of a random value.
+func HMACKey(id string, secret []byte) SigningKey {
+	return SigningKey{id: id, alg: algHMAC, secret: secret}
+}
+
+// Ed25519Key returns a key which signs messages with Ed25519. Only the
+// publishers need the private key, the subscribers verify the signatures with
+// the public key, see [Ed25519PublicKey].
+func Ed25519Key(id string, key ed25519.PrivateKey) SigningKey {
+	k := SigningKey{id: id, alg: algEd25519, priv: key}
+	if len(key) == ed25519.PrivateKeySize {
+		k.pub, _ = key.Public().(ed25519.PublicKey) //nolint:errcheck // always a public key
+	}
+	return k
+}
+
+// Ed25519PublicKey returns a key which verifies the Ed25519 signatures of the
+// received messages, but cannot sign messages.
+func Ed25519PublicKey(id string, key ed25519.PublicKey) SigningKey {
+	return SigningKey{id: id, alg: algEd25519, pub: key}
+}
+
+// validate checks the size of the key.
+func (k *SigningKey) validate() error {
+	switch k.alg {
+	case algHMAC:
+		if len(k.secret) < minHMACKeySize {
+			return fmt.Errorf("hmac key %q must have at least %d bytes, got %d", k.id, minHMACKeySize, len(k.secret))
+		}
+	case algEd25519:
+		if k.priv != nil && len(k.priv) != ed25519.PrivateKeySize {
+			return fmt.Errorf("ed25519 private key %q must have %d bytes, got %d", k.id, ed25519.PrivateKeySize, len(k.priv))
+		}
+		if len(k.pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("ed25519 public key %q must have %d bytes, got %d", k.id, ed25519.PublicKeySize, len(k.pub))
+		}
+	default:
+		return fmt.Errorf("signing key %q has no algorithm", k.id)
+	}
+	return nil
+}
+
+// canSign reports whether the key can sign messages, or only verify them.
+func (k *SigningKey) canSign() bool {
+	return k.alg == algHMAC || k.priv != nil
+}
+
+func (k *SigningKey) sign(data []byte) []byte {
+	if k.alg == algEd25519 {
+		return ed25519.Sign(k.priv, data)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k *SigningKey) verify(data, sig []byte) bool {
+	if k.alg == algEd25519 {
+		return ed25519.Verify(k.pub, data, sig)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), sig)
+}
+
+// EncryptionKey encrypts the bodies of the published messages, or decrypts the
+// bodies of the received messages, with AES-GCM. Create it with [AESKey].
+type EncryptionKey struct {
+	id   string
+	key  []byte
+	aead cipher.AEAD // set by validate
+}
+
+// AESKey returns a key which encrypts messages with AES-GCM. The key is shared
+// by the publishers and the subscribers, and must have 16, 24 or 32 bytes for
+// AES-128, AES-192 or AES-256.
+func AESKey(id string, key []byte) EncryptionKey {
+	return EncryptionKey{id: id, key: key}
+}
+
+// validate checks the key and prepares the cipher.
+func (k *EncryptionKey) validate() error {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return fmt.Errorf("aes key %q: %v", k.id, err)
+	}
+	k.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("aes key %q: %v", k.id, err)
+	}
+	return nil
+}
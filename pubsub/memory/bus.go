@@ -0,0 +1,364 @@
+// Package memory provides an in-memory message bus. It delivers messages
+// within the process and is meant for tests and local development, where
+// running a message broker is not an option.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub"
+	"github.com/eventscompass/service-framework/service"
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+// Bus is an in-memory message bus. Messages are routed like on a topic exchange
+// of a RabbitMQ broker: subscriptions match topics by words separated by dots,
+// where "*" matches exactly one word and "#" matches zero or more words.
+//
+// Every subscription has its own unbounded queue, so publishing never blocks.
+// Messages published to a topic without any subscriptions are dropped. Delayed
+// messages, see [service.WithDelay], are routed to the subscriptions when they
+// are due. Requests, see [Bus.Request], are answered by the subscriptions
+// which handle them with a [service.Responder].
+type Bus struct {
+	mu      sync.Mutex
+	subs    map[*subscription]struct{}
+	closed  bool
+	delayed map[*time.Timer]struct{} // the timers of the delayed messages
+
+	// pending is the number of messages that are queued or being handled.
+	pending int
+	idle    []chan struct{} // closed once pending drops to zero
+	subbed  []chan struct{} // closed whenever a subscription is added
+}
+
+// subscription is the queue of a single [Bus.Subscribe] call.
+type subscription struct {
+	pattern string
+	queue   []delivery
+	ready   chan struct{} // signalled when the queue is not empty
+}
+
+// delivery is a message queued for a subscription.
+type delivery struct {
+	ctx context.Context //nolint:containedctx // carries the trace context
+	msg []byte
+}
+
+// New creates a new in-memory [Bus].
+func New() *Bus {
+	return &Bus{
+		subs:    make(map[*subscription]struct{}),
+		delayed: make(map[*time.Timer]struct{}),
+	}
+}
+
+var (
+	_ service.MessageBus     = (*Bus)(nil)
+	_ service.Requester      = (*Bus)(nil)
+	_ service.BatchPublisher = (*Bus)(nil)
+)
+
+// Publish publishes a message to a given topic. The message is queued for
+// every subscription matching the topic, or for the subscriptions matching the
+// topic once the message is due if its delivery is delayed. This function
+// returns [service.ErrConnectionClosed] in case the bus is closed.
+func (b *Bus) Publish(ctx context.Context, topic string, msg []byte, opts ...service.PublishOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.publish(ctx, topic, msg, opts)
+}
+
+// PublishBatch publishes the messages in order, like [Bus.Publish]. All
+// messages are queued at once, thus the handlers receive none of them before
+// the whole batch is published. Nothing is published if the context is done.
+// This function returns [service.BatchError] in case any of the messages could
+// not be published.
+func (b *Bus) PublishBatch(ctx context.Context, msgs []service.Message) error {
+	errs := make([]error, len(msgs))
+	b.mu.Lock()
+	for i, m := range msgs {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = b.publish(ctx, m.Topic, m.Body, m.Options)
+	}
+	b.mu.Unlock()
+	return service.NewBatchError(errs)
+}
+
+// publish queues the message, or schedules it if its delivery is delayed.
+func (b *Bus) publish(ctx context.Context, topic string, msg []byte, opts []service.PublishOption) error {
+	if b.closed {
+		return fmt.Errorf("%w: bus is closed", service.ErrConnectionClosed)
+	}
+
+	o := service.NewPublishOptions(opts...)
+	now := time.Now()
+	d := newDelivery(ctx, msg, service.Delivery{
+		Topic:       topic,
+		PublishedAt: now,
+		DeliverAt:   o.DeliverAt,
+		Headers:     maps.Clone(o.Headers),
+	})
+	if o.DeliverAt.After(now) {
+		// The delayed message counts as pending until it is due, so
+		// that Wait waits for it as well.
+		b.pending++
+		var timer *time.Timer
+		timer = time.AfterFunc(o.DeliverAt.Sub(now), func() {
+			b.mu.Lock()
+			_, ok := b.delayed[timer]
+			delete(b.delayed, timer)
+			if ok {
+				b.route(topic, d)
+			}
+			b.mu.Unlock()
+			if ok {
+				b.done(1)
+			}
+		})
+		b.delayed[timer] = struct{}{}
+		return nil
+	}
+	b.route(topic, d)
+	return nil
+}
+
+// Request publishes the message to the topic and waits for the response of the
+// first subscription that replies with a [service.Responder], or until the
+// context is done. This function returns [service.ErrNotFound] in case no
+// subscription matches the topic. This function returns
+// [service.ErrConnectionClosed] in case the bus is closed. This function
+// returns [service.ReplyError] in case the request handler failed.
+func (b *Bus) Request(ctx context.Context, topic string, msg []byte) ([]byte, error) {
+	type reply struct {
+		resp []byte
+		err  error
+	}
+	replies := make(chan reply, 1)
+	replyFunc := func(_ context.Context, resp []byte, err error) error {
+		r := reply{resp: append([]byte(nil), resp...)}
+		if err != nil {
+			r.err = service.NewReplyError(err)
+		}
+		select {
+		case replies <- r:
+		default: // only the first reply is returned
+		}
+		return nil
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("%w: bus is closed", service.ErrConnectionClosed)
+	}
+	matched := false
+	for s := range b.subs {
+		matched = matched || pubsub.MatchTopic(s.pattern, topic)
+	}
+	if !matched {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("%w: no responder for topic %s", service.ErrNotFound, topic)
+	}
+	d := newDelivery(ctx, msg, service.Delivery{Topic: topic, PublishedAt: time.Now()})
+	d.ctx = service.ContextWithReply(d.ctx, replyFunc)
+	b.route(topic, d)
+	b.mu.Unlock()
+
+	select {
+	case r := <-replies:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err() //nolint:wrapcheck // intentional
+	}
+}
+
+// newDelivery returns the delivery of a copy of the message with the metadata.
+// The handlers continue the trace of the publisher, without being cancelled
+// together with the publishing request.
+func newDelivery(ctx context.Context, msg []byte, md service.Delivery) delivery {
+	return delivery{
+		ctx: service.ContextWithDelivery(tracing.ContextWithRemoteSpanContext(
+			context.Background(), tracing.SpanContextFromContext(ctx)), md),
+		msg: append([]byte(nil), msg...),
+	}
+}
+
+// route queues the message for every subscription matching the topic.
+func (b *Bus) route(topic string, d delivery) {
+	for s := range b.subs {
+		if !pubsub.MatchTopic(s.pattern, topic) {
+			continue
+		}
+		s.queue = append(s.queue, d)
+		b.pending++
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe subscribes to the given topic. The event handler callback will be
+// executed on every received message, one message at a time. This function
+// returns [service.ErrConnectionClosed] in case the bus is closed. This is a
+// blocking function. Canceling the context, or closing the bus, will cancel
+// the subscription.
+func (b *Bus) Subscribe(ctx context.Context, topic string, h service.EventHandler) error {
+	s := &subscription{pattern: topic, ready: make(chan struct{}, 1)}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: bus is closed", service.ErrConnectionClosed)
+	}
+	b.subs[s] = struct{}{}
+	for _, ch := range b.subbed {
+		close(ch)
+	}
+	b.subbed = nil
+	b.mu.Unlock()
+
+	defer b.unsubscribe(s)
+	for {
+		select {
+		case _, ok := <-s.ready:
+			if !ok { // the bus is closed
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+		for {
+			d, ok := b.next(s)
+			if !ok {
+				break
+			}
+			if ctx.Err() != nil {
+				b.done(1)
+				return nil
+			}
+			h(d.ctx, d.msg)
+			b.done(1)
+		}
+	}
+}
+
+// next removes the first message from the queue of the subscription. It reports
+// false if the queue is empty.
+func (b *Bus) next(s *subscription) (delivery, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(s.queue) == 0 {
+		return delivery{}, false
+	}
+	d := s.queue[0]
+	s.queue[0] = delivery{}
+	s.queue = s.queue[1:]
+	return d, true
+}
+
+// unsubscribe removes the subscription and drops the messages still queued.
+func (b *Bus) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	n := len(s.queue)
+	s.queue = nil
+	b.mu.Unlock()
+	b.done(n)
+}
+
+// done marks n messages as handled.
+func (b *Bus) done(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending -= n
+	if b.pending > 0 {
+		return
+	}
+	for _, ch := range b.idle {
+		close(ch)
+	}
+	b.idle = nil
+}
+
+// Close closes the bus. All subscriptions are cancelled, the delayed messages
+// are dropped, and publishing afterwards fails.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for timer := range b.delayed {
+		timer.Stop()
+		delete(b.delayed, timer)
+		b.pending--
+	}
+	for s := range b.subs {
+		delete(b.subs, s)
+		b.pending -= len(s.queue)
+		s.queue = nil
+		close(s.ready)
+	}
+	if b.pending <= 0 {
+		for _, ch := range b.idle {
+			close(ch)
+		}
+		b.idle = nil
+	}
+	return nil
+}
+
+// Wait blocks until all published messages have been handled, including the
+// messages published by the handlers themselves and the delayed messages.
+func (b *Bus) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	if b.pending <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	b.idle = append(b.idle, ch)
+	b.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck // intentional
+	}
+}
+
+// WaitForSubscribers blocks until at least n subscriptions match the given
+// topic. Use it to make sure that no messages are dropped because they were
+// published before the subscribers were ready.
+func (b *Bus) WaitForSubscribers(ctx context.Context, topic string, n int) error {
+	for {
+		b.mu.Lock()
+		count := 0
+		for s := range b.subs {
+			if pubsub.MatchTopic(s.pattern, topic) {
+				count++
+			}
+		}
+		if count >= n {
+			b.mu.Unlock()
+			return nil
+		}
+		ch := make(chan struct{})
+		b.subbed = append(b.subbed, ch)
+		b.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck // intentional
+		}
+	}
+}
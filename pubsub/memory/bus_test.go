@@ -0,0 +1,243 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub/memory"
+	"github.com/eventscompass/service-framework/service"
+)
+
+// received records the messages passed to the handlers of a test.
+type received struct {
+	mu   sync.Mutex
+	msgs []string
+	mds  []service.Delivery
+}
+
+func (r *received) handler(ctx context.Context, msg []byte) {
+	md, _ := service.DeliveryFromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, string(msg))
+	r.mds = append(r.mds, md)
+}
+
+func (r *received) get() ([]string, []service.Delivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.msgs...), append([]service.Delivery(nil), r.mds...)
+}
+
+// subscribe runs the subscription until the test completes, and waits until it
+// is active.
+func subscribe(t *testing.T, bus *memory.Bus, topic string, h service.EventHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := bus.Subscribe(ctx, topic, h); err != nil {
+			t.Errorf("subscribe: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	if err := bus.WaitForSubscribers(waitCtx, topic, 1); err != nil {
+		t.Fatalf("wait for subscriber: %v", err)
+	}
+}
+
+// wait waits until all published messages have been handled.
+func wait(t *testing.T, bus *memory.Bus) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bus.Wait(ctx); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	bus := memory.New()
+	defer bus.Close()
+	var all, created received
+	// The wildcard subscription would count as a subscriber of the topic,
+	// thus it is added last.
+	subscribe(t, bus, "event.created", created.handler)
+	subscribe(t, bus, "event.#", all.handler)
+
+	ctx := context.Background()
+	for i, topic := range []string{"event.created", "event.deleted", "booking.created"} {
+		err := bus.Publish(ctx, topic, []byte(fmt.Sprint(i)), service.WithHeader("n", fmt.Sprint(i)))
+		if err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	wait(t, bus)
+
+	msgs, mds := all.get()
+	if fmt.Sprint(msgs) != "[0 1]" {
+		t.Errorf("got messages %v for event.#, want [0 1]", msgs)
+	}
+	if len(mds) == 2 && (mds[1].Topic != "event.deleted" || mds[1].Headers["n"] != "1" || mds[1].PublishedAt.IsZero()) {
+		t.Errorf("got delivery %+v", mds[1])
+	}
+	if msgs, _ := created.get(); fmt.Sprint(msgs) != "[0]" {
+		t.Errorf("got messages %v for event.created, want [0]", msgs)
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	bus := memory.New()
+	defer bus.Close()
+	var r received
+	subscribe(t, bus, "event.*", r.handler)
+
+	err := bus.PublishBatch(context.Background(), []service.Message{
+		{Topic: "event.a", Body: []byte("a")},
+		{Topic: "event.b", Body: []byte("b")},
+	})
+	if err != nil {
+		t.Fatalf("publish batch: %v", err)
+	}
+	wait(t, bus)
+	if msgs, _ := r.get(); fmt.Sprint(msgs) != "[a b]" {
+		t.Errorf("got messages %v, want [a b]", msgs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = bus.PublishBatch(ctx, []service.Message{{Topic: "event.c", Body: []byte("c")}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPublishDelayed(t *testing.T) {
+	bus := memory.New()
+	defer bus.Close()
+	var r received
+	subscribe(t, bus, "event.created", r.handler)
+
+	start := time.Now()
+	if err := bus.Publish(context.Background(), "event.created", []byte("later"), service.WithDelay(50*time.Millisecond)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if msgs, _ := r.get(); len(msgs) != 0 {
+		t.Errorf("got messages %v before they are due", msgs)
+	}
+	wait(t, bus)
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("message delivered after %v, want at least 50ms", d)
+	}
+	msgs, mds := r.get()
+	if fmt.Sprint(msgs) != "[later]" {
+		t.Fatalf("got messages %v, want [later]", msgs)
+	}
+	if d := mds[0].Delay(); d < 40*time.Millisecond || d > 60*time.Millisecond {
+		t.Errorf("got delay %v, want 50ms", d)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	bus := memory.New()
+	defer bus.Close()
+	ctx := context.Background()
+
+	if _, err := bus.Request(ctx, "booking.get", []byte("1")); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v without a responder, want %v", err, service.ErrNotFound)
+	}
+
+	subscribe(t, bus, "booking.get", service.Responder(func(_ context.Context, msg []byte) ([]byte, error) {
+		if string(msg) == "missing" {
+			return nil, fmt.Errorf("%w: booking %s", service.ErrNotFound, msg)
+		}
+		return append([]byte("booking "), msg...), nil
+	}))
+	resp, err := bus.Request(ctx, "booking.get", []byte("1"))
+	if err != nil || string(resp) != "booking 1" {
+		t.Errorf("got response %q and error %v, want booking 1", resp, err)
+	}
+
+	_, err = bus.Request(ctx, "booking.get", []byte("missing"))
+	var replyErr *service.ReplyError
+	if !errors.As(err, &replyErr) || !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want a reply error wrapping %v", err, service.ErrNotFound)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	bus := memory.New()
+	defer bus.Close()
+	subscribe(t, bus, "booking.get", func(context.Context, []byte) {}) // never replies
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := bus.Request(ctx, "booking.get", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := memory.New()
+	var r received
+	subscribed := make(chan error, 1)
+	go func() { subscribed <- bus.Subscribe(context.Background(), "event.created", r.handler) }()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bus.WaitForSubscribers(ctx, "event.created", 1); err != nil {
+		t.Fatalf("wait for subscriber: %v", err)
+	}
+	if err := bus.Publish(ctx, "event.created", []byte("delayed"), service.WithDelay(time.Hour)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	// Closing the bus cancels the subscriptions and drops the delayed
+	// messages.
+	if err := bus.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := <-subscribed; err != nil {
+		t.Errorf("got error %v from the cancelled subscription", err)
+	}
+	wait(t, bus)
+
+	if err := bus.Publish(ctx, "event.created", nil); !errors.Is(err, service.ErrConnectionClosed) {
+		t.Errorf("got error %v from publish, want %v", err, service.ErrConnectionClosed)
+	}
+	if err := bus.Subscribe(ctx, "event.created", r.handler); !errors.Is(err, service.ErrConnectionClosed) {
+		t.Errorf("got error %v from subscribe, want %v", err, service.ErrConnectionClosed)
+	}
+	if _, err := bus.Request(ctx, "event.created", nil); !errors.Is(err, service.ErrConnectionClosed) {
+		t.Errorf("got error %v from request, want %v", err, service.ErrConnectionClosed)
+	}
+}
+
+func TestWaitChained(t *testing.T) {
+	bus := memory.New()
+	defer bus.Close()
+
+	// Wait waits for the messages published by the handlers as well.
+	var r received
+	subscribe(t, bus, "step.second", r.handler)
+	subscribe(t, bus, "step.first", func(ctx context.Context, msg []byte) {
+		time.Sleep(10 * time.Millisecond)
+		_ = bus.Publish(ctx, "step.second", msg)
+	})
+	if err := bus.Publish(context.Background(), "step.first", []byte("x")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	wait(t, bus)
+	if msgs, _ := r.get(); fmt.Sprint(msgs) != "[x]" {
+		t.Errorf("got messages %v, want [x]", msgs)
+	}
+}
@@ -35,14 +35,23 @@ type EventCreated struct {
 	End        time.Time `json:"end_time"`
 }
 
+// SchemaVersion implements the [Versioned] interface.
+func (EventCreated) SchemaVersion() int { return 1 }
+
 // LocationCreated is the payload for notifying for the creation of a location.
 type LocationCreated struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
+// SchemaVersion implements the [Versioned] interface.
+func (LocationCreated) SchemaVersion() int { return 1 }
+
 // EventBooked is the payload for notifying for the booking of an event.
 type EventBooked struct {
 	EventID string `json:"event_id"`
 	UserID  string `json:"user_id"`
 }
+
+// SchemaVersion implements the [Versioned] interface.
+func (EventBooked) SchemaVersion() int { return 1 }
@@ -0,0 +1,439 @@
+// Package pubsubtest provides a fake message bus for unit tests of code that
+// publishes or subscribes for messages.
+//
+//	bus := pubsubtest.NewBus()
+//	bus.FailPublish(rabbitmq.ErrConnClosed, 2) // the second publish fails
+//
+//	err := createEvent(ctx, bus, ...)
+//
+//	bus.AssertPublished(t, pubsub.EventCreatedTopic, pubsub.EventCreated{ID: "1"})
+package pubsubtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub"
+	"github.com/eventscompass/service-framework/service"
+)
+
+// Message is a message published on a [Bus].
+type Message struct {
+	Topic string
+	Body  []byte
+
+	// DeliverAt is the time at which the delivery is scheduled,
+	// see [service.WithDelay], or zero.
+	DeliverAt time.Time
+
+	// Headers are the headers of the message, see
+	// [service.WithHeader].
+	Headers map[string]string
+
+	// Request reports whether the message is a request sent
+	// with [Bus.Request], rather than a published message.
+	Request bool
+}
+
+// Bus is a [service.MessageBus] that records the published messages, instead
+// of sending them to a message broker. Messages are delivered to the handlers
+// of the subscriptions only when the test calls [Bus.Deliver].
+type Bus struct {
+	mu        sync.Mutex
+	published []Message
+	subs      map[*subscription]struct{}
+	responses map[string]service.RequestHandler
+	closed    chan struct{}
+	closeOnce sync.Once
+	subbed    []chan struct{} // closed whenever a subscription is added
+
+	publishCalls   int
+	subscribeCalls int
+	publishErrs    failures
+	subscribeErrs  failures
+}
+
+type subscription struct {
+	pattern string
+	handler service.EventHandler
+}
+
+// NewBus creates a new [Bus].
+func NewBus() *Bus {
+	return &Bus{
+		subs:          make(map[*subscription]struct{}),
+		responses:     make(map[string]service.RequestHandler),
+		closed:        make(chan struct{}),
+		publishErrs:   make(failures),
+		subscribeErrs: make(failures),
+	}
+}
+
+var (
+	_ service.MessageBus     = (*Bus)(nil)
+	_ service.Requester      = (*Bus)(nil)
+	_ service.BatchPublisher = (*Bus)(nil)
+)
+
+// failures maps the number of a call, counting from 1, to the error it returns.
+// The error with number 0 is returned by all calls without a specific error.
+type failures map[int]error
+
+func (f failures) set(err error, calls []int) {
+	if len(calls) == 0 {
+		f[0] = err
+		return
+	}
+	for _, n := range calls {
+		f[n] = err
+	}
+}
+
+func (f failures) get(call int) error {
+	if err, ok := f[call]; ok {
+		return err
+	}
+	return f[0]
+}
+
+// FailPublish makes the given calls to [Bus.Publish], counting from 1, return
+// the error, e.g. rabbitmq.ErrConnClosed. Without any calls, all subsequent
+// calls fail. A nil error makes the calls succeed again. Failed publications
+// are not recorded.
+func (b *Bus) FailPublish(err error, calls ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publishErrs.set(err, calls)
+}
+
+// FailSubscribe makes the given calls to [Bus.Subscribe], counting from 1,
+// return the error, e.g. rabbitmq.ErrChanBroken. Without any calls, all
+// subsequent calls fail. A nil error makes the calls succeed again.
+func (b *Bus) FailSubscribe(err error, calls ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribeErrs.set(err, calls)
+}
+
+// Publish records the message, unless it is scripted to fail, see
+// [Bus.FailPublish]. Delayed messages are recorded immediately, with the time
+// at which they are due. This function returns [service.ErrConnectionClosed]
+// in case the bus is closed.
+func (b *Bus) Publish(_ context.Context, topic string, msg []byte, opts ...service.PublishOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.record(newMessage(topic, msg, opts))
+}
+
+// PublishBatch records the messages in order, like [Bus.Publish]. Every message
+// counts as a call of [Bus.Publish], thus the messages of a batch are scripted
+// to fail with [Bus.FailPublish]. This function returns [service.BatchError] in
+// case any of the messages could not be published.
+func (b *Bus) PublishBatch(ctx context.Context, msgs []service.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = b.record(newMessage(m.Topic, m.Body, m.Options))
+	}
+	return service.NewBatchError(errs)
+}
+
+// newMessage returns the record of a published message.
+func newMessage(topic string, msg []byte, opts []service.PublishOption) Message {
+	o := service.NewPublishOptions(opts...)
+	return Message{
+		Topic:     topic,
+		Body:      append([]byte(nil), msg...),
+		DeliverAt: o.DeliverAt,
+		Headers:   o.Headers,
+	}
+}
+
+// record records the published message, unless the call is scripted to fail.
+func (b *Bus) record(m Message) error {
+	b.publishCalls++
+	if err := b.publishErrs.get(b.publishCalls); err != nil {
+		return err
+	}
+	if b.isClosed() {
+		return fmt.Errorf("%w: bus is closed", service.ErrConnectionClosed)
+	}
+	b.published = append(b.published, m)
+	return nil
+}
+
+// Respond makes [Bus.Request] answer the requests to the topic with the
+// responses of h, instead of passing them to the subscriptions. A nil handler
+// removes the responder.
+func (b *Bus) Respond(topic string, h service.RequestHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h == nil {
+		delete(b.responses, topic)
+		return
+	}
+	b.responses[topic] = h
+}
+
+// Request records the request like [Bus.Publish], marked as a request, and
+// fails like it, see [Bus.FailPublish]. The request is answered by the responder of the topic,
+// see [Bus.Respond], or else by the handlers of the subscriptions matching the
+// topic, which are run synchronously until one of them replies. This function
+// returns [service.ErrNotFound] in case there is neither a responder nor a
+// subscription for the topic, or none of the handlers replies. This function
+// returns [service.ReplyError] in case the request handler failed.
+func (b *Bus) Request(ctx context.Context, topic string, msg []byte) ([]byte, error) {
+	m := newMessage(topic, msg, nil)
+	m.Request = true
+	b.mu.Lock()
+	if err := b.record(m); err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	respond := b.responses[topic]
+	handlers := b.handlers(topic)
+	b.mu.Unlock()
+
+	ctx = service.ContextWithDelivery(ctx, service.Delivery{Topic: topic})
+	if respond != nil {
+		resp, err := respond(ctx, msg)
+		if err != nil {
+			return nil, service.NewReplyError(err)
+		}
+		return resp, nil
+	}
+
+	var (
+		replied bool
+		resp    []byte
+		respErr error
+	)
+	ctx = service.ContextWithReply(ctx, func(_ context.Context, r []byte, err error) error {
+		if !replied {
+			replied, resp = true, r
+			if err != nil {
+				respErr = service.NewReplyError(err)
+			}
+		}
+		return nil
+	})
+	for _, h := range handlers {
+		h(ctx, msg)
+		if replied {
+			return resp, respErr
+		}
+	}
+	return nil, fmt.Errorf("%w: no responder for topic %s", service.ErrNotFound, topic)
+}
+
+// Subscribe registers the handler for the messages delivered to the topic with
+// [Bus.Deliver], unless it is scripted to fail, see [Bus.FailSubscribe]. This
+// function returns [service.ErrConnectionClosed] in case the bus is closed.
+// This is a blocking function. Canceling the context, or closing the bus, will
+// cancel the subscription.
+func (b *Bus) Subscribe(ctx context.Context, topic string, h service.EventHandler) error {
+	b.mu.Lock()
+	b.subscribeCalls++
+	if err := b.subscribeErrs.get(b.subscribeCalls); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	if b.isClosed() {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: bus is closed", service.ErrConnectionClosed)
+	}
+	s := &subscription{pattern: topic, handler: h}
+	b.subs[s] = struct{}{}
+	for _, ch := range b.subbed {
+		close(ch)
+	}
+	b.subbed = nil
+	b.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-b.closed:
+	}
+
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+	return nil
+}
+
+// Close closes the bus. All subscriptions are cancelled, and publishing
+// afterwards fails.
+func (b *Bus) Close() error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	return nil
+}
+
+func (b *Bus) isClosed() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForSubscribers blocks until at least n subscriptions match the given
+// topic. Use it to make sure that the subscriptions started by the code under
+// test are active before calling [Bus.Deliver].
+func (b *Bus) WaitForSubscribers(ctx context.Context, topic string, n int) error {
+	for {
+		b.mu.Lock()
+		if len(b.handlers(topic)) >= n {
+			b.mu.Unlock()
+			return nil
+		}
+		ch := make(chan struct{})
+		b.subbed = append(b.subbed, ch)
+		b.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck // intentional
+		}
+	}
+}
+
+// Published returns the messages published to the given topic, in the order in
+// which they were published, including the requests. An empty topic returns all
+// messages.
+func (b *Bus) Published(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var msgs []Message
+	for _, m := range b.published {
+		if topic == "" || m.Topic == topic {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// Reset forgets the published messages and the number of calls, and removes
+// the scripted failures and responders. The subscriptions are kept.
+func (b *Bus) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = nil
+	b.publishCalls, b.subscribeCalls = 0, 0
+	clear(b.publishErrs)
+	clear(b.subscribeErrs)
+	clear(b.responses)
+}
+
+// Deliver passes the payload to the handlers of all subscriptions matching the
+// topic, see [pubsub.MatchTopic]. The handlers are run synchronously, one after
+// the other. Payloads other than []byte are encoded as json. The test fails if
+// no subscription matches the topic.
+func (b *Bus) Deliver(t testing.TB, topic string, payload any) {
+	t.Helper()
+	msg := encode(t, payload)
+
+	b.mu.Lock()
+	handlers := b.handlers(topic)
+	b.mu.Unlock()
+
+	if len(handlers) == 0 {
+		t.Fatalf("no subscription for topic %q", topic)
+	}
+	ctx := service.ContextWithDelivery(context.Background(), service.Delivery{Topic: topic})
+	for _, h := range handlers {
+		h(ctx, msg)
+	}
+}
+
+// handlers returns the handlers of the subscriptions matching the topic.
+func (b *Bus) handlers(topic string) []service.EventHandler {
+	var handlers []service.EventHandler
+	for s := range b.subs {
+		if pubsub.MatchTopic(s.pattern, topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	return handlers
+}
+
+// AssertPublished checks that a message equal to want was published to the
+// topic. The messages are decoded from json into values of the type of want
+// before comparing them, unless want is a []byte. Otherwise the test is marked
+// as failed.
+func (b *Bus) AssertPublished(t testing.TB, topic string, want any) {
+	t.Helper()
+	msgs := b.Published(topic)
+	if raw, ok := want.([]byte); ok {
+		for _, m := range msgs {
+			if bytes.Equal(m.Body, raw) {
+				return
+			}
+		}
+		t.Errorf("no message %q published to topic %q", raw, topic)
+		return
+	}
+
+	typ := reflect.TypeOf(want)
+	wantValue := decode(t, encode(t, want), typ)
+	for _, m := range msgs {
+		got := reflect.New(typ)
+		if err := json.Unmarshal(m.Body, got.Interface()); err != nil {
+			continue
+		}
+		if reflect.DeepEqual(got.Elem().Interface(), wantValue) {
+			return
+		}
+	}
+
+	t.Errorf("no message equal to %+v published to topic %q", want, topic)
+	for _, m := range msgs {
+		t.Logf("published: %s", m.Body)
+	}
+}
+
+// AssertNotPublished checks that no message was published to the topic.
+// Otherwise the test is marked as failed.
+func (b *Bus) AssertNotPublished(t testing.TB, topic string) {
+	t.Helper()
+	if msgs := b.Published(topic); len(msgs) > 0 {
+		t.Errorf("%d messages published to topic %q", len(msgs), topic)
+	}
+}
+
+// encode returns the payload encoded as json, unless it is already a []byte.
+func encode(t testing.TB, payload any) []byte {
+	t.Helper()
+	if msg, ok := payload.([]byte); ok {
+		return msg
+	}
+	msg, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("encode payload: %v", err)
+	}
+	return msg
+}
+
+// decode returns the json message decoded into a value of the given type.
+// Decoding the expected value as well makes the comparison independent of the
+// details lost in encoding, e.g. the monotonic clock reading of times.
+func decode(t testing.TB, msg []byte, typ reflect.Type) any {
+	t.Helper()
+	v := reflect.New(typ)
+	if err := json.Unmarshal(msg, v.Interface()); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	return v.Elem().Interface()
+}
@@ -0,0 +1,186 @@
+package pubsubtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub/pubsubtest"
+	"github.com/eventscompass/service-framework/service"
+)
+
+var errBroken = errors.New("broken")
+
+type event struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+}
+
+func TestPublish(t *testing.T) {
+	bus := pubsubtest.NewBus()
+	ctx := context.Background()
+	e := event{ID: "1", Start: time.Now()}
+	msg, _ := json.Marshal(e)
+	if err := bus.Publish(ctx, "event.created", msg, service.WithHeader("k", "v"), service.WithDelay(time.Hour)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := bus.Publish(ctx, "event.deleted", []byte("raw")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	bus.AssertPublished(t, "event.created", e)
+	bus.AssertPublished(t, "event.deleted", []byte("raw"))
+	bus.AssertNotPublished(t, "booking.created")
+	msgs := bus.Published("event.created")
+	if len(msgs) != 1 || msgs[0].Headers["k"] != "v" || msgs[0].DeliverAt.Before(time.Now()) || msgs[0].Request {
+		t.Errorf("got messages %+v", msgs)
+	}
+	if got := len(bus.Published("")); got != 2 {
+		t.Errorf("got %d messages, want 2", got)
+	}
+
+	bus.Reset()
+	bus.AssertNotPublished(t, "event.created")
+}
+
+func TestFailPublish(t *testing.T) {
+	bus := pubsubtest.NewBus()
+	ctx := context.Background()
+	bus.FailPublish(errBroken, 2)
+	for i, want := range []error{nil, errBroken, nil} {
+		if err := bus.Publish(ctx, "event.created", []byte(fmt.Sprint(i))); !errors.Is(err, want) {
+			t.Errorf("call %d: got error %v, want %v", i+1, err, want)
+		}
+	}
+	if got := len(bus.Published("event.created")); got != 2 {
+		t.Errorf("got %d messages, want the 2 that did not fail", got)
+	}
+
+	// The messages of a batch count as separate calls.
+	bus.Reset()
+	bus.FailPublish(errBroken, 2)
+	err := bus.PublishBatch(ctx, []service.Message{
+		{Topic: "event.created", Body: []byte("a")},
+		{Topic: "event.created", Body: []byte("b")},
+	})
+	var batchErr *service.BatchError
+	if !errors.As(err, &batchErr) || !errors.Is(err, errBroken) {
+		t.Errorf("got error %v, want a batch error wrapping %v", err, errBroken)
+	}
+	bus.AssertPublished(t, "event.created", []byte("a"))
+
+	// Without calls, all subsequent calls fail until the error is reset.
+	bus.FailPublish(errBroken)
+	if err := bus.Publish(ctx, "event.created", nil); !errors.Is(err, errBroken) {
+		t.Errorf("got error %v, want %v", err, errBroken)
+	}
+	bus.FailPublish(nil)
+	if err := bus.Publish(ctx, "event.created", nil); err != nil {
+		t.Errorf("got error %v after resetting the failure", err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	bus := pubsubtest.NewBus()
+	ctx := context.Background()
+	if _, err := bus.Request(ctx, "booking.get", []byte("1")); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v without a responder, want %v", err, service.ErrNotFound)
+	}
+
+	bus.Respond("booking.get", func(_ context.Context, msg []byte) ([]byte, error) {
+		if string(msg) == "missing" {
+			return nil, fmt.Errorf("%w: booking %s", service.ErrNotFound, msg)
+		}
+		return append([]byte("booking "), msg...), nil
+	})
+	resp, err := bus.Request(ctx, "booking.get", []byte("1"))
+	if err != nil || string(resp) != "booking 1" {
+		t.Errorf("got response %q and error %v, want booking 1", resp, err)
+	}
+	var replyErr *service.ReplyError
+	if _, err := bus.Request(ctx, "booking.get", []byte("missing")); !errors.As(err, &replyErr) || !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want a reply error wrapping %v", err, service.ErrNotFound)
+	}
+
+	// The requests are recorded as such.
+	msgs := bus.Published("booking.get")
+	if len(msgs) != 3 || !msgs[0].Request || string(msgs[1].Body) != "1" {
+		t.Errorf("got messages %+v, want 3 requests", msgs)
+	}
+}
+
+// subscribe runs the subscription until the test completes, and waits until it
+// is active.
+func subscribe(t *testing.T, bus *pubsubtest.Bus, topic string, h service.EventHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- bus.Subscribe(ctx, topic, h) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("subscribe: %v", err)
+		}
+	})
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	if err := bus.WaitForSubscribers(waitCtx, topic, 1); err != nil {
+		t.Fatalf("wait for subscriber: %v", err)
+	}
+}
+
+func TestRequestSubscription(t *testing.T) {
+	bus := pubsubtest.NewBus()
+	subscribe(t, bus, "booking.*", service.Responder(func(context.Context, []byte) ([]byte, error) {
+		return []byte("from subscription"), nil
+	}))
+	resp, err := bus.Request(context.Background(), "booking.get", nil)
+	if err != nil || string(resp) != "from subscription" {
+		t.Errorf("got response %q and error %v, want from subscription", resp, err)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	bus := pubsubtest.NewBus()
+	var got []event
+	subscribe(t, bus, "event.#", func(ctx context.Context, msg []byte) {
+		var e event
+		_ = json.Unmarshal(msg, &e)
+		if d, _ := service.DeliveryFromContext(ctx); d.Topic != "event.created" {
+			t.Errorf("got topic %q, want event.created", d.Topic)
+		}
+		got = append(got, e)
+	})
+
+	// The handlers are run synchronously.
+	bus.Deliver(t, "event.created", event{ID: "1"})
+	bus.Deliver(t, "event.created", []byte(`{"id":"2"}`))
+	if len(got) != 2 || got[0].ID != "1" || got[1].ID != "2" {
+		t.Errorf("got events %+v, want 1 and 2", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := pubsubtest.NewBus()
+	ctx := context.Background()
+	bus.FailSubscribe(errBroken, 1)
+	if err := bus.Subscribe(ctx, "event.created", func(context.Context, []byte) {}); !errors.Is(err, errBroken) {
+		t.Errorf("got error %v, want %v", err, errBroken)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- bus.Subscribe(ctx, "event.created", func(context.Context, []byte) {}) }()
+	if err := bus.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := <-done; err != nil && !errors.Is(err, service.ErrConnectionClosed) {
+		t.Errorf("got error %v from the subscription", err)
+	}
+	if err := bus.Publish(ctx, "event.created", nil); !errors.Is(err, service.ErrConnectionClosed) {
+		t.Errorf("got error %v, want %v", err, service.ErrConnectionClosed)
+	}
+	bus.AssertNotPublished(t, "event.created")
+}
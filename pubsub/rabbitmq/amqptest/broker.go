@@ -0,0 +1,406 @@
+package amqptest
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub"
+)
+
+// The exchange types supported by the server.
+const (
+	kindDirect  = "direct"
+	kindFanout  = "fanout"
+	kindTopic   = "topic"
+	kindHeaders = "headers"
+)
+
+// message is a message stored in a queue.
+type message struct {
+	exchange    string
+	routingKey  string
+	props       properties
+	body        []byte
+	redelivered bool
+	expiresAt   time.Time // zero if the message does not expire
+}
+
+type exchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	args       map[string]any
+	bindings   []binding
+}
+
+// binding binds a queue, or another exchange, to an exchange.
+type binding struct {
+	queue    string
+	exchange string
+	key      string
+	args     map[string]any
+}
+
+func (b binding) equal(o binding) bool {
+	return b.queue == o.queue && b.exchange == o.exchange && b.key == o.key && argsEqual(b.args, o.args)
+}
+
+type queue struct {
+	name       string
+	durable    bool
+	exclusive  bool
+	autoDelete bool
+	owner      *conn // the connection of an exclusive queue
+	args       map[string]any
+
+	ttl       time.Duration // zero if messages do not expire
+	expires   time.Duration // zero if the queue does not expire
+	usedAt    time.Time     // when the queue was last declared or consumed
+	dlx       string        // the dead letter exchange
+	dlxKey    string        // the dead letter routing key
+	hasDLX    bool
+	hasDLXKey bool
+
+	messages  []*message
+	consumers []*consumer
+	next      int // the consumer that receives the next message
+}
+
+type consumer struct {
+	tag   string
+	ch    *channel
+	queue *queue
+	noAck bool
+}
+
+// delivery is a message delivered to a channel, which is not yet acknowledged.
+type delivery struct {
+	tag   uint64
+	msg   *message
+	queue *queue
+}
+
+// newQueue creates a queue with the given arguments, see
+// https://www.rabbitmq.com/docs/queues#optional-arguments.
+func newQueue(name string, args map[string]any) *queue {
+	q := &queue{name: name, args: args}
+	if ms, ok := intArg(args["x-message-ttl"]); ok {
+		q.ttl = time.Duration(ms) * time.Millisecond
+	}
+	if ms, ok := intArg(args["x-expires"]); ok {
+		q.expires = time.Duration(ms) * time.Millisecond
+	}
+	q.dlx, q.hasDLX = args["x-dead-letter-exchange"].(string)
+	q.dlxKey, q.hasDLXKey = args["x-dead-letter-routing-key"].(string)
+	return q
+}
+
+// bind adds the binding to the exchange, unless it exists already.
+func (ex *exchange) bind(b binding) {
+	for _, eb := range ex.bindings {
+		if eb.equal(b) {
+			return
+		}
+	}
+	ex.bindings = append(ex.bindings, b)
+}
+
+// unbind removes the bindings of the exchange for which match returns true.
+func (ex *exchange) unbind(match func(binding) bool) {
+	bindings := ex.bindings[:0]
+	for _, b := range ex.bindings {
+		if !match(b) {
+			bindings = append(bindings, b)
+		}
+	}
+	ex.bindings = bindings
+}
+
+// argsEqual reports whether the arguments are equivalent. Integers are equal
+// regardless of their size, as clients encode them differently.
+func argsEqual(a, b map[string]any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !valueEqual(av, bv) {
+			return false
+		}
+	}
+	return true
+}
+
+func valueEqual(a, b any) bool {
+	if ai, ok := intArg(a); ok {
+		bi, ok := intArg(b)
+		return ok && ai == bi
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func intArg(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int8:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// route returns the queues to which a message published to the exchange with
+// the routing key and headers is delivered. The message is routed through the
+// exchanges bound to the exchange as well.
+func (s *Server) route(ex *exchange, key string, headers map[string]any) []*queue {
+	if ex.name == "" { // the default exchange routes to the queue by name
+		if q, ok := s.queues[key]; ok {
+			return []*queue{q}
+		}
+		return nil
+	}
+
+	var queues []*queue
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	var walk func(ex *exchange)
+	walk = func(ex *exchange) {
+		visited[ex.name] = true
+		for _, b := range ex.bindings {
+			if !ex.matches(b, key, headers) {
+				continue
+			}
+			if b.exchange != "" {
+				if dst, ok := s.exchanges[b.exchange]; ok && !visited[b.exchange] {
+					walk(dst)
+				}
+				continue
+			}
+			if q, ok := s.queues[b.queue]; ok && !seen[b.queue] {
+				seen[b.queue] = true
+				queues = append(queues, q)
+			}
+		}
+	}
+	walk(ex)
+	return queues
+}
+
+// matches reports whether a message with the routing key and headers matches
+// the binding of the exchange.
+func (ex *exchange) matches(b binding, key string, headers map[string]any) bool {
+	switch ex.kind {
+	case kindDirect:
+		return b.key == key
+	case kindFanout:
+		return true
+	case kindTopic:
+		return pubsub.MatchTopic(b.key, key)
+	case kindHeaders:
+		// The binding arguments are matched against the headers, see
+		// https://www.rabbitmq.com/docs/exchanges#headers.
+		all := b.args["x-match"] != "any"
+		for k, v := range b.args {
+			if strings.HasPrefix(k, "x-") {
+				continue
+			}
+			hv, ok := headers[k]
+			match := ok && (v == nil || valueEqual(v, hv))
+			if match != all {
+				return match
+			}
+		}
+		return all
+	default:
+		return false
+	}
+}
+
+// enqueue adds a copy of the message to the queue and delivers the messages
+// of the queue to its consumers.
+func (s *Server) enqueue(q *queue, m *message) {
+	cp := *m
+	var ttl time.Duration
+	hasTTL := false
+	if ms, err := strconv.ParseInt(m.props.expiration, 10, 64); err == nil && ms >= 0 {
+		ttl, hasTTL = time.Duration(ms)*time.Millisecond, true
+	}
+	if q.ttl > 0 && (!hasTTL || q.ttl < ttl) {
+		ttl, hasTTL = q.ttl, true
+	}
+	if hasTTL {
+		cp.expiresAt = s.now().Add(ttl)
+	}
+	q.messages = append(q.messages, &cp)
+	s.dispatch(q)
+}
+
+// dispatch delivers the messages of the queue to its consumers, for as long as
+// the consumers are able to receive them. Expired messages are dead-lettered.
+func (s *Server) dispatch(q *queue) {
+	for len(q.messages) > 0 {
+		if s.expire(q) {
+			continue
+		}
+		c := q.nextConsumer()
+		if c == nil {
+			return
+		}
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		c.ch.deliver(c, m)
+	}
+}
+
+// expire dead-letters the first message of the queue if it has expired. Like
+// RabbitMQ, only the messages at the head of the queue are expired.
+func (s *Server) expire(q *queue) bool {
+	m := q.messages[0]
+	if m.expiresAt.IsZero() || s.now().Before(m.expiresAt) {
+		return false
+	}
+	q.messages = q.messages[1:]
+	s.deadLetter(q, m, "expired")
+	return true
+}
+
+// nextConsumer returns the next consumer of the queue, in a round robin
+// fashion, that is able to receive a message, or nil.
+func (q *queue) nextConsumer() *consumer {
+	for i := 0; i < len(q.consumers); i++ {
+		c := q.consumers[(q.next+i)%len(q.consumers)]
+		if c.noAck || c.ch.canDeliver() {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return c
+		}
+	}
+	return nil
+}
+
+// deadLetter publishes the message to the dead letter exchange of the queue,
+// if any, see https://www.rabbitmq.com/docs/dlx.
+func (s *Server) deadLetter(q *queue, m *message, reason string) {
+	if !q.hasDLX {
+		return
+	}
+	ex, ok := s.exchanges[q.dlx]
+	if !ok {
+		return
+	}
+
+	cp := *m
+	cp.redelivered = false
+	cp.expiresAt = time.Time{}
+	cp.props.headers = make(map[string]any, len(m.props.headers)+1)
+	for k, v := range m.props.headers {
+		cp.props.headers[k] = v
+	}
+	if cp.props.expiration != "" {
+		cp.props.headers["original-expiration"] = cp.props.expiration
+		cp.props.expiration = ""
+	}
+	death := map[string]any{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        q.name,
+		"time":         s.now(),
+		"exchange":     m.exchange,
+		"routing-keys": []any{m.routingKey},
+	}
+	deaths, _ := cp.props.headers["x-death"].([]any)
+	cp.props.headers["x-death"] = append([]any{death}, deaths...)
+	if q.hasDLXKey {
+		cp.routingKey = q.dlxKey
+	}
+	cp.exchange = ex.name
+
+	for _, dq := range s.route(ex, cp.routingKey, cp.props.headers) {
+		s.enqueue(dq, &cp)
+	}
+}
+
+// requeue puts the messages back at the head of their queues, in the order in
+// which they were delivered, and marks them as redelivered.
+func (s *Server) requeue(ds []delivery) {
+	for i := len(ds) - 1; i >= 0; i-- {
+		d := ds[i]
+		if _, ok := s.queues[d.queue.name]; !ok {
+			continue // the queue was deleted
+		}
+		d.msg.redelivered = true
+		d.queue.messages = append([]*message{d.msg}, d.queue.messages...)
+	}
+	for _, d := range ds {
+		s.dispatch(d.queue)
+	}
+}
+
+// removeConsumer cancels the consumer. An auto-delete queue is deleted once its
+// last consumer is cancelled.
+func (s *Server) removeConsumer(c *consumer) {
+	if c == c.ch.replyTo {
+		delete(s.replies, c.ch.replyKey)
+		c.ch.replyTo = nil
+		return
+	}
+	q := c.queue
+	for i, qc := range q.consumers {
+		if qc == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if len(q.consumers) == 0 {
+		q.usedAt = s.now()
+	}
+	if q.autoDelete && len(q.consumers) == 0 {
+		s.deleteQueue(q)
+	}
+}
+
+// deleteQueue deletes the queue and its bindings. The consumers of the queue
+// are notified that they are cancelled.
+func (s *Server) deleteQueue(q *queue) {
+	delete(s.queues, q.name)
+	for _, ex := range s.exchanges {
+		ex.unbind(func(b binding) bool { return b.queue == q.name })
+	}
+	for _, c := range q.consumers {
+		delete(c.ch.consumers, c.tag)
+		c.ch.sendMethod(60, 30, func(e *encoder) { //nolint:gomnd // basic.cancel
+			e.shortstr(c.tag)
+			e.bits(true) // no-wait
+		})
+	}
+	q.consumers = nil
+}
+
+// sweep delivers the messages of all queues to their consumers, and expires
+// the messages that are due. Queues with an expiry are deleted once they are
+// unused for that long, i.e. they have no consumers and are not declared again.
+func (s *Server) sweep() {
+	for _, q := range s.queues {
+		if q.expires > 0 && len(q.consumers) == 0 && !s.now().Before(q.usedAt.Add(q.expires)) {
+			s.deleteQueue(q)
+			continue
+		}
+		s.dispatch(q)
+	}
+}
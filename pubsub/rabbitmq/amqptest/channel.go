@@ -0,0 +1,697 @@
+package amqptest
+
+import "strings"
+
+// directReplyTo is the pseudo-queue for consuming replies without declaring a
+// queue, see https://www.rabbitmq.com/docs/direct-reply-to.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// channel is a channel of a client connection. All fields are guarded by the
+// mutex of the server.
+type channel struct {
+	conn *conn
+	id   uint16
+
+	closing   bool // the server sent channel.close
+	consumers map[string]*consumer
+	prefetch  int
+
+	nextTag  uint64
+	unacked  []delivery
+	confirm  bool
+	sequence uint64 // the number of messages published in confirm mode
+
+	// replyTo is the consumer of the direct reply-to pseudo-queue, and
+	// replyKey the routing key of the replies to the channel.
+	replyTo  *consumer
+	replyKey string
+
+	// publish is the message being published, until all its content frames
+	// are received.
+	publish *publishing
+}
+
+type publishing struct {
+	msg       message
+	mandatory bool
+	size      uint64
+	header    bool
+}
+
+func newChannel(c *conn, id uint16) *channel {
+	return &channel{conn: c, id: id, consumers: make(map[string]*consumer)}
+}
+
+func (ch *channel) sendMethod(class, method uint16, args func(*encoder)) {
+	ch.conn.sendMethod(ch.id, class, method, args)
+}
+
+// closeWith sends channel.close with the error. Frames are discarded until the
+// client replies with channel.close-ok.
+func (ch *channel) closeWith(err *amqpError) {
+	if ch.closing {
+		return
+	}
+	ch.closing = true
+	ch.cleanup()
+	ch.sendMethod(20, 40, func(e *encoder) { //nolint:gomnd // channel.close
+		e.u16(err.code)
+		e.shortstr(err.text)
+		e.u16(err.classID)
+		e.u16(err.methodID)
+	})
+}
+
+// cleanup cancels the consumers of the channel and requeues the messages that
+// are not acknowledged.
+func (ch *channel) cleanup() {
+	for _, c := range ch.consumers {
+		ch.conn.srv.removeConsumer(c)
+	}
+	clear(ch.consumers)
+	unacked := ch.unacked
+	ch.unacked = nil
+	ch.conn.srv.requeue(unacked)
+	ch.publish = nil
+}
+
+// canDeliver reports whether the prefetch limit of the channel allows another
+// message to be delivered.
+func (ch *channel) canDeliver() bool {
+	return !ch.closing && (ch.prefetch == 0 || len(ch.unacked) < ch.prefetch)
+}
+
+// deliver sends the message to the consumer.
+func (ch *channel) deliver(c *consumer, m *message) {
+	ch.nextTag++
+	tag := ch.nextTag
+	if !c.noAck {
+		ch.unacked = append(ch.unacked, delivery{tag: tag, msg: m, queue: c.queue})
+	}
+	ch.sendContent(methodFrame(ch.id, 60, 60, func(e *encoder) { //nolint:gomnd // basic.deliver
+		e.shortstr(c.tag)
+		e.u64(tag)
+		e.bits(m.redelivered)
+		e.shortstr(m.exchange)
+		e.shortstr(m.routingKey)
+	}), m)
+	ch.conn.srv.delivered()
+}
+
+// sendContent sends the method followed by the content of the message.
+func (ch *channel) sendContent(method frame, m *message) {
+	var hdr encoder
+	hdr.u16(60) //nolint:gomnd // basic class
+	hdr.u16(0)
+	hdr.u64(uint64(len(m.body)))
+	hdr.properties(&m.props)
+
+	frames := []frame{method, {typ: frameHeader, channel: ch.id, payload: hdr.Bytes()}}
+	size := int(ch.conn.frameMax) - 8 //nolint:gomnd // frame header and end
+	for body := m.body; len(body) > 0; {
+		n := min(len(body), size)
+		frames = append(frames, frame{typ: frameBody, channel: ch.id, payload: body[:n]})
+		body = body[n:]
+	}
+	ch.conn.send(frames...)
+}
+
+// handle handles a frame on the channel.
+func (ch *channel) handle(f frame) *amqpError {
+	d := &decoder{b: f.payload}
+	if ch.closing {
+		// After channel.close only channel.close-ok is expected.
+		if f.typ == frameMethod && d.u16() == 20 && d.u16() == 41 {
+			delete(ch.conn.channels, ch.id)
+		}
+		return nil
+	}
+
+	switch f.typ {
+	case frameHeader:
+		return ch.handleHeader(d)
+	case frameBody:
+		return ch.handleBody(f.payload)
+	case frameMethod:
+	default:
+		return newError(codeFrameError, "unknown frame type %d", f.typ)
+	}
+	if ch.publish != nil {
+		return newError(codeUnexpectedFrame, "expected content of basic.publish")
+	}
+
+	class, method := d.u16(), d.u16()
+	err := ch.handleMethod(class, method, d)
+	if err == nil && d.err != nil {
+		err = newError(codeFrameError, "%v", d.err)
+	}
+	if err != nil {
+		err.classID, err.methodID = class, method
+	}
+	return err
+}
+
+//nolint:gocyclo,cyclop // one case per method
+func (ch *channel) handleMethod(class, method uint16, d *decoder) *amqpError {
+	switch {
+	case class == 20 && method == 20: // channel.flow
+		active := d.u8()&1 != 0
+		ch.sendMethod(20, 21, func(e *encoder) { e.bits(active) }) //nolint:gomnd // channel.flow-ok
+	case class == 20 && method == 40: // channel.close
+		ch.cleanup()
+		delete(ch.conn.channels, ch.id)
+		ch.sendMethod(20, 41, nil) //nolint:gomnd // channel.close-ok
+	case class == 40 && method == 10: // exchange.declare
+		return ch.exchangeDeclare(d)
+	case class == 40 && method == 20: // exchange.delete
+		return ch.exchangeDelete(d)
+	case class == 40 && method == 30: // exchange.bind
+		return ch.exchangeBind(d)
+	case class == 40 && method == 40: // exchange.unbind
+		return ch.exchangeUnbind(d)
+	case class == 50 && method == 10: // queue.declare
+		return ch.queueDeclare(d)
+	case class == 50 && method == 20: // queue.bind
+		return ch.queueBind(d)
+	case class == 50 && method == 30: // queue.purge
+		return ch.queuePurge(d)
+	case class == 50 && method == 40: // queue.delete
+		return ch.queueDelete(d)
+	case class == 50 && method == 50: // queue.unbind
+		return ch.queueUnbind(d)
+	case class == 60 && method == 10: // basic.qos
+		d.u32() // prefetch size
+		ch.prefetch = int(d.u16())
+		ch.sendMethod(60, 11, nil) //nolint:gomnd // basic.qos-ok
+		ch.conn.srv.sweep()
+	case class == 60 && method == 20: // basic.consume
+		return ch.basicConsume(d)
+	case class == 60 && method == 30: // basic.cancel
+		return ch.basicCancel(d)
+	case class == 60 && method == 40: // basic.publish
+		return ch.basicPublish(d)
+	case class == 60 && method == 70: // basic.get
+		return ch.basicGet(d)
+	case class == 60 && method == 80: // basic.ack
+		tag := d.u64()
+		return ch.settle(tag, d.u8()&1 != 0, func(delivery) {})
+	case class == 60 && method == 90: // basic.reject
+		tag := d.u64()
+		return ch.reject(tag, false, d.u8()&1 != 0)
+	case class == 60 && method == 120: // basic.nack
+		tag := d.u64()
+		bits := d.u8()
+		return ch.reject(tag, bits&1 != 0, bits&2 != 0) //nolint:gomnd // requeue bit
+	case class == 60 && (method == 100 || method == 110): // basic.recover
+		unacked := ch.unacked
+		ch.unacked = nil
+		ch.conn.srv.requeue(unacked)
+		if method == 110 {
+			ch.sendMethod(60, 111, nil) //nolint:gomnd // basic.recover-ok
+		}
+	case class == 85 && method == 10: // confirm.select
+		ch.confirm = true
+		if d.u8()&1 == 0 {
+			ch.sendMethod(85, 11, nil) //nolint:gomnd // confirm.select-ok
+		}
+	default:
+		return newError(codeNotImplemented, "method %d.%d is not implemented", class, method)
+	}
+	return nil
+}
+
+func (ch *channel) exchangeDeclare(d *decoder) *amqpError {
+	d.u16()
+	name, kind := d.shortstr(), d.shortstr()
+	bits := d.u8()
+	passive, durable, autoDelete, internal, noWait := bits&1 != 0, bits&2 != 0, bits&4 != 0, bits&8 != 0, bits&16 != 0
+	args := d.table()
+
+	srv := ch.conn.srv
+	ex, ok := srv.exchanges[name]
+	switch {
+	case passive && !ok:
+		return newError(codeNotFound, "no exchange '%s'", name)
+	case passive:
+	case ok && (ex.kind != kind || ex.durable != durable || ex.autoDelete != autoDelete || ex.internal != internal):
+		return newError(codePreconditionFailed, "inequivalent arg for exchange '%s'", name)
+	case ok && !argsEqual(ex.args, args):
+		return newError(codePreconditionFailed, "inequivalent arguments for exchange '%s'", name)
+	case ok:
+	case name == "" || strings.HasPrefix(name, "amq."):
+		return newError(codeAccessRefused, "exchange name '%s' contains reserved prefix 'amq.'", name)
+	case kind != kindDirect && kind != kindFanout && kind != kindTopic && kind != kindHeaders:
+		return newError(codeCommandInvalid, "unknown exchange type '%s'", kind)
+	default:
+		srv.exchanges[name] = &exchange{
+			name: name, kind: kind, durable: durable, autoDelete: autoDelete, internal: internal, args: args,
+		}
+	}
+	if !noWait {
+		ch.sendMethod(40, 11, nil) //nolint:gomnd // exchange.declare-ok
+	}
+	return nil
+}
+
+func (ch *channel) exchangeDelete(d *decoder) *amqpError {
+	d.u16()
+	name := d.shortstr()
+	bits := d.u8()
+	ifUnused, noWait := bits&1 != 0, bits&2 != 0
+
+	srv := ch.conn.srv
+	if ex, ok := srv.exchanges[name]; ok {
+		if ifUnused && len(ex.bindings) > 0 {
+			return newError(codePreconditionFailed, "exchange '%s' in use", name)
+		}
+		if name == "" || strings.HasPrefix(name, "amq.") {
+			return newError(codeAccessRefused, "cannot delete exchange '%s'", name)
+		}
+		delete(srv.exchanges, name)
+		for _, other := range srv.exchanges {
+			other.unbind(func(b binding) bool { return b.exchange == name })
+		}
+	}
+	if !noWait {
+		ch.sendMethod(40, 21, nil) //nolint:gomnd // exchange.delete-ok
+	}
+	return nil
+}
+
+func (ch *channel) exchangeBind(d *decoder) *amqpError {
+	d.u16()
+	dst, src, key := d.shortstr(), d.shortstr(), d.shortstr()
+	noWait := d.u8()&1 != 0
+	args := d.table()
+
+	srv := ch.conn.srv
+	for _, name := range []string{dst, src} {
+		if _, ok := srv.exchanges[name]; !ok || name == "" {
+			return newError(codeNotFound, "no exchange '%s'", name)
+		}
+	}
+	srv.exchanges[src].bind(binding{exchange: dst, key: key, args: args})
+	if !noWait {
+		ch.sendMethod(40, 31, nil) //nolint:gomnd // exchange.bind-ok
+	}
+	return nil
+}
+
+func (ch *channel) exchangeUnbind(d *decoder) *amqpError {
+	d.u16()
+	dst, src, key := d.shortstr(), d.shortstr(), d.shortstr()
+	noWait := d.u8()&1 != 0
+	args := d.table()
+
+	if ex, ok := ch.conn.srv.exchanges[src]; ok {
+		b := binding{exchange: dst, key: key, args: args}
+		ex.unbind(b.equal)
+	}
+	if !noWait {
+		ch.sendMethod(40, 51, nil) //nolint:gomnd // exchange.unbind-ok
+	}
+	return nil
+}
+
+func (ch *channel) queueDeclare(d *decoder) *amqpError {
+	d.u16()
+	name := d.shortstr()
+	bits := d.u8()
+	passive, durable, exclusive, autoDelete, noWait := bits&1 != 0, bits&2 != 0, bits&4 != 0, bits&8 != 0, bits&16 != 0
+	args := d.table()
+
+	srv := ch.conn.srv
+	q, ok := srv.queues[name]
+	switch {
+	case ok && q.exclusive && q.owner != ch.conn:
+		return newError(codeResourceLocked, "cannot obtain exclusive access to locked queue '%s'", name)
+	case passive && !ok:
+		return newError(codeNotFound, "no queue '%s'", name)
+	case passive:
+	case ok && (q.durable != durable || q.exclusive != exclusive || q.autoDelete != autoDelete):
+		return newError(codePreconditionFailed, "inequivalent arg for queue '%s'", name)
+	case ok && !argsEqual(q.args, args):
+		return newError(codePreconditionFailed, "inequivalent arguments for queue '%s'", name)
+	case ok:
+	case strings.HasPrefix(name, "amq."):
+		return newError(codeAccessRefused, "queue name '%s' contains reserved prefix 'amq.'", name)
+	default:
+		if name == "" {
+			name = srv.generateName("amq.gen-")
+		}
+		q = newQueue(name, args)
+		q.durable, q.exclusive, q.autoDelete = durable, exclusive, autoDelete
+		if exclusive {
+			q.owner = ch.conn
+		}
+		srv.queues[name] = q
+	}
+	q.usedAt = srv.now()
+	if !noWait {
+		ch.sendMethod(50, 11, func(e *encoder) { //nolint:gomnd // queue.declare-ok
+			e.shortstr(q.name)
+			e.u32(uint32(len(q.messages)))
+			e.u32(uint32(len(q.consumers)))
+		})
+	}
+	return nil
+}
+
+func (ch *channel) queueBind(d *decoder) *amqpError {
+	d.u16()
+	qname, exname, key := d.shortstr(), d.shortstr(), d.shortstr()
+	noWait := d.u8()&1 != 0
+	args := d.table()
+
+	srv := ch.conn.srv
+	if _, ok := srv.queues[qname]; !ok {
+		return newError(codeNotFound, "no queue '%s'", qname)
+	}
+	ex, ok := srv.exchanges[exname]
+	if !ok || exname == "" {
+		return newError(codeNotFound, "no exchange '%s'", exname)
+	}
+	ex.bind(binding{queue: qname, key: key, args: args})
+	if !noWait {
+		ch.sendMethod(50, 21, nil) //nolint:gomnd // queue.bind-ok
+	}
+	return nil
+}
+
+func (ch *channel) queueUnbind(d *decoder) *amqpError {
+	d.u16()
+	qname, exname, key := d.shortstr(), d.shortstr(), d.shortstr()
+	args := d.table()
+
+	if ex, ok := ch.conn.srv.exchanges[exname]; ok {
+		b := binding{queue: qname, key: key, args: args}
+		ex.unbind(b.equal)
+	}
+	ch.sendMethod(50, 51, nil) //nolint:gomnd // queue.unbind-ok
+	return nil
+}
+
+func (ch *channel) queuePurge(d *decoder) *amqpError {
+	d.u16()
+	name := d.shortstr()
+	noWait := d.u8()&1 != 0
+
+	q, ok := ch.conn.srv.queues[name]
+	if !ok {
+		return newError(codeNotFound, "no queue '%s'", name)
+	}
+	n := len(q.messages)
+	q.messages = nil
+	if !noWait {
+		ch.sendMethod(50, 31, func(e *encoder) { e.u32(uint32(n)) }) //nolint:gomnd // queue.purge-ok
+	}
+	return nil
+}
+
+func (ch *channel) queueDelete(d *decoder) *amqpError {
+	d.u16()
+	name := d.shortstr()
+	bits := d.u8()
+	ifUnused, ifEmpty, noWait := bits&1 != 0, bits&2 != 0, bits&4 != 0
+
+	srv := ch.conn.srv
+	n := 0
+	if q, ok := srv.queues[name]; ok {
+		if q.exclusive && q.owner != ch.conn {
+			return newError(codeResourceLocked, "cannot obtain exclusive access to locked queue '%s'", name)
+		}
+		if ifUnused && len(q.consumers) > 0 {
+			return newError(codePreconditionFailed, "queue '%s' in use", name)
+		}
+		if ifEmpty && len(q.messages) > 0 {
+			return newError(codePreconditionFailed, "queue '%s' not empty", name)
+		}
+		n = len(q.messages)
+		srv.deleteQueue(q)
+	}
+	if !noWait {
+		ch.sendMethod(50, 41, func(e *encoder) { e.u32(uint32(n)) }) //nolint:gomnd // queue.delete-ok
+	}
+	return nil
+}
+
+func (ch *channel) basicConsume(d *decoder) *amqpError {
+	d.u16()
+	qname, tag := d.shortstr(), d.shortstr()
+	bits := d.u8()
+	noAck, exclusive, noWait := bits&2 != 0, bits&4 != 0, bits&8 != 0
+	d.table()
+
+	srv := ch.conn.srv
+	if qname == directReplyTo {
+		return ch.consumeReplies(tag, noAck, noWait)
+	}
+	q, ok := srv.queues[qname]
+	if !ok {
+		return newError(codeNotFound, "no queue '%s'", qname)
+	}
+	if q.exclusive && q.owner != ch.conn {
+		return newError(codeResourceLocked, "cannot obtain exclusive access to locked queue '%s'", qname)
+	}
+	if exclusive && len(q.consumers) > 0 {
+		return newError(codeResourceLocked, "queue '%s' in exclusive use", qname)
+	}
+	if tag == "" {
+		tag = srv.generateName("amq.ctag-")
+	}
+	if _, ok := ch.consumers[tag]; ok {
+		return newError(codeNotAllowed, "attempt to reuse consumer tag '%s'", tag)
+	}
+
+	c := &consumer{tag: tag, ch: ch, queue: q, noAck: noAck}
+	ch.consumers[tag] = c
+	q.consumers = append(q.consumers, c)
+	if !noWait {
+		ch.sendMethod(60, 21, func(e *encoder) { e.shortstr(tag) }) //nolint:gomnd // basic.consume-ok
+	}
+	srv.dispatch(q)
+	return nil
+}
+
+// consumeReplies consumes the replies to the messages published on the
+// channel with the direct reply-to pseudo-queue as reply-to.
+func (ch *channel) consumeReplies(tag string, noAck, noWait bool) *amqpError {
+	srv := ch.conn.srv
+	if !noAck {
+		return newError(codePreconditionFailed, "reply consumer cannot acknowledge")
+	}
+	if ch.replyTo != nil {
+		return newError(codePreconditionFailed, "reply consumer already set")
+	}
+	if tag == "" {
+		tag = srv.generateName("amq.ctag-")
+	}
+	if _, ok := ch.consumers[tag]; ok {
+		return newError(codeNotAllowed, "attempt to reuse consumer tag '%s'", tag)
+	}
+
+	ch.replyTo = &consumer{tag: tag, ch: ch, noAck: true}
+	ch.replyKey = srv.generateName(directReplyTo + ".")
+	ch.consumers[tag] = ch.replyTo
+	srv.replies[ch.replyKey] = ch
+	if !noWait {
+		ch.sendMethod(60, 21, func(e *encoder) { e.shortstr(tag) }) //nolint:gomnd // basic.consume-ok
+	}
+	return nil
+}
+
+func (ch *channel) basicCancel(d *decoder) *amqpError {
+	tag := d.shortstr()
+	noWait := d.u8()&1 != 0
+	if c, ok := ch.consumers[tag]; ok {
+		delete(ch.consumers, tag)
+		ch.conn.srv.removeConsumer(c)
+	}
+	if !noWait {
+		ch.sendMethod(60, 31, func(e *encoder) { e.shortstr(tag) }) //nolint:gomnd // basic.cancel-ok
+	}
+	return nil
+}
+
+func (ch *channel) basicPublish(d *decoder) *amqpError {
+	d.u16()
+	exname, key := d.shortstr(), d.shortstr()
+	mandatory := d.u8()&1 != 0
+	if _, ok := ch.conn.srv.exchanges[exname]; !ok {
+		return newError(codeNotFound, "no exchange '%s'", exname)
+	}
+	ch.publish = &publishing{
+		msg:       message{exchange: exname, routingKey: key},
+		mandatory: mandatory,
+	}
+	return nil
+}
+
+func (ch *channel) handleHeader(d *decoder) *amqpError {
+	p := ch.publish
+	if p == nil || p.header {
+		return newError(codeUnexpectedFrame, "unexpected content header")
+	}
+	d.u16() // class
+	d.u16() // weight
+	p.size = d.u64()
+	p.msg.props = d.properties()
+	p.header = true
+	if d.err != nil {
+		return newError(codeFrameError, "%v", d.err)
+	}
+	if p.size == 0 {
+		return ch.published()
+	}
+	return nil
+}
+
+func (ch *channel) handleBody(b []byte) *amqpError {
+	p := ch.publish
+	if p == nil || !p.header {
+		return newError(codeUnexpectedFrame, "unexpected content body")
+	}
+	p.msg.body = append(p.msg.body, b...)
+	if uint64(len(p.msg.body)) > p.size {
+		return newError(codeFrameError, "content body exceeds the declared size")
+	}
+	if uint64(len(p.msg.body)) == p.size {
+		return ch.published()
+	}
+	return nil
+}
+
+// published routes the message once all of its content is received.
+func (ch *channel) published() *amqpError {
+	p := ch.publish
+	ch.publish = nil
+
+	srv := ch.conn.srv
+	if p.msg.props.replyTo == directReplyTo {
+		if ch.replyTo == nil {
+			return newError(codePreconditionFailed, "fast reply consumer does not exist")
+		}
+		p.msg.props.replyTo = ch.replyKey
+	}
+
+	ex := srv.exchanges[p.msg.exchange]
+	var queues []*queue
+	var reply *channel // the channel to which a reply is sent directly
+	switch {
+	case p.msg.exchange == "" && strings.HasPrefix(p.msg.routingKey, directReplyTo+"."):
+		// Replies to channels which are gone are dropped.
+		reply = srv.replies[p.msg.routingKey]
+	case ex != nil:
+		queues = srv.route(ex, p.msg.routingKey, p.msg.props.headers)
+	}
+	if len(queues) == 0 && reply == nil && p.mandatory {
+		ch.sendContent(methodFrame(ch.id, 60, 50, func(e *encoder) { //nolint:gomnd // basic.return
+			e.u16(codeNoRoute)
+			e.shortstr("NO_ROUTE")
+			e.shortstr(p.msg.exchange)
+			e.shortstr(p.msg.routingKey)
+		}), &p.msg)
+	}
+
+	nack := srv.nackPublishes
+	if !nack {
+		for _, q := range queues {
+			srv.enqueue(q, &p.msg)
+		}
+		if reply != nil {
+			reply.deliver(reply.replyTo, &p.msg)
+		}
+	}
+	if ch.confirm {
+		ch.sequence++
+		seq := ch.sequence
+		method := uint16(80) //nolint:gomnd // basic.ack
+		if nack {
+			method = 120 // basic.nack
+		}
+		ch.sendMethod(60, method, func(e *encoder) { //nolint:gomnd // basic class
+			e.u64(seq)
+			e.bits(false) // multiple
+		})
+	}
+	return nil
+}
+
+func (ch *channel) basicGet(d *decoder) *amqpError {
+	d.u16()
+	name := d.shortstr()
+	noAck := d.u8()&1 != 0
+
+	srv := ch.conn.srv
+	q, ok := srv.queues[name]
+	if !ok {
+		return newError(codeNotFound, "no queue '%s'", name)
+	}
+	for len(q.messages) > 0 && srv.expire(q) {
+	}
+	if len(q.messages) == 0 {
+		ch.sendMethod(60, 72, func(e *encoder) { e.shortstr("") }) //nolint:gomnd // basic.get-empty
+		return nil
+	}
+
+	m := q.messages[0]
+	q.messages = q.messages[1:]
+	ch.nextTag++
+	tag := ch.nextTag
+	if !noAck {
+		ch.unacked = append(ch.unacked, delivery{tag: tag, msg: m, queue: q})
+	}
+	ch.sendContent(methodFrame(ch.id, 60, 71, func(e *encoder) { //nolint:gomnd // basic.get-ok
+		e.u64(tag)
+		e.bits(m.redelivered)
+		e.shortstr(m.exchange)
+		e.shortstr(m.routingKey)
+		e.u32(uint32(len(q.messages)))
+	}), m)
+	srv.delivered()
+	return nil
+}
+
+// settle removes the delivery with the given tag from the unacknowledged
+// deliveries, or all deliveries up to the tag if multiple is set, and passes
+// each of them to f.
+func (ch *channel) settle(tag uint64, multiple bool, f func(delivery)) *amqpError {
+	var settled []delivery
+	rest := ch.unacked[:0]
+	for _, d := range ch.unacked {
+		if d.tag == tag || (multiple && (tag == 0 || d.tag < tag)) {
+			settled = append(settled, d)
+		} else {
+			rest = append(rest, d)
+		}
+	}
+	if tag != 0 && (len(settled) == 0 || settled[len(settled)-1].tag != tag) {
+		return newError(codePreconditionFailed, "unknown delivery tag %d", tag)
+	}
+	ch.unacked = rest
+	for _, d := range settled {
+		f(d)
+	}
+	// Settling frees up capacity for more deliveries.
+	for _, d := range settled {
+		ch.conn.srv.dispatch(d.queue)
+	}
+	return nil
+}
+
+// reject settles the deliveries, which are either requeued or dead-lettered.
+func (ch *channel) reject(tag uint64, multiple, requeue bool) *amqpError {
+	var requeued []delivery
+	err := ch.settle(tag, multiple, func(d delivery) {
+		if requeue {
+			requeued = append(requeued, d)
+		} else {
+			ch.conn.srv.deadLetter(d.queue, d.msg, "rejected")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	ch.conn.srv.requeue(requeued)
+	return nil
+}
@@ -0,0 +1,437 @@
+package amqptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// The frame types of the protocol.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+// protocolHeader is sent by clients when opening a connection.
+const protocolHeader = "AMQP\x00\x00\x09\x01"
+
+// errMalformed is returned when a frame cannot be decoded.
+var errMalformed = errors.New("malformed frame")
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r io.Reader, frameMax uint32) (frame, error) {
+	var hdr [7]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err //nolint:wrapcheck // intentional
+	}
+	f := frame{typ: hdr[0], channel: binary.BigEndian.Uint16(hdr[1:3])}
+	size := binary.BigEndian.Uint32(hdr[3:7])
+	if frameMax > 0 && size > frameMax {
+		return frame{}, fmt.Errorf("%w: frame of %d bytes exceeds the maximum", errMalformed, size)
+	}
+	f.payload = make([]byte, size+1)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err //nolint:wrapcheck // intentional
+	}
+	if f.payload[size] != frameEnd {
+		return frame{}, fmt.Errorf("%w: missing frame end", errMalformed)
+	}
+	f.payload = f.payload[:size]
+	return f, nil
+}
+
+func (f frame) encode() []byte {
+	b := make([]byte, 7, 8+len(f.payload))
+	b[0] = f.typ
+	binary.BigEndian.PutUint16(b[1:3], f.channel)
+	binary.BigEndian.PutUint32(b[3:7], uint32(len(f.payload)))
+	b = append(b, f.payload...)
+	return append(b, frameEnd)
+}
+
+// decoder reads the fields of a frame payload. The first error is kept, and
+// all following reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if len(d.b) < n {
+		d.err = fmt.Errorf("%w: unexpected end of payload", errMalformed)
+		return make([]byte, n)
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8   { return d.next(1)[0] }
+func (d *decoder) u16() uint16 { return binary.BigEndian.Uint16(d.next(2)) }
+func (d *decoder) u32() uint32 { return binary.BigEndian.Uint32(d.next(4)) }
+func (d *decoder) u64() uint64 { return binary.BigEndian.Uint64(d.next(8)) }
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.u8())))
+}
+
+func (d *decoder) longstr() []byte {
+	return d.next(int(d.u32()))
+}
+
+func (d *decoder) table() map[string]any {
+	t := make(map[string]any)
+	sub := &decoder{b: d.longstr()}
+	for len(sub.b) > 0 && sub.err == nil {
+		key := sub.shortstr()
+		t[key] = sub.value()
+	}
+	if d.err == nil {
+		d.err = sub.err
+	}
+	return t
+}
+
+// decimal is the decimal field value of the protocol.
+type decimal struct {
+	scale uint8
+	value int32
+}
+
+//nolint:gocyclo,cyclop // one case per field type
+func (d *decoder) value() any {
+	switch typ := d.u8(); typ {
+	case 't':
+		return d.u8() != 0
+	case 'b':
+		return int8(d.u8())
+	case 'B':
+		return d.u8()
+	case 's':
+		return int16(d.u16())
+	case 'u':
+		return d.u16()
+	case 'I':
+		return int32(d.u32())
+	case 'i':
+		return d.u32()
+	case 'l':
+		return int64(d.u64())
+	case 'L':
+		return d.u64()
+	case 'f':
+		return math.Float32frombits(d.u32())
+	case 'd':
+		return math.Float64frombits(d.u64())
+	case 'D':
+		return decimal{scale: d.u8(), value: int32(d.u32())}
+	case 'S':
+		return string(d.longstr())
+	case 'x':
+		return append([]byte(nil), d.longstr()...)
+	case 'T':
+		return time.Unix(int64(d.u64()), 0)
+	case 'F':
+		return d.table()
+	case 'A':
+		var a []any
+		sub := &decoder{b: d.longstr()}
+		for len(sub.b) > 0 && sub.err == nil {
+			a = append(a, sub.value())
+		}
+		if d.err == nil {
+			d.err = sub.err
+		}
+		return a
+	case 'V':
+		return nil
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: unknown field type %q", errMalformed, typ)
+		}
+		return nil
+	}
+}
+
+// encoder writes the fields of a frame payload.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) u8(v uint8) { e.WriteByte(v) }
+
+func (e *encoder) u16(v uint16) {
+	e.Write(binary.BigEndian.AppendUint16(nil, v))
+}
+
+func (e *encoder) u32(v uint32) {
+	e.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (e *encoder) u64(v uint64) {
+	e.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (e *encoder) bits(bits ...bool) {
+	var b uint8
+	for i, bit := range bits {
+		if bit {
+			b |= 1 << i
+		}
+	}
+	e.u8(b)
+}
+
+func (e *encoder) shortstr(s string) {
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	e.u8(uint8(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) longstr(b []byte) {
+	e.u32(uint32(len(b)))
+	e.Write(b)
+}
+
+func (e *encoder) table(t map[string]any) {
+	var sub encoder
+	for k, v := range t {
+		sub.shortstr(k)
+		sub.value(v)
+	}
+	e.longstr(sub.Bytes())
+}
+
+//nolint:gocyclo,cyclop // one case per field type
+func (e *encoder) value(v any) {
+	switch v := v.(type) {
+	case bool:
+		e.u8('t')
+		if v {
+			e.u8(1)
+		} else {
+			e.u8(0)
+		}
+	case int8:
+		e.u8('b')
+		e.u8(uint8(v))
+	case uint8:
+		e.u8('B')
+		e.u8(v)
+	case int16:
+		e.u8('s')
+		e.u16(uint16(v))
+	case uint16:
+		e.u8('u')
+		e.u16(v)
+	case int32:
+		e.u8('I')
+		e.u32(uint32(v))
+	case int:
+		e.u8('l')
+		e.u64(uint64(v))
+	case uint32:
+		e.u8('i')
+		e.u32(v)
+	case int64:
+		e.u8('l')
+		e.u64(uint64(v))
+	case uint64:
+		e.u8('L')
+		e.u64(v)
+	case float32:
+		e.u8('f')
+		e.u32(math.Float32bits(v))
+	case float64:
+		e.u8('d')
+		e.u64(math.Float64bits(v))
+	case decimal:
+		e.u8('D')
+		e.u8(v.scale)
+		e.u32(uint32(v.value))
+	case string:
+		e.u8('S')
+		e.longstr([]byte(v))
+	case []byte:
+		e.u8('x')
+		e.longstr(v)
+	case time.Time:
+		e.u8('T')
+		e.u64(uint64(v.Unix()))
+	case map[string]any:
+		e.u8('F')
+		e.table(v)
+	case []any:
+		e.u8('A')
+		var sub encoder
+		for _, item := range v {
+			sub.value(item)
+		}
+		e.longstr(sub.Bytes())
+	default:
+		e.u8('V')
+	}
+}
+
+// properties are the basic properties of a message, carried by the content
+// header frame.
+type properties struct {
+	contentType     string
+	contentEncoding string
+	headers         map[string]any
+	deliveryMode    uint8
+	priority        uint8
+	correlationID   string
+	replyTo         string
+	expiration      string
+	messageID       string
+	timestamp       uint64
+	typ             string
+	userID          string
+	appID           string
+}
+
+// The flags of the basic properties, in the order in which the properties are
+// encoded.
+const (
+	flagContentType = 1 << (15 - iota)
+	flagContentEncoding
+	flagHeaders
+	flagDeliveryMode
+	flagPriority
+	flagCorrelationID
+	flagReplyTo
+	flagExpiration
+	flagMessageID
+	flagTimestamp
+	flagType
+	flagUserID
+	flagAppID
+	flagClusterID
+)
+
+//nolint:gocyclo,cyclop // one branch per property
+func (d *decoder) properties() properties {
+	var p properties
+	flags := d.u16()
+	if flags&flagContentType != 0 {
+		p.contentType = d.shortstr()
+	}
+	if flags&flagContentEncoding != 0 {
+		p.contentEncoding = d.shortstr()
+	}
+	if flags&flagHeaders != 0 {
+		p.headers = d.table()
+	}
+	if flags&flagDeliveryMode != 0 {
+		p.deliveryMode = d.u8()
+	}
+	if flags&flagPriority != 0 {
+		p.priority = d.u8()
+	}
+	if flags&flagCorrelationID != 0 {
+		p.correlationID = d.shortstr()
+	}
+	if flags&flagReplyTo != 0 {
+		p.replyTo = d.shortstr()
+	}
+	if flags&flagExpiration != 0 {
+		p.expiration = d.shortstr()
+	}
+	if flags&flagMessageID != 0 {
+		p.messageID = d.shortstr()
+	}
+	if flags&flagTimestamp != 0 {
+		p.timestamp = d.u64()
+	}
+	if flags&flagType != 0 {
+		p.typ = d.shortstr()
+	}
+	if flags&flagUserID != 0 {
+		p.userID = d.shortstr()
+	}
+	if flags&flagAppID != 0 {
+		p.appID = d.shortstr()
+	}
+	if flags&flagClusterID != 0 {
+		d.shortstr() // deprecated
+	}
+	return p
+}
+
+//nolint:gocyclo,cyclop // one branch per property
+func (e *encoder) properties(p *properties) {
+	var flags uint16
+	var body encoder
+	if p.contentType != "" {
+		flags |= flagContentType
+		body.shortstr(p.contentType)
+	}
+	if p.contentEncoding != "" {
+		flags |= flagContentEncoding
+		body.shortstr(p.contentEncoding)
+	}
+	if len(p.headers) > 0 {
+		flags |= flagHeaders
+		body.table(p.headers)
+	}
+	if p.deliveryMode != 0 {
+		flags |= flagDeliveryMode
+		body.u8(p.deliveryMode)
+	}
+	if p.priority != 0 {
+		flags |= flagPriority
+		body.u8(p.priority)
+	}
+	if p.correlationID != "" {
+		flags |= flagCorrelationID
+		body.shortstr(p.correlationID)
+	}
+	if p.replyTo != "" {
+		flags |= flagReplyTo
+		body.shortstr(p.replyTo)
+	}
+	if p.expiration != "" {
+		flags |= flagExpiration
+		body.shortstr(p.expiration)
+	}
+	if p.messageID != "" {
+		flags |= flagMessageID
+		body.shortstr(p.messageID)
+	}
+	if p.timestamp != 0 {
+		flags |= flagTimestamp
+		body.u64(p.timestamp)
+	}
+	if p.typ != "" {
+		flags |= flagType
+		body.shortstr(p.typ)
+	}
+	if p.userID != "" {
+		flags |= flagUserID
+		body.shortstr(p.userID)
+	}
+	if p.appID != "" {
+		flags |= flagAppID
+		body.shortstr(p.appID)
+	}
+	e.u16(flags)
+	e.Write(body.Bytes())
+}
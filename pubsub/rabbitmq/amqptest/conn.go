@@ -0,0 +1,424 @@
+package amqptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The reply codes of the protocol.
+const (
+	codeConnectionForced   = 320
+	codeAccessRefused      = 403
+	codeNotFound           = 404
+	codeResourceLocked     = 405
+	codePreconditionFailed = 406
+	codeFrameError         = 501
+	codeCommandInvalid     = 503
+	codeChannelError       = 504
+	codeUnexpectedFrame    = 505
+	codeNotAllowed         = 530
+	codeNotImplemented     = 540
+	codeNoRoute            = 312
+)
+
+// The limits negotiated with the clients.
+const (
+	channelMax = 2047
+	frameMax   = 131072
+)
+
+// amqpError is a channel or connection exception, which closes the channel or
+// the connection respectively.
+type amqpError struct {
+	code     uint16
+	text     string
+	classID  uint16
+	methodID uint16
+}
+
+func (e *amqpError) Error() string {
+	return fmt.Sprintf("%d %s", e.code, e.text)
+}
+
+func newError(code uint16, format string, args ...any) *amqpError {
+	text := fmt.Sprintf(format, args...)
+	return &amqpError{code: code, text: strings.ToUpper(codeName(code)) + " - " + text}
+}
+
+func codeName(code uint16) string {
+	switch code {
+	case codeConnectionForced:
+		return "connection_forced"
+	case codeAccessRefused:
+		return "access_refused"
+	case codeNotFound:
+		return "not_found"
+	case codeResourceLocked:
+		return "resource_locked"
+	case codePreconditionFailed:
+		return "precondition_failed"
+	case codeFrameError:
+		return "frame_error"
+	case codeCommandInvalid:
+		return "command_invalid"
+	case codeChannelError:
+		return "channel_error"
+	case codeUnexpectedFrame:
+		return "unexpected_frame"
+	case codeNotAllowed:
+		return "not_allowed"
+	case codeNotImplemented:
+		return "not_implemented"
+	default:
+		return "error"
+	}
+}
+
+// conn is a client connection.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+
+	frameMax  uint32
+	heartbeat time.Duration
+
+	// The following fields are guarded by the mutex of the server.
+	channels map[uint16]*channel
+	closing  bool // the server sent connection.close
+
+	// out holds the frames waiting to be written.
+	outMu     sync.Mutex
+	outCond   *sync.Cond
+	out       [][]byte
+	outClosed bool
+	written   chan struct{} // closed when the writer stops
+}
+
+func newConn(srv *Server, nc net.Conn) *conn {
+	c := &conn{
+		srv:      srv,
+		nc:       nc,
+		frameMax: frameMax,
+		channels: make(map[uint16]*channel),
+		written:  make(chan struct{}),
+	}
+	c.outCond = sync.NewCond(&c.outMu)
+	return c
+}
+
+// send queues the frames for writing. The frames are written in order and
+// are never interleaved with the frames of other calls.
+func (c *conn) send(frames ...frame) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.outClosed {
+		return
+	}
+	for _, f := range frames {
+		c.out = append(c.out, f.encode())
+	}
+	c.outCond.Signal()
+}
+
+// write writes the queued frames until the connection is closed.
+func (c *conn) write() {
+	defer close(c.written)
+	w := bufio.NewWriter(c.nc)
+	for {
+		c.outMu.Lock()
+		for len(c.out) == 0 && !c.outClosed {
+			c.outCond.Wait()
+		}
+		out, closed := c.out, c.outClosed
+		c.out = nil
+		c.outMu.Unlock()
+
+		for _, b := range out {
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil || closed {
+			return
+		}
+	}
+}
+
+// shutdown stops writing frames, after the frames already queued.
+func (c *conn) shutdown() {
+	c.outMu.Lock()
+	c.outClosed = true
+	c.outCond.Signal()
+	c.outMu.Unlock()
+}
+
+// drop closes the network connection abruptly, without the closing handshake
+// of the protocol, once the frames already queued are written.
+func (c *conn) drop() {
+	c.shutdown()
+	go func() {
+		<-c.written
+		_ = c.nc.Close() //nolint:errcheck // intentional
+	}()
+}
+
+func (c *conn) sendMethod(channel, class, method uint16, args func(*encoder)) {
+	c.send(methodFrame(channel, class, method, args))
+}
+
+func methodFrame(channel, class, method uint16, args func(*encoder)) frame {
+	var e encoder
+	e.u16(class)
+	e.u16(method)
+	if args != nil {
+		args(&e)
+	}
+	return frame{typ: frameMethod, channel: channel, payload: e.Bytes()}
+}
+
+// serve runs the connection until the client or the server closes it.
+func (c *conn) serve() {
+	go c.write()
+	defer func() {
+		// Close the network connection once the queued frames, e.g.
+		// connection.close-ok, are written.
+		c.shutdown()
+		_ = c.nc.SetWriteDeadline(time.Now().Add(time.Second)) //nolint:errcheck // intentional
+		<-c.written
+		_ = c.nc.Close() //nolint:errcheck // intentional
+	}()
+
+	defer c.cleanup()
+	r := bufio.NewReader(c.nc)
+	if err := c.handshake(r); err != nil {
+		return
+	}
+
+	if c.heartbeat > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go c.heartbeats(done)
+	}
+
+	for {
+		if c.heartbeat > 0 {
+			// Clients are considered dead after missing two heartbeats.
+			_ = c.nc.SetReadDeadline(time.Now().Add(3 * c.heartbeat)) //nolint:errcheck,gomnd // intentional
+		}
+		f, err := readFrame(r, c.frameMax)
+		if err != nil {
+			if errors.Is(err, errMalformed) {
+				c.closeWith(&amqpError{code: codeFrameError, text: err.Error()})
+			}
+			return
+		}
+		if done := c.handle(f); done {
+			return
+		}
+	}
+}
+
+func (c *conn) heartbeats(done <-chan struct{}) {
+	ticker := time.NewTicker(c.heartbeat / 2) //nolint:gomnd // twice per interval
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.send(frame{typ: frameHeartbeat})
+		case <-done:
+			return
+		}
+	}
+}
+
+// handshake negotiates the connection, see section 2.2.4 of the specification.
+func (c *conn) handshake(r *bufio.Reader) error {
+	_ = c.nc.SetDeadline(time.Now().Add(10 * time.Second)) //nolint:errcheck,gomnd // intentional
+	defer c.nc.SetDeadline(time.Time{})                    //nolint:errcheck // intentional
+
+	hdr := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	if string(hdr) != protocolHeader {
+		_, _ = c.nc.Write([]byte(protocolHeader)) //nolint:errcheck // intentional
+		return fmt.Errorf("%w: unsupported protocol %q", errMalformed, hdr)
+	}
+
+	c.sendMethod(0, 10, 10, func(e *encoder) { //nolint:gomnd // connection.start
+		e.u8(0)
+		e.u8(9) //nolint:gomnd // version 0-9-1
+		e.table(map[string]any{
+			"product": "amqptest",
+			"capabilities": map[string]any{
+				"publisher_confirms":           true,
+				"consumer_cancel_notify":       true,
+				"basic.nack":                   true,
+				"per_consumer_qos":             true,
+				"authentication_failure_close": true,
+			},
+		})
+		e.longstr([]byte("PLAIN"))
+		e.longstr([]byte("en_US"))
+	})
+
+	d, err := c.expect(r, 10, 11) //nolint:gomnd // connection.start-ok
+	if err != nil {
+		return err
+	}
+	d.table() // client properties
+	mechanism := d.shortstr()
+	response := string(d.longstr())
+	if mechanism != "PLAIN" || response != "\x00"+c.srv.username+"\x00"+c.srv.password {
+		c.closeWith(newError(codeAccessRefused, "login was refused using authentication mechanism %s", mechanism))
+		return errors.New("access refused")
+	}
+
+	c.sendMethod(0, 10, 30, func(e *encoder) { //nolint:gomnd // connection.tune
+		e.u16(channelMax)
+		e.u32(frameMax)
+		e.u16(uint16(c.srv.heartbeat / time.Second))
+	})
+	if d, err = c.expect(r, 10, 31); err != nil { //nolint:gomnd // connection.tune-ok
+		return err
+	}
+	d.u16() // channel max
+	if n := d.u32(); n > 0 && n < c.frameMax {
+		c.frameMax = n
+	}
+	c.heartbeat = time.Duration(d.u16()) * time.Second
+
+	if d, err = c.expect(r, 10, 40); err != nil { //nolint:gomnd // connection.open
+		return err
+	}
+	vhost := d.shortstr()
+	if !c.srv.hasVHost(vhost) {
+		c.closeWith(newError(codeAccessRefused, "access to vhost '%s' refused", vhost))
+		return errors.New("access refused")
+	}
+	c.sendMethod(0, 10, 41, func(e *encoder) { e.shortstr("") }) //nolint:gomnd // connection.open-ok
+	return nil
+}
+
+// expect reads the next frame, which must be the given method on channel zero.
+func (c *conn) expect(r *bufio.Reader, class, method uint16) (*decoder, error) {
+	f, err := readFrame(r, c.frameMax)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{b: f.payload}
+	if f.typ != frameMethod || f.channel != 0 || d.u16() != class || d.u16() != method {
+		c.closeWith(newError(codeCommandInvalid, "expected method %d.%d", class, method))
+		return nil, errors.New("unexpected frame")
+	}
+	return d, d.err
+}
+
+// closeWith sends connection.close with the error. The connection is closed
+// when the client replies, or after a timeout.
+func (c *conn) closeWith(err *amqpError) {
+	c.sendMethod(0, 10, 50, func(e *encoder) { //nolint:gomnd // connection.close
+		e.u16(err.code)
+		e.shortstr(err.text)
+		e.u16(err.classID)
+		e.u16(err.methodID)
+	})
+	c.shutdown()
+	time.AfterFunc(time.Second, func() { _ = c.nc.Close() }) //nolint:errcheck // intentional
+}
+
+// handle handles a frame. It reports whether the connection is done.
+func (c *conn) handle(f frame) bool {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+
+	if c.closing {
+		// After connection.close only connection.close-ok is expected.
+		d := &decoder{b: f.payload}
+		return f.typ == frameMethod && f.channel == 0 && d.u16() == 10 && d.u16() == 51
+	}
+
+	var err *amqpError
+	switch {
+	case f.typ == frameHeartbeat:
+		return false
+	case f.channel == 0:
+		var done bool
+		done, err = c.handleConnection(f)
+		if done {
+			return true
+		}
+	default:
+		err = c.handleChannel(f)
+	}
+	if err != nil {
+		if err.code >= codeFrameError || err.code == codeConnectionForced {
+			c.closing = true
+			c.closeWith(err)
+			return false
+		}
+		if ch, ok := c.channels[f.channel]; ok {
+			ch.closeWith(err)
+		}
+	}
+	return false
+}
+
+// handleConnection handles the methods on channel zero.
+func (c *conn) handleConnection(f frame) (bool, *amqpError) {
+	d := &decoder{b: f.payload}
+	if f.typ != frameMethod {
+		return false, newError(codeUnexpectedFrame, "unexpected frame on channel 0")
+	}
+	class, method := d.u16(), d.u16()
+	switch {
+	case class == 10 && method == 50: // connection.close
+		c.sendMethod(0, 10, 51, nil) //nolint:gomnd // connection.close-ok
+		return true, nil
+	case class == 10 && method == 51: // connection.close-ok
+		return true, nil
+	default:
+		return false, newError(codeCommandInvalid, "unexpected method %d.%d on channel 0", class, method)
+	}
+}
+
+// handleChannel handles the frames on the other channels.
+func (c *conn) handleChannel(f frame) *amqpError {
+	ch, ok := c.channels[f.channel]
+	if !ok {
+		d := &decoder{b: f.payload}
+		if f.typ != frameMethod || d.u16() != 20 || d.u16() != 10 { //nolint:gomnd // channel.open
+			return newError(codeChannelError, "expected channel.open on channel %d", f.channel)
+		}
+		if f.channel > channelMax {
+			return newError(codeChannelError, "channel %d exceeds the maximum", f.channel)
+		}
+		c.channels[f.channel] = newChannel(c, f.channel)
+		c.sendMethod(f.channel, 20, 11, func(e *encoder) { e.longstr(nil) }) //nolint:gomnd // channel.open-ok
+		return nil
+	}
+	return ch.handle(f)
+}
+
+// cleanup releases the resources of the connection once it is closed. The
+// unacknowledged messages are requeued and the exclusive queues are deleted.
+func (c *conn) cleanup() {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	for _, ch := range c.channels {
+		ch.cleanup()
+	}
+	c.channels = nil
+	for _, q := range c.srv.queues {
+		if q.exclusive && q.owner == c {
+			c.srv.deleteQueue(q)
+		}
+	}
+	c.srv.removeConn(c)
+}
@@ -0,0 +1,379 @@
+// Package amqptest provides an in-process AMQP 0-9-1 server for integration
+// tests of code using a RabbitMQ message broker, e.g. [rabbitmq.Bus].
+//
+// The server implements the subset of the protocol used by the client library:
+// direct, fanout, topic and headers exchanges, queues with bindings, bindings
+// between exchanges, publishing with publisher confirms, consuming with
+// acknowledgements and prefetch limits, message TTLs and dead-lettering, queue
+// expiry, and direct reply-to.
+// Messages are kept in memory only. Faults can be injected, e.g. dropping the
+// connections while messages are consumed:
+//
+//	func TestRedelivery(t *testing.T) {
+//		srv := amqptest.Start(t)
+//		bus, err := rabbitmq.NewAMQPBus(srv.Config(), "events")
+//		...
+//		srv.DropAfterDeliveries(1) // drop the connections after the first delivery
+//	}
+package amqptest
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/config"
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq"
+)
+
+// sweepInterval is the interval at which expired messages are dead-lettered.
+const sweepInterval = 10 * time.Millisecond
+
+// Server is an in-process AMQP 0-9-1 server listening on the loopback
+// interface.
+type Server struct {
+	ln        net.Listener
+	username  string
+	password  string
+	heartbeat time.Duration
+	vhosts    map[string]bool
+	wg        sync.WaitGroup
+	done      chan struct{}
+
+	// The following fields are guarded by mu.
+	mu            sync.Mutex
+	exchanges     map[string]*exchange
+	queues        map[string]*queue
+	conns         map[*conn]struct{}
+	replies       map[string]*channel // the channels by their direct reply-to key
+	closed        bool
+	nextID        int
+	nackPublishes bool
+	dropAfter     int // the number of deliveries before dropping connections
+}
+
+// Option configures a [Server].
+type Option func(*options)
+
+type options struct {
+	username  string
+	password  string
+	heartbeat time.Duration
+	vhosts    []string
+}
+
+// WithCredentials sets the credentials accepted by the server. The default
+// credentials are guest/guest.
+func WithCredentials(username, password string) Option {
+	return func(o *options) {
+		o.username, o.password = username, password
+	}
+}
+
+// WithVHosts sets the virtual hosts to which clients can connect. The default
+// virtual host is "/". All virtual hosts share the same exchanges and queues.
+func WithVHosts(vhosts ...string) Option {
+	return func(o *options) {
+		o.vhosts = vhosts
+	}
+}
+
+// WithHeartbeat sets the heartbeat interval proposed to clients. By default
+// the server proposes to disable heartbeats.
+func WithHeartbeat(d time.Duration) Option {
+	return func(o *options) {
+		o.heartbeat = d
+	}
+}
+
+// NewServer starts a new [Server]. The server must be closed by the caller.
+func NewServer(opts ...Option) (*Server, error) {
+	o := options{username: "guest", password: "guest", vhosts: []string{"/"}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	s := &Server{
+		ln:        ln,
+		username:  o.username,
+		password:  o.password,
+		heartbeat: o.heartbeat,
+		vhosts:    make(map[string]bool, len(o.vhosts)),
+		done:      make(chan struct{}),
+		exchanges: make(map[string]*exchange),
+		queues:    make(map[string]*queue),
+		conns:     make(map[*conn]struct{}),
+		replies:   make(map[string]*channel),
+	}
+	for _, vhost := range o.vhosts {
+		s.vhosts[vhost] = true
+	}
+	// Declare the exchanges that every broker provides.
+	s.exchanges[""] = &exchange{kind: kindDirect, durable: true}
+	for _, kind := range []string{kindDirect, kindFanout, kindTopic} {
+		name := "amq." + kind
+		s.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+
+	s.wg.Add(2) //nolint:gomnd // accept and sweep
+	go s.accept()
+	go s.sweepLoop()
+	return s, nil
+}
+
+// Start starts a new [Server] for the test. The test fails if the server cannot
+// be started. The server is closed when the test finishes.
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	s, err := NewServer(opts...)
+	if err != nil {
+		t.Fatalf("start amqp server: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := newConn(s, nc)
+		if !s.addConn(c) {
+			_ = nc.Close() //nolint:errcheck // intentional
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+func (s *Server) sweepLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.sweep()
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close stops the server. The connections are dropped without closing them
+// gracefully, like when the broker crashes.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	_ = s.ln.Close() //nolint:errcheck // intentional
+	for c := range s.conns {
+		_ = c.nc.Close() //nolint:errcheck // intentional
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Addr returns the address on which the server listens.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// URL returns the URL for connecting to the default virtual host of the server.
+func (s *Server) URL() string {
+	u := url.URL{Scheme: "amqp", User: url.UserPassword(s.username, s.password), Host: s.Addr(), Path: "/"}
+	return u.String()
+}
+
+// Config returns the configuration for connecting a [rabbitmq.Bus] to the
+// server.
+func (s *Server) Config() *rabbitmq.Config {
+	addr := s.ln.Addr().(*net.TCPAddr) //nolint:forcetypeassert // tcp listener
+	return &rabbitmq.Config{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: s.username,
+		Password: config.NewSecret(s.password),
+	}
+}
+
+// DropConnections closes all client connections abruptly, without the closing
+// handshake of the protocol, like when the network fails. The messages which
+// are not acknowledged are requeued.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropConnections()
+}
+
+func (s *Server) dropConnections() {
+	for c := range s.conns {
+		c.drop()
+	}
+}
+
+// CloseConnections closes all client connections gracefully, with the reply
+// code 320 (CONNECTION_FORCED), like when the broker is shut down.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if !c.closing {
+			c.closing = true
+			c.closeWith(newError(codeConnectionForced, "broker forced connection closure with reason 'shutdown'"))
+		}
+	}
+}
+
+// NackPublishes makes the server reject all published messages, while enabled.
+// Rejected messages are not routed to any queue, and channels in confirm mode
+// receive a basic.nack instead of a basic.ack.
+func (s *Server) NackPublishes(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nackPublishes = enabled
+}
+
+// DropAfterDeliveries drops all client connections, see
+// [Server.DropConnections], right after the next n messages are delivered to
+// consumers. The connections are dropped once only. Zero disables dropping.
+func (s *Server) DropAfterDeliveries(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropAfter = n
+}
+
+// delivered counts a message delivered to a consumer.
+func (s *Server) delivered() {
+	if s.dropAfter == 0 {
+		return
+	}
+	if s.dropAfter--; s.dropAfter == 0 {
+		s.dropConnections()
+	}
+}
+
+// Queues returns the names of the queues, in lexical order.
+func (s *Server) Queues() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.queues))
+	for name := range s.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Exchanges returns the names of the exchanges, in lexical order. The default
+// exchange, which has an empty name, is not included.
+func (s *Server) Exchanges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.exchanges))
+	for name := range s.exchanges {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Messages returns the number of messages in the queue that are ready to be
+// delivered. It returns zero if the queue does not exist.
+func (s *Server) Messages(queue string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[queue]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+// Unacked returns the number of messages from the queue that are delivered,
+// but not yet acknowledged.
+func (s *Server) Unacked(queue string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for c := range s.conns {
+		for _, ch := range c.channels {
+			for _, d := range ch.unacked {
+				if d.queue.name == queue {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// Consumers returns the number of consumers of the queue.
+func (s *Server) Consumers(queue string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[queue]; ok {
+		return len(q.consumers)
+	}
+	return 0
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// addConn registers an accepted connection. It reports false if the server is
+// closed.
+func (s *Server) addConn(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) removeConn(c *conn) {
+	delete(s.conns, c)
+}
+
+func (s *Server) hasVHost(vhost string) bool {
+	return s.vhosts[vhost]
+}
+
+func (s *Server) now() time.Time {
+	return time.Now()
+}
+
+// generateName returns a unique name with the given prefix.
+func (s *Server) generateName(prefix string) string {
+	s.nextID++
+	return prefix + strconv.Itoa(s.nextID)
+}
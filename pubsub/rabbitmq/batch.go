@@ -0,0 +1,119 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/eventscompass/service-framework/service"
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+var _ service.BatchPublisher = (*Bus)(nil)
+
+// PublishBatch publishes the messages in order, on a single channel. Unlike
+// [Bus.Publish], the messages are published with publisher confirms: a message
+// is only reported as published once the broker has confirmed it. The
+// confirmations are pipelined, i.e. all messages are sent before waiting for
+// the first confirmation. Delayed messages are published like with
+// [Bus.Publish].
+//
+// Once the context is done, no more messages are sent. The messages which were
+// sent but not yet confirmed are reported with the error of the context, they
+// may or may not be published.
+//
+// This function returns [service.BatchError] in case any of the messages could
+// not be published, with the errors of [Bus.Publish] for the messages. The
+// messages which are not confirmed by the broker are reported with
+// [ErrChanBroken].
+func (b *Bus) PublishBatch(ctx context.Context, msgs []service.Message) (err error) {
+	errs := make([]error, len(msgs))
+	defer func() {
+		for i, m := range msgs {
+			if errs[i] != nil {
+				publishErrors.Inc(b.exchange, m.Topic)
+			} else {
+				publishedMessages.Inc(b.exchange, m.Topic)
+			}
+		}
+	}()
+
+	ctx, span := tracing.Start(ctx, "publish batch",
+		tracing.WithKind(tracing.SpanKindProducer),
+		tracing.WithAttributes(
+			slog.String("messaging.system", "rabbitmq"),
+			slog.String("messaging.destination.name", b.exchange),
+			slog.Int("messaging.batch.message_count", len(msgs)),
+		),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	// fail reports the error for all messages from the i-th on.
+	fail := func(i int, err error) {
+		for ; i < len(msgs); i++ {
+			errs[i] = err
+		}
+	}
+
+	conn, err := b.broker.connect()
+	if err != nil {
+		fail(0, err)
+		return service.NewBatchError(errs)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		fail(0, fmt.Errorf("%w: open channel: %v", ErrConnBroken, err))
+		return service.NewBatchError(errs)
+	}
+	defer ch.Close() //nolint:errcheck // intentional
+
+	if err = b.declareExchange(ch); err != nil {
+		fail(0, err)
+		return service.NewBatchError(errs)
+	}
+	if err = ch.Confirm(false); err != nil {
+		fail(0, fmt.Errorf("%w: enable confirms: %v", ErrChanBroken, err))
+		return service.NewBatchError(errs)
+	}
+
+	confirms := make([]*amqp.DeferredConfirmation, len(msgs))
+	for i, m := range msgs {
+		if err := ctx.Err(); err != nil {
+			fail(i, err)
+			break
+		}
+		exchange, key, pub, err := b.publishing(ctx, m.Topic, m.Body, m.Options)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		confirms[i], err = ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, pub)
+		if err != nil {
+			// The channel cannot be used anymore.
+			fail(i, fmt.Errorf("%w: publish message: %v", ErrChanBroken, err))
+			break
+		}
+	}
+
+	// The broker confirms the messages in order, and closing the channel
+	// fails the pending confirmations.
+	for i, c := range confirms {
+		if c == nil {
+			continue
+		}
+		select {
+		case <-c.Done():
+			if !c.Acked() {
+				errs[i] = fmt.Errorf("%w: message not confirmed by the broker", ErrChanBroken)
+			}
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+	}
+	return service.NewBatchError(errs)
+}
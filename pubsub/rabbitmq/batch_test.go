@@ -0,0 +1,49 @@
+package rabbitmq_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq"
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq/amqptest"
+	"github.com/eventscompass/service-framework/service"
+)
+
+func TestPublishBatch(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "events")
+	ch := subscribe(t, srv, bus, "events", "event.*")
+	ctx := context.Background()
+	msgs := []service.Message{
+		{Topic: "event.created", Body: []byte("1")},
+		{Topic: "event.booked", Body: []byte("2")},
+		{Topic: "event.deleted", Body: []byte("3")},
+	}
+
+	srv.NackPublishes(true)
+	err := bus.PublishBatch(ctx, msgs)
+	var batchErr *service.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got error %v, want a batch error", err)
+	}
+	if got := len(batchErr.Failed()); got != len(msgs) {
+		t.Errorf("got %d failed messages, want %d", got, len(msgs))
+	}
+	if !errors.Is(err, rabbitmq.ErrChanBroken) {
+		t.Errorf("got error %v, want %v", err, rabbitmq.ErrChanBroken)
+	}
+	receiveNone(t, ch, 100*time.Millisecond)
+
+	srv.NackPublishes(false)
+	if err := bus.PublishBatch(ctx, msgs); err != nil {
+		t.Fatalf("publish batch: %v", err)
+	}
+	for _, m := range msgs {
+		if r := receive(t, ch); !bytes.Equal(r.msg, m.Body) || r.delivery.Topic != m.Topic {
+			t.Errorf("got message %q on %q, want %q on %q", r.msg, r.delivery.Topic, m.Body, m.Topic)
+		}
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 
 	"github.com/eventscompass/service-framework/service"
+	"github.com/eventscompass/service-framework/tracing"
 )
 
 var (
@@ -29,39 +34,67 @@ var (
 	ErrChanBroken = errors.New("connection channel broken")
 )
 
-// Config holds configuration variables for connecting to a RabbitMQ broker.
-type Config struct {
-	Host     string
-	Port     int
-	Username string
-	Password string
-}
-
 // Bus is a message bus backed by a RabbitMQ message broker.
 type Bus struct {
-	// conn is the connection to the RabbitMQ message broker.
-	conn *amqp.Connection
+	// broker holds the connection to the RabbitMQ message broker,
+	// which is shared by all buses.
+	broker *broker
 
 	// exchange is the exchange associated with this Bus.
 	exchange string
+
+	// codec compresses and decompresses the messages, and limits
+	// their size.
+	codec codec
+
+	// expiry is the time after which the queues of the
+	// subscriptions are deleted once they have no consumers.
+	expiry time.Duration
 }
 
+var _ service.MessageBus = (*Bus)(nil)
+
 // NewAMQPBus creates a new [Bus] instance which can be used to publish events
 // to the given exchange. If you want to publish to a different exchange then
 // simply create a new [Bus] instance, the same broker connection will be
-// re-used. This function returns [ErrConnBroken] in case the connection to the
-// message broker is broken.
+// re-used. If the connection is lost, then it is re-established when the bus is
+// used again, and a new connection is opened once it is closed with
+// [Bus.Close]. This function returns [service.ErrInvalidConfig] in case the
+// configuration is not valid, e.g. the URL cannot be parsed or the TLS files
+// cannot be loaded. This function returns [ErrConnFailed] in case the
+// connection to the message broker cannot be established. This function
+// returns [ErrConnBroken] in case the connection to the message broker is
+// broken.
 func NewAMQPBus(cfg *Config, exchange string) (*Bus, error) {
-	connInfo := fmt.Sprintf(
-		"amqp://%s:%s@%s:%d", cfg.Username, cfg.Password, cfg.Host, cfg.Port)
+	cfg, err := cfg.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrInvalidConfig, err)
+	}
+	urls, err := cfg.dialURLs()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrInvalidConfig, err)
+	}
+	dialCfg, err := cfg.amqpConfig()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrInvalidConfig, err)
+	}
+	c, err := newCodec(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrInvalidConfig, err)
+	}
 
-	// Use once.Do to make sure that a given micro-service creates only one
-	// rabbitmq connection even if it calls this function multiple times.
-	var err error
-	once.Do(func() { conn, err = amqp.Dial(connInfo) })
+	// Use a shared broker to make sure that a given micro-service creates
+	// only one rabbitmq connection even if it calls this function multiple
+	// times. The configuration of the first call is used.
+	sharedMu.Lock()
+	if shared == nil || shared.isClosed() {
+		shared = &broker{urls: urls, cfg: dialCfg}
+	}
+	br := shared
+	sharedMu.Unlock()
+	conn, err := br.connect()
 	if err != nil {
-		// TODO: maybe try using exponential backoff for connecting?
-		return nil, fmt.Errorf("%w: dial broker:  %v", ErrConnFailed, err)
+		return nil, err
 	}
 
 	// Make sure the connection is working by opening a channel on it.
@@ -73,45 +106,84 @@ func NewAMQPBus(cfg *Config, exchange string) (*Bus, error) {
 	defer ch.Close() //nolint:errcheck // intentional
 
 	return &Bus{
-		conn:     conn,
+		broker:   br,
 		exchange: exchange,
+		codec:    c,
+		expiry:   cfg.SubscriptionExpiry,
 	}, nil
 }
 
-// Publish publishes a message to a given topic. This function returns
-// [ErrConnClosed] in case the connection to the message broker is closed.
-// This function returns [ErrConnBroken] in case the connection is broken.
-// This function returns [ErrChanBroken] in case operations on the connection
-// channel fail.
-func (b *Bus) Publish(ctx context.Context, topic string, msg []byte) error {
-	if b.conn.IsClosed() {
-		return ErrConnClosed //nolint:wrapcheck // intentional
+// Publish publishes a message to a given topic.
+//
+// The delivery of the message can be delayed with [service.WithDelay] or
+// [service.WithDeliveryTime], by up to [MaxDelay]. Delayed messages are kept in
+// queues on the broker, and are delivered to the subscriptions of the buses
+// when they are due, rounded to whole seconds. They are not routed through the
+// exchange of the bus, so they only reach the subscriptions made with
+// [Bus.Subscribe].
+//
+// The message is compressed if configured, see [Config].
+//
+// This function returns [ErrConnClosed] in case the connection to the message
+// broker is closed. This function returns [ErrConnFailed] in case the
+// connection was lost and cannot be re-established. This function returns
+// [ErrConnBroken] in case the connection is broken. This function returns
+// [ErrChanBroken] in case operations on the connection channel fail. This
+// function returns [ErrDelayTooLong] in case the delay exceeds [MaxDelay].
+// This function returns [ErrMessageTooLarge] in case the message exceeds the
+// maximum message size.
+func (b *Bus) Publish(ctx context.Context, topic string, msg []byte, opts ...service.PublishOption) (err error) {
+	defer func() {
+		if err != nil {
+			publishErrors.Inc(b.exchange, topic)
+		} else {
+			publishedMessages.Inc(b.exchange, topic)
+		}
+	}()
+
+	ctx, span := tracing.Start(ctx, "publish "+topic,
+		tracing.WithKind(tracing.SpanKindProducer),
+		tracing.WithAttributes(
+			slog.String("messaging.system", "rabbitmq"),
+			slog.String("messaging.destination.name", b.exchange),
+			slog.String("messaging.rabbitmq.destination.routing_key", topic),
+		),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	exchange, key, pub, err := b.publishing(ctx, topic, msg, opts)
+	if err != nil {
+		return err
+	}
+
+	conn, err := b.broker.connect()
+	if err != nil {
+		return err
 	}
 
 	// Note that AMQP channels are not thread-safe. Thus, we will be creating a
 	// new channel for every published message. By using separate AMQP channels
 	// we can reuse the same AMQP connection concurrently.
-	ch, err := b.conn.Channel()
+	ch, err := conn.Channel()
 	if err != nil {
 		return fmt.Errorf("%w: open channel: %v", ErrConnBroken, err)
 	}
 	defer ch.Close() //nolint:errcheck // intentional
 
-	err = ch.ExchangeDeclare(b.exchange, "topic", true, false, false, false, nil)
-	if err != nil {
-		return fmt.Errorf("%w: declare exchange: %v", ErrChanBroken, err)
+	if err = b.declareExchange(ch); err != nil {
+		return err
 	}
 
 	err = ch.PublishWithContext(
 		ctx,
-		b.exchange, // exchange
-		topic,      // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        msg,
-		},
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
+		pub,
 	)
 	if err != nil {
 		// TODO: maybe we should retry publishing.
@@ -121,45 +193,207 @@ func (b *Bus) Publish(ctx context.Context, topic string, msg []byte) error {
 	return nil
 }
 
+// publishing returns the exchange and routing key to which the message is
+// published, and the message itself, compressed if configured. Delayed
+// messages are published to the delay levels, which are declared if needed.
+func (b *Bus) publishing(
+	ctx context.Context,
+	topic string,
+	msg []byte,
+	opts []service.PublishOption,
+) (string, string, amqp.Publishing, error) {
+	body, encoding, err := b.codec.encode(msg)
+	if err != nil {
+		return "", "", amqp.Publishing{}, err
+	}
+
+	o := service.NewPublishOptions(opts...)
+	exchange, key := b.exchange, topic
+	var delay time.Duration
+	if !o.DeliverAt.IsZero() {
+		delay = time.Until(o.DeliverAt).Round(time.Second)
+	}
+	if delay > MaxDelay {
+		return "", "", amqp.Publishing{}, fmt.Errorf("%w: %s exceeds %s", ErrDelayTooLong, delay, MaxDelay)
+	}
+	if delay > 0 {
+		if err := b.broker.declareTopology(delayTopology, true); err != nil {
+			return "", "", amqp.Publishing{}, err
+		}
+		exchange = delayExchangePrefix + strconv.Itoa(delayLevels-1)
+		key = delayRoutingKey(int64(delay/time.Second), b.exchange, topic)
+	}
+
+	// Propagate the trace context through the message headers.
+	headers := amqp.Table{}
+	for k, v := range o.Headers {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, tableCarrier(headers))
+	if !o.DeliverAt.IsZero() {
+		headers[topicHeader] = topic
+		headers[deliverAtHeader] = o.DeliverAt.UnixMilli()
+	}
+	return exchange, key, amqp.Publishing{
+		ContentType:     "application/json",
+		ContentEncoding: encoding,
+		Headers:         headers,
+		Timestamp:       time.Now(),
+		Body:            body,
+	}, nil
+}
+
 // Subscribe subscribes to the given topic. The event handler callback will be
 // executed on every received message. This function returns [ErrConnClosed] in
 // case the connection to the message broker is closed. This function returns
-// [ErrConnBroken] in case the connection is broken. This function returns
-// [ErrChanBroken] in case operations on the connection channel fail. This is a
-// blocking function. Canceling the context will cancel the subscription.
+// [ErrConnFailed] in case the connection was lost and cannot be re-established.
+// This function returns [ErrConnBroken] in case the connection is broken. This
+// function returns [ErrChanBroken] in case operations on the connection channel
+// fail. This is a blocking function. Canceling the context will cancel the
+// subscription. The subscription receives the delayed messages as well, see
+// [Bus.Publish], and the requests of [Bus.Request]. Compressed messages are
+// decompressed before they are passed to the handler. Messages which cannot be
+// decompressed, or exceed the maximum message size, are rejected.
+//
+// If the connection is lost while subscribed, the subscription is established
+// again on a new connection, retrying with exponential backoff. The topologies
+// of the bus are declared again on the new connection, see
+// [Bus.DeclareTopology]. The queue of the subscription is deleted once the
+// context is cancelled, or by the broker once it has no consumers for
+// [Config.SubscriptionExpiry], e.g. after the bus is closed.
 func (b *Bus) Subscribe(
 	ctx context.Context,
 	topic string,
 	eventHandler service.EventHandler,
-) error {
-	if b.conn.IsClosed() {
-		return ErrConnClosed //nolint:wrapcheck // intentional
+) (err error) {
+	defer func() {
+		if err != nil {
+			subscribeErrors.Inc(b.exchange, topic)
+		}
+	}()
+
+	// The queue is named by the bus, so that it is consumed again after the
+	// connection is lost, and the messages which were not acknowledged are
+	// redelivered. The queue outlives the connection, thus the broker deletes
+	// it once it has no consumers for the expiry of the bus, in case it is not
+	// deleted when the subscription is cancelled, e.g. because the bus is
+	// closed.
+	id, err := randomID()
+	if err != nil {
+		return fmt.Errorf("%w: queue name: %v", service.ErrUnexpected, err)
+	}
+	queue := b.exchange + ".subscription." + id
+
+	consumed, err := b.consume(ctx, queue, topic, eventHandler, false)
+	if !consumed {
+		return err
+	}
+	attempt := 0
+	for {
+		switch {
+		case ctx.Err() != nil, b.broker.isClosed():
+			return nil
+		case consumed:
+			// The deliveries stopped while the context is still live,
+			// i.e. the connection or the channel was lost.
+			slog.Warn("subscription lost, subscribing again", slog.String("topic", topic))
+			attempt = 0
+		case attempt >= resubscribeAttempts:
+			return err
+		default:
+			slog.Warn("failed to subscribe again",
+				slog.String("topic", topic),
+				slog.Int("attempt", attempt),
+				slog.String("error", err.Error()),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(resubscribeBackoff(attempt)):
+		}
+		attempt++
+		consumed, err = b.consume(ctx, queue, topic, eventHandler, true)
+	}
+}
+
+// The retries of [Bus.Subscribe] after the connection is lost.
+const (
+	// resubscribeAttempts is the number of attempts to subscribe
+	// again, after which the subscription fails.
+	resubscribeAttempts = 10
+
+	// The backoff between the attempts starts at the min delay
+	// and doubles until it reaches the max delay.
+	resubscribeMinDelay = 100 * time.Millisecond
+	resubscribeMaxDelay = 10 * time.Second
+)
+
+// resubscribeBackoff returns the time to wait before the given attempt.
+func resubscribeBackoff(attempt int) time.Duration {
+	if attempt >= 16 { //nolint:gomnd // the max delay is reached long before
+		return resubscribeMaxDelay
+	}
+	return min(resubscribeMinDelay<<attempt, resubscribeMaxDelay)
+}
+
+// consume subscribes to the topic with the queue on the current connection, and
+// passes the received messages to the event handler until the deliveries stop.
+// The queue is deleted once the context is done. It reports whether the
+// subscription was established, in which case the returned error is nil.
+// Subscribing again after the connection was lost is logged.
+func (b *Bus) consume(
+	ctx context.Context,
+	queue string,
+	topic string,
+	eventHandler service.EventHandler,
+	again bool,
+) (bool, error) {
+	// The delay levels are declared, so that the subscription can be bound
+	// to their delivery exchange.
+	if err := b.broker.declareTopology(delayTopology, true); err != nil {
+		return false, err
+	}
+	conn, err := b.broker.connect()
+	if err != nil {
+		return false, err
 	}
 
 	// AMQP channels are not thread-safe, thus we need to use a separate channel
 	// for every subscription, so that we can reuse the connection concurrently.
-	ch, err := b.conn.Channel()
+	ch, err := conn.Channel()
 	if err != nil {
-		return fmt.Errorf("%w: open channel: %v", ErrConnBroken, err)
+		return false, fmt.Errorf("%w: open channel: %v", ErrConnBroken, err)
 	}
 	defer ch.Close() //nolint:errcheck // intentional
 
 	// Before binding the queue, make sure the exchange exists.
-	err = ch.ExchangeDeclare(b.exchange, "topic", true, false, false, false, nil)
-	if err != nil {
-		return fmt.Errorf("%w: declare exchange: %v", ErrChanBroken, err)
+	if err := b.declareExchange(ch); err != nil {
+		return false, err
 	}
 
-	// Declare a queue with an arbitrary name and bind it to the exchange.
-	q, err := ch.QueueDeclare("", true, false, false, false, nil)
+	// Declare the queue, unless it exists already, and bind it to the exchange.
+	// Declaring the queue again restarts its expiry.
+	q, err := ch.QueueDeclare(queue, true, false, false, false, amqp.Table{
+		"x-expires": b.expiry.Milliseconds(),
+	})
 	if err != nil {
-		return fmt.Errorf("%w: declare queue: %v", ErrChanBroken, err)
+		return false, fmt.Errorf("%w: declare queue: %v", ErrChanBroken, err)
 	}
-	defer ch.QueueDelete(q.Name, false, false, true) //nolint:errcheck // intentional
+	defer func() {
+		if ctx.Err() != nil {
+			_, _ = ch.QueueDelete(q.Name, false, false, true) //nolint:errcheck // intentional
+		}
+	}()
 
 	err = ch.QueueBind(q.Name, topic, b.exchange, false, nil)
 	if err != nil {
-		return fmt.Errorf("%w: bind queue: %v", ErrChanBroken, err)
+		return false, fmt.Errorf("%w: bind queue: %v", ErrChanBroken, err)
+	}
+	err = ch.QueueBind(q.Name, delayBindingKey(b.exchange, topic), delayDeliveryExchange, false, nil)
+	if err != nil {
+		return false, fmt.Errorf("%w: bind queue: %v", ErrChanBroken, err)
 	}
 
 	msgs, err := ch.ConsumeWithContext(
@@ -173,32 +407,208 @@ func (b *Bus) Subscribe(
 		nil,    // args
 	)
 	if err != nil {
-		return fmt.Errorf("%w: consume queue: %v", ErrChanBroken, err)
+		return false, fmt.Errorf("%w: consume queue: %v", ErrChanBroken, err)
+	}
+	if again {
+		slog.Info("subscribed again", slog.String("topic", topic))
 	}
 
 	for msg := range msgs {
-		// Pass the message to the event handler.
-		eventHandler(ctx, msg.Body)
+		body, err := b.codec.decode(msg.Body, msg.ContentEncoding)
+		if err != nil {
+			// The message would fail again if it was requeued, thus it is
+			// rejected and dead-lettered, if the queue has a dead letter
+			// exchange.
+			slog.Error("rejected message",
+				slog.String("topic", msg.RoutingKey),
+				slog.String("error", err.Error()),
+			)
+			rejectedMessages.Inc(b.exchange, topic)
+			_ = msg.Reject(false) //nolint:errcheck // intentional
+			continue
+		}
+
+		// Pass the message to the event handler. The handler is run inside a
+		// consumer span, which continues the trace of the publisher.
+		msgCtx := tracing.Extract(ctx, tableCarrier(msg.Headers))
+		msgCtx, span := tracing.Start(msgCtx, "consume "+topic,
+			tracing.WithKind(tracing.SpanKindConsumer),
+			tracing.WithAttributes(
+				slog.String("messaging.system", "rabbitmq"),
+				slog.String("messaging.destination.name", b.exchange),
+				slog.String("messaging.rabbitmq.destination.routing_key", msg.RoutingKey),
+			),
+		)
+		msgCtx = service.ContextWithDelivery(msgCtx, delivery(msg))
+		if msg.ReplyTo != "" {
+			msgCtx = service.ContextWithReply(msgCtx, b.replyFunc(msg))
+		}
+		start := time.Now()
+		eventHandler(msgCtx, body)
+		consumedMessages.Inc(b.exchange, topic)
+		handlerDuration.Observe(time.Since(start).Seconds(), b.exchange, topic)
+		span.End()
 
 		// Ack the message only after we have finished processing.
 		_ = msg.Ack(false) //nolint:errcheck // intentional
 	}
-
-	return nil
+	return true, nil
 }
 
 // Close closes the connection to the message broker and releases all associated
-// resources. This function returns [ErrConnBroken] if it fails to close the
-// connection.
+// resources. The connection is shared by all buses, which cannot be used
+// anymore afterwards. This function returns [ErrConnBroken] if it fails to
+// close the connection.
 func (b *Bus) Close() error {
-	if err := b.conn.Close(); err != nil {
+	b.broker.mu.Lock()
+	defer b.broker.mu.Unlock()
+	b.broker.closed = true
+	if b.broker.conn == nil {
+		return nil
+	}
+	if err := b.broker.conn.Close(); err != nil {
 		return fmt.Errorf("%w: close conn: %v", ErrConnBroken, err)
 	}
 	return nil
 }
 
+// delivery returns the metadata of the message.
+func delivery(msg amqp.Delivery) service.Delivery {
+	d := service.Delivery{
+		Topic:       msg.RoutingKey,
+		PublishedAt: msg.Timestamp,
+	}
+	if topic, ok := msg.Headers[topicHeader].(string); ok {
+		d.Topic = topic
+	}
+	if ms, ok := msg.Headers[deliverAtHeader].(int64); ok {
+		d.DeliverAt = time.UnixMilli(ms)
+	}
+	for k, v := range msg.Headers {
+		if s, ok := v.(string); ok && !strings.HasPrefix(k, "x-") {
+			if d.Headers == nil {
+				d.Headers = make(map[string]string)
+			}
+			d.Headers[k] = s
+		}
+	}
+	return d
+}
+
+// declareExchange declares the exchange of the bus as a durable topic exchange,
+// unless it is declared by a topology, see [Bus.DeclareTopology].
+func (b *Bus) declareExchange(ch *amqp.Channel) error {
+	if b.broker.declares(b.exchange) {
+		return nil
+	}
+	err := ch.ExchangeDeclare(b.exchange, "topic", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("%w: declare exchange: %v", ErrChanBroken, err)
+	}
+	return nil
+}
+
+// broker holds the connection to the message broker.
+type broker struct {
+	mu         sync.Mutex
+	urls       []string
+	cfg        amqp.Config
+	conn       *amqp.Connection
+	closed     bool
+	topologies []Topology // declared again on every new connection
+}
+
 var (
 	// Use a singleton to make sure only one connection is open.
-	once sync.Once
-	conn *amqp.Connection
+	sharedMu sync.Mutex
+	shared   *broker
 )
+
+// isClosed reports whether the connection was closed with [Bus.Close].
+func (br *broker) isClosed() bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.closed
+}
+
+// connect returns the connection to the broker. If there is no connection yet,
+// or it was lost, then a new connection is established and the topologies are
+// declared on it.
+func (br *broker) connect() (*amqp.Connection, error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.connectLocked()
+}
+
+func (br *broker) connectLocked() (*amqp.Connection, error) {
+	if br.closed {
+		return nil, ErrConnClosed //nolint:wrapcheck // intentional
+	}
+	if br.conn != nil && !br.conn.IsClosed() {
+		return br.conn, nil
+	}
+
+	c, err := dial(br.urls, br.cfg)
+	if err != nil {
+		// TODO: maybe try using exponential backoff for connecting?
+		return nil, fmt.Errorf("%w: dial broker: %v", ErrConnFailed, err)
+	}
+	for i := range br.topologies {
+		if err := declare(c, &br.topologies[i]); err != nil {
+			_ = c.Close() //nolint:errcheck // intentional
+			return nil, err
+		}
+	}
+	if br.conn != nil {
+		slog.Info("reconnected to broker")
+	}
+	br.conn = c
+	return c, nil
+}
+
+// declares reports whether the exchange is declared by one of the topologies.
+func (br *broker) declares(exchange string) bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	for _, t := range br.topologies {
+		for _, ex := range t.Exchanges {
+			if ex.Name == exchange {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dial connects to the first of the brokers which can be reached, trying them
+// in order. The errors of all attempts are returned if none can be reached.
+func dial(urls []string, dialCfg amqp.Config) (*amqp.Connection, error) {
+	var errs []string
+	for _, addr := range urls {
+		cfg := dialCfg
+		if cfg.TLSClientConfig != nil {
+			// The server name is set for every dialed host.
+			cfg.TLSClientConfig = cfg.TLSClientConfig.Clone()
+		}
+		c, err := amqp.DialConfig(addr, cfg)
+		if err == nil {
+			watchConnection(c)
+			return c, nil
+		}
+		if len(urls) > 1 {
+			slog.Warn("failed to connect to broker", slog.String("url", addr), slog.String("error", err.Error()))
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, errors.New(strings.Join(errs, "; ")) //nolint:goerr113 // wrapped by the caller
+}
+
+// watchConnection keeps track of the state of the connection in the metrics.
+func watchConnection(c *amqp.Connection) {
+	connectionUp.Set(1)
+	closed := c.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-closed
+		connectionUp.Set(0)
+	}()
+}
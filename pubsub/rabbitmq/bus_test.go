@@ -0,0 +1,251 @@
+package rabbitmq_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq"
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq/amqptest"
+	"github.com/eventscompass/service-framework/service"
+)
+
+// timeout is the time the tests wait for a message.
+const timeout = 5 * time.Second
+
+type received struct {
+	msg      []byte
+	delivery service.Delivery
+}
+
+// newBus creates a bus connected to the server. The configuration can be
+// changed with the given functions.
+func newBus(t *testing.T, srv *amqptest.Server, exchange string, opts ...func(*rabbitmq.Config)) *rabbitmq.Bus {
+	t.Helper()
+	cfg := srv.Config()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	bus, err := rabbitmq.NewAMQPBus(cfg, exchange)
+	if err != nil {
+		t.Fatalf("new bus: %v", err)
+	}
+	t.Cleanup(func() { _ = bus.Close() })
+	return bus
+}
+
+// subscribe subscribes to the topic and waits until the subscription is
+// consumed. The received messages are sent to the returned channel, and the
+// subscription is cancelled when the test finishes.
+func subscribe(t *testing.T, srv *amqptest.Server, bus *rabbitmq.Bus, exchange, topic string) <-chan received {
+	t.Helper()
+	ch := make(chan received, 16)
+	subscribeWith(t, srv, bus, exchange, topic, func(ctx context.Context, msg []byte) {
+		d, _ := service.DeliveryFromContext(ctx)
+		ch <- received{msg: msg, delivery: d}
+	})
+	return ch
+}
+
+func subscribeWith(t *testing.T, srv *amqptest.Server, bus *rabbitmq.Bus, exchange, topic string, h service.EventHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	before := consumers(srv, exchange)
+	done := make(chan error, 1)
+	go func() { done <- bus.Subscribe(ctx, topic, h) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("subscribe to %q: %v", topic, err)
+			}
+		case <-time.After(timeout):
+			t.Errorf("subscription to %q not cancelled", topic)
+		}
+	})
+	waitFor(t, "subscription", func() bool { return consumers(srv, exchange) > before })
+}
+
+// consumers returns the number of consumers of the subscription queues of the
+// exchange.
+func consumers(srv *amqptest.Server, exchange string) int {
+	n := 0
+	for _, q := range srv.Queues() {
+		if strings.HasPrefix(q, exchange+".subscription.") {
+			n += srv.Consumers(q)
+		}
+	}
+	return n
+}
+
+// subscriptionQueue returns the name of the only subscription queue of the
+// exchange.
+func subscriptionQueue(t *testing.T, srv *amqptest.Server, exchange string) string {
+	t.Helper()
+	var queues []string
+	for _, q := range srv.Queues() {
+		if strings.HasPrefix(q, exchange+".subscription.") {
+			queues = append(queues, q)
+		}
+	}
+	if len(queues) != 1 {
+		t.Fatalf("got subscription queues %q, want one", queues)
+	}
+	return queues[0]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch <-chan received) received {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(timeout):
+		t.Fatal("no message received")
+		return received{}
+	}
+}
+
+func receiveNone(t *testing.T, ch <-chan received, d time.Duration) {
+	t.Helper()
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected message %q", r.msg)
+	case <-time.After(d):
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "events")
+	created := subscribe(t, srv, bus, "events", "event.created")
+	all := subscribe(t, srv, bus, "events", "event.#")
+
+	ctx := context.Background()
+	if err := bus.Publish(ctx, "event.created", []byte(`{"id":"1"}`), service.WithHeader("tenant", "a")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := bus.Publish(ctx, "event.deleted", []byte(`{"id":"2"}`)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	r := receive(t, created)
+	if string(r.msg) != `{"id":"1"}` {
+		t.Errorf("got message %q", r.msg)
+	}
+	if r.delivery.Topic != "event.created" {
+		t.Errorf("got topic %q, want event.created", r.delivery.Topic)
+	}
+	if got := r.delivery.Headers["tenant"]; got != "a" {
+		t.Errorf("got tenant header %q, want a", got)
+	}
+	if r.delivery.PublishedAt.IsZero() {
+		t.Error("publish time not set")
+	}
+	receiveNone(t, created, 100*time.Millisecond)
+
+	for _, want := range []string{`{"id":"1"}`, `{"id":"2"}`} {
+		if r := receive(t, all); string(r.msg) != want {
+			t.Errorf("got message %q, want %q", r.msg, want)
+		}
+	}
+}
+
+func TestRedeliveryAfterDroppedConnection(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "events")
+	ch := subscribe(t, srv, bus, "events", "event.created")
+	queue := subscriptionQueue(t, srv, "events")
+
+	// The connection is dropped before the message is acknowledged, thus it
+	// is delivered again once the subscription is established again. The
+	// first delivery may be lost with the connection.
+	srv.DropAfterDeliveries(1)
+	if err := bus.Publish(context.Background(), "event.created", []byte("1")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if r := receive(t, ch); string(r.msg) != "1" {
+		t.Fatalf("got message %q", r.msg)
+	}
+	waitFor(t, "acknowledgement", func() bool {
+		return srv.Consumers(queue) == 1 && srv.Messages(queue) == 0 && srv.Unacked(queue) == 0
+	})
+	select {
+	case r := <-ch:
+		if string(r.msg) != "1" {
+			t.Fatalf("got message %q", r.msg)
+		}
+	default:
+	}
+	receiveNone(t, ch, 100*time.Millisecond)
+}
+
+func TestSubscribeAfterReconnect(t *testing.T) {
+	for name, disconnect := range map[string]func(*amqptest.Server){
+		"dropped": (*amqptest.Server).DropConnections,
+		"closed":  (*amqptest.Server).CloseConnections,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := amqptest.Start(t)
+			bus := newBus(t, srv, "events")
+			ch := subscribe(t, srv, bus, "events", "event.created")
+
+			disconnect(srv)
+			waitFor(t, "disconnect", func() bool { return consumers(srv, "events") == 0 })
+			waitFor(t, "subscription", func() bool { return consumers(srv, "events") == 1 })
+
+			// Publishing reconnects as well.
+			if err := bus.Publish(context.Background(), "event.created", []byte("1")); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			if r := receive(t, ch); string(r.msg) != "1" {
+				t.Errorf("got message %q", r.msg)
+			}
+		})
+	}
+}
+
+func TestSubscriptionQueueExpiry(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "events", func(cfg *rabbitmq.Config) {
+		cfg.SubscriptionExpiry = 200 * time.Millisecond
+	})
+	subscribe(t, srv, bus, "events", "event.created")
+	queue := subscriptionQueue(t, srv, "events")
+
+	// The queue is kept while the subscription is established again on a new
+	// connection, and outlives its expiry as long as it is consumed.
+	srv.DropConnections()
+	waitFor(t, "disconnect", func() bool { return consumers(srv, "events") == 0 })
+	waitFor(t, "subscription", func() bool { return consumers(srv, "events") == 1 })
+	time.Sleep(300 * time.Millisecond)
+	if got := subscriptionQueue(t, srv, "events"); got != queue {
+		t.Errorf("got queue %q after reconnecting, want %q", got, queue)
+	}
+
+	// The queue is not deleted by the subscription when the bus is closed,
+	// but expires once it has no consumers.
+	if err := bus.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	waitFor(t, "queue expiry", func() bool {
+		for _, q := range srv.Queues() {
+			if q == queue {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,136 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrMessageTooLarge is returned when a message exceeds the maximum message
+// size, see [Config].
+var ErrMessageTooLarge = errors.New("message too large")
+
+// The content encodings of compressed messages, which are also the names of the
+// compression algorithms in the [Config].
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// zstdEncoder is shared by all buses, it is safe for concurrent use.
+var zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+	enc, _ := zstd.NewWriter(nil) //nolint:errcheck // fails only for invalid options
+	return enc
+})
+
+// codec compresses the bodies of the published messages, and decompresses the
+// bodies of the received messages. Messages are compressed one by one, and the
+// compression is signalled with the content encoding of the message, so that
+// compressed and uncompressed messages can be mixed.
+type codec struct {
+	// encoding is the compression of the published messages, or
+	// empty if they are not compressed.
+	encoding string
+
+	// threshold is the size in bytes from which messages are
+	// compressed.
+	threshold int
+
+	// maxSize is the maximum size of the messages in bytes,
+	// before compression and after decompression. Zero means no
+	// limit.
+	maxSize int
+}
+
+// newCodec returns the codec configured by cfg.
+func newCodec(cfg *Config) (codec, error) {
+	c := codec{threshold: cfg.CompressionThreshold, maxSize: cfg.MaxMessageSize}
+	switch cfg.Compression {
+	case "", "none":
+	case encodingGzip, encodingZstd:
+		c.encoding = cfg.Compression
+	default:
+		return codec{}, fmt.Errorf("unsupported compression %q", cfg.Compression)
+	}
+	return c, nil
+}
+
+// encode returns the body of the message to publish, and its content encoding.
+// Messages which are smaller than the threshold, or which do not get smaller
+// when compressed, are published as they are. This function returns
+// [ErrMessageTooLarge] in case the message exceeds the maximum size.
+func (c codec) encode(body []byte) ([]byte, string, error) {
+	if c.maxSize > 0 && len(body) > c.maxSize {
+		return nil, "", fmt.Errorf("%w: %d bytes exceed the maximum of %d", ErrMessageTooLarge, len(body), c.maxSize)
+	}
+	if c.encoding == "" || len(body) < c.threshold {
+		return body, "", nil
+	}
+
+	var compressed []byte
+	switch c.encoding {
+	case encodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, _ = w.Write(body) //nolint:errcheck // writing to a buffer does not fail
+		_ = w.Close()        //nolint:errcheck // writing to a buffer does not fail
+		compressed = buf.Bytes()
+	case encodingZstd:
+		compressed = zstdEncoder().EncodeAll(body, nil)
+	}
+	if len(compressed) >= len(body) {
+		return body, "", nil
+	}
+	return compressed, c.encoding, nil
+}
+
+// decode returns the decompressed body of a received message. Messages with
+// any of the supported encodings are decompressed, whether or not the bus
+// compresses the messages it publishes. This function returns
+// [ErrMessageTooLarge] in case the message exceeds the maximum size, either as
+// received or once decompressed.
+func (c codec) decode(body []byte, encoding string) ([]byte, error) {
+	if c.maxSize > 0 && len(body) > c.maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceed the maximum of %d", ErrMessageTooLarge, len(body), c.maxSize)
+	}
+
+	var r io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case encodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("decompress gzip: %w", err)
+		}
+		r = gr
+	case encodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("decompress zstd: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	// Stop decompressing once the limit is exceeded, rather than running
+	// out of memory on a decompression bomb.
+	if c.maxSize > 0 {
+		r = io.LimitReader(r, int64(c.maxSize)+1)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s: %w", encoding, err)
+	}
+	if c.maxSize > 0 && len(decompressed) > c.maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes once decompressed", ErrMessageTooLarge, c.maxSize)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,94 @@
+package rabbitmq_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq"
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq/amqptest"
+)
+
+func TestCompression(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "events", func(cfg *rabbitmq.Config) {
+		cfg.Compression = "gzip"
+		cfg.CompressionThreshold = 100
+	})
+	ch := subscribe(t, srv, bus, "events", "#")
+
+	// A queue consumed without the bus shows the messages as published.
+	conn, err := amqp.Dial(srv.URL())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	raw, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("open channel: %v", err)
+	}
+	if _, err := raw.QueueDeclare("raw", false, true, false, false, nil); err != nil {
+		t.Fatalf("declare queue: %v", err)
+	}
+	if err := raw.QueueBind("raw", "#", "events", false, nil); err != nil {
+		t.Fatalf("bind queue: %v", err)
+	}
+
+	small, large := []byte("small"), bytes.Repeat([]byte("event "), 100)
+	for _, msg := range [][]byte{small, large} {
+		if err := bus.Publish(context.Background(), "event.created", msg); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+		if r := receive(t, ch); !bytes.Equal(r.msg, msg) {
+			t.Errorf("got message %q, want %q", r.msg, msg)
+		}
+	}
+
+	for _, want := range []struct {
+		encoding string
+		size     int
+	}{{"", len(small)}, {"gzip", -1}} {
+		var d amqp.Delivery
+		waitFor(t, "raw message", func() bool {
+			var ok bool
+			d, ok, err = raw.Get("raw", true)
+			return err != nil || ok
+		})
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if d.ContentEncoding != want.encoding {
+			t.Errorf("got content encoding %q, want %q", d.ContentEncoding, want.encoding)
+		}
+		if want.size < 0 && len(d.Body) >= len(large) {
+			t.Errorf("compressed message has %d bytes, the original %d", len(d.Body), len(large))
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	srv := amqptest.Start(t)
+	publisher := newBus(t, srv, "events")
+	limited := newBus(t, srv, "events", func(cfg *rabbitmq.Config) { cfg.MaxMessageSize = 10 })
+	ch := subscribe(t, srv, limited, "events", "#")
+	ctx := context.Background()
+
+	err := limited.Publish(ctx, "event.created", bytes.Repeat([]byte("x"), 11))
+	if !errors.Is(err, rabbitmq.ErrMessageTooLarge) {
+		t.Errorf("got error %v, want %v", err, rabbitmq.ErrMessageTooLarge)
+	}
+
+	// Received messages which are too large are rejected.
+	if err := publisher.Publish(ctx, "event.created", bytes.Repeat([]byte("x"), 11)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := publisher.Publish(ctx, "event.created", []byte("small")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if r := receive(t, ch); string(r.msg) != "small" {
+		t.Errorf("got message %q, want small", r.msg)
+	}
+}
@@ -0,0 +1,120 @@
+package rabbitmq
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDelayTooLong is returned when the delivery of a message is delayed by
+// more than [MaxDelay].
+var ErrDelayTooLong = errors.New("delay too long")
+
+// Delayed messages are delivered without the delayed message exchange plugin.
+// Instead, they pass through a cascade of delay levels. Every level consists
+// of a topic exchange and a queue, whose messages expire after 2^level seconds
+// and are then dead-lettered to the exchange of the next lower level. The
+// routing key of a delayed message starts with the bits of its delay in
+// seconds, from the highest level down to level 0, e.g. "0.0...1.0.1" for 5s,
+// followed by the exchange and the topic of the message. The dots in the name
+// of the exchange are escaped, see [delayExchangeWord]. At every level, the
+// message is routed to the queue if its bit is set, and directly to the next
+// level otherwise. After level 0, the message is routed to the delivery
+// exchange, to which the subscriptions of all buses are bound.
+//
+// As all messages in the queue of a level expire after the same time, they
+// expire in order, and a long delay never holds up a shorter one.
+const (
+	// delayLevels is the number of delay levels.
+	delayLevels = 28
+
+	// delayExchangePrefix is the prefix of the names of the
+	// exchanges and queues of the delay levels.
+	delayExchangePrefix = "delay."
+
+	// delayDeliveryExchange is the exchange to which the delayed
+	// messages are routed when they are due.
+	delayDeliveryExchange = "delay.deliver"
+)
+
+// MaxDelay is the longest delay of the delivery of a message, about 8.5 years.
+// Delays are rounded to whole seconds.
+const MaxDelay = (1<<delayLevels - 1) * time.Second
+
+// The headers of delayed messages.
+const (
+	// topicHeader holds the topic to which a delayed message was
+	// published, as the routing key holds the delay as well.
+	topicHeader = "x-topic"
+
+	// deliverAtHeader holds the time at which a delayed message
+	// is due, in milliseconds since the Unix epoch.
+	deliverAtHeader = "x-deliver-at"
+)
+
+// delayTopology is the topology of the delay levels.
+var delayTopology = newDelayTopology()
+
+func newDelayTopology() Topology {
+	t := Topology{
+		Exchanges: []Exchange{{Name: delayDeliveryExchange}},
+	}
+	for level := delayLevels - 1; level >= 0; level-- {
+		name := delayExchangePrefix + strconv.Itoa(level)
+		next := delayDeliveryExchange
+		if level > 0 {
+			next = delayExchangePrefix + strconv.Itoa(level-1)
+		}
+
+		// The bit of the level is preceded by the bits of the higher
+		// levels.
+		prefix := strings.Repeat("*.", delayLevels-1-level)
+		ttl := int64(time.Duration(1<<level) * time.Second / time.Millisecond)
+		t.Exchanges = append(t.Exchanges, Exchange{Name: name})
+		t.Queues = append(t.Queues, Queue{
+			Name: name,
+			Args: map[string]any{
+				"x-message-ttl":          ttl,
+				"x-dead-letter-exchange": next,
+			},
+		})
+		t.Bindings = append(t.Bindings,
+			Binding{Source: name, Queue: name, Key: prefix + "1.#"},
+			Binding{Source: name, Exchange: next, Key: prefix + "0.#"},
+		)
+	}
+	return t
+}
+
+// delayRoutingKey returns the routing key with which a message that is
+// delayed by the given number of seconds is published to the exchange of the
+// highest delay level.
+func delayRoutingKey(seconds int64, exchange, topic string) string {
+	var b strings.Builder
+	for level := delayLevels - 1; level >= 0; level-- {
+		b.WriteByte('0' + byte(seconds>>level&1))
+		b.WriteByte('.')
+	}
+	b.WriteString(delayExchangeWord(exchange))
+	b.WriteByte('.')
+	b.WriteString(topic)
+	return b.String()
+}
+
+// delayBindingKey returns the key with which a subscription to the topic of
+// the exchange is bound to the delivery exchange.
+func delayBindingKey(exchange, topic string) string {
+	return strings.Repeat("*.", delayLevels) + delayExchangeWord(exchange) + "." + topic
+}
+
+// delayExchangeEscaper escapes the dots in the names of exchanges.
+var delayExchangeEscaper = strings.NewReplacer("%", "%25", ".", "%2E")
+
+// delayExchangeWord returns the name of the exchange as a single word of a
+// routing key, so that it is delimited from the topic. Otherwise the delayed
+// messages published to the topic "c" of the exchange "a.b" would be received
+// by the subscriptions to the topic "b.c" of the exchange "a".
+func delayExchangeWord(exchange string) string {
+	return delayExchangeEscaper.Replace(exchange)
+}
@@ -0,0 +1,47 @@
+package rabbitmq_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq"
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq/amqptest"
+	"github.com/eventscompass/service-framework/service"
+)
+
+func TestDelayedDelivery(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "a")
+	ch := subscribe(t, srv, bus, "a", "b.#")
+
+	// Delayed messages published to the topic "c" of the exchange "a.b"
+	// must not reach the subscriptions to "b.c" of the exchange "a".
+	other := newBus(t, srv, "a.b")
+	if err := other.Publish(context.Background(), "c", []byte("other"), service.WithDelay(time.Second)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	start := time.Now()
+	if err := bus.Publish(context.Background(), "b.c", []byte("1"), service.WithDelay(time.Second)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	r := receive(t, ch)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("delivered after %v, want 1s", elapsed)
+	}
+	if string(r.msg) != "1" || r.delivery.Topic != "b.c" {
+		t.Errorf("got message %q on %q", r.msg, r.delivery.Topic)
+	}
+	// The publish time has a precision of seconds.
+	if got := r.delivery.Delay(); got < time.Second || got >= 2*time.Second {
+		t.Errorf("got delay %v, want 1s", got)
+	}
+	receiveNone(t, ch, 200*time.Millisecond)
+
+	err := bus.Publish(context.Background(), "b.c", nil, service.WithDelay(rabbitmq.MaxDelay+time.Second))
+	if !errors.Is(err, rabbitmq.ErrDelayTooLong) {
+		t.Errorf("got error %v, want %v", err, rabbitmq.ErrDelayTooLong)
+	}
+}
@@ -0,0 +1,52 @@
+package rabbitmq
+
+import (
+	"github.com/eventscompass/service-framework/metrics"
+)
+
+var (
+	publishedMessages = metrics.NewCounter(
+		"rabbitmq_published_messages_total",
+		"Total number of messages published to the broker.",
+		"exchange", "topic",
+	)
+	publishErrors = metrics.NewCounter(
+		"rabbitmq_publish_errors_total",
+		"Total number of messages that failed to be published to the broker.",
+		"exchange", "topic",
+	)
+	consumedMessages = metrics.NewCounter(
+		"rabbitmq_consumed_messages_total",
+		"Total number of messages consumed from the broker.",
+		"exchange", "topic",
+	)
+	rejectedMessages = metrics.NewCounter(
+		"rabbitmq_rejected_messages_total",
+		"Total number of consumed messages that were rejected, e.g. because they were too large.",
+		"exchange", "topic",
+	)
+	subscribeErrors = metrics.NewCounter(
+		"rabbitmq_subscribe_errors_total",
+		"Total number of subscriptions that failed.",
+		"exchange", "topic",
+	)
+	handlerDuration = metrics.NewHistogram(
+		"rabbitmq_handler_duration_seconds",
+		"Latency of the event handlers processing consumed messages.",
+		nil, "exchange", "topic",
+	)
+	requestErrors = metrics.NewCounter(
+		"rabbitmq_request_errors_total",
+		"Total number of requests that failed or were not answered.",
+		"exchange", "topic",
+	)
+	requestDuration = metrics.NewHistogram(
+		"rabbitmq_request_duration_seconds",
+		"Latency of requests, from publishing until the reply is received.",
+		nil, "exchange", "topic",
+	)
+	connectionUp = metrics.NewGauge(
+		"rabbitmq_connection_up",
+		"Whether the connection to the broker is open (1) or not (0).",
+	)
+)
@@ -0,0 +1,200 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/eventscompass/service-framework/service"
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+// directReplyTo is the pseudo-queue from which the replies to requests are
+// consumed, without declaring a queue for them, see
+// https://www.rabbitmq.com/docs/direct-reply-to.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// The headers of a reply which carry the error of the request handler, see
+// [service.ReplyError].
+const (
+	errorCodeHeader = "x-error-code"
+	errorHeader     = "x-error"
+)
+
+var _ service.Requester = (*Bus)(nil)
+
+// Request publishes the message to the given topic and waits for the response,
+// or until the context is done. The request is answered by the subscriptions
+// which handle it with a [service.Responder]. The reply is sent with direct
+// reply-to, thus no queue is declared for it. If the context has a deadline,
+// then the request expires on the broker once the deadline is exceeded.
+//
+// This function returns [service.ErrNotFound] in case no subscription receives
+// the request. This function returns [service.ReplyError] in case the request
+// handler failed. This function returns [ErrConnClosed] in case the connection
+// to the message broker is closed. This function returns [ErrConnFailed] in
+// case the connection was lost and cannot be re-established. This function
+// returns [ErrConnBroken] in case the connection is broken. This function
+// returns [ErrChanBroken] in case operations on the connection channel fail.
+// This function returns [ErrMessageTooLarge] in case the request or the
+// response exceeds the maximum message size.
+func (b *Bus) Request(ctx context.Context, topic string, msg []byte) (resp []byte, err error) {
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			requestErrors.Inc(b.exchange, topic)
+		}
+		requestDuration.Observe(time.Since(start).Seconds(), b.exchange, topic)
+	}()
+
+	ctx, span := tracing.Start(ctx, "request "+topic,
+		tracing.WithKind(tracing.SpanKindClient),
+		tracing.WithAttributes(
+			slog.String("messaging.system", "rabbitmq"),
+			slog.String("messaging.destination.name", b.exchange),
+			slog.String("messaging.rabbitmq.destination.routing_key", topic),
+		),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	body, encoding, err := b.codec.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := b.broker.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	// The replies are sent to the channel on which the request is published,
+	// thus every request needs a channel of its own.
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: open channel: %v", ErrConnBroken, err)
+	}
+	defer ch.Close() //nolint:errcheck // intentional
+
+	if err = b.declareExchange(ch); err != nil {
+		return nil, err
+	}
+
+	// Direct reply-to requires consuming the replies before publishing.
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: consume replies: %v", ErrChanBroken, err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	id, err := randomID()
+	if err != nil {
+		return nil, fmt.Errorf("%w: correlation id: %v", service.ErrUnexpected, err)
+	}
+	headers := amqp.Table{}
+	tracing.Inject(ctx, tableCarrier(headers))
+	req := amqp.Publishing{
+		ContentType:     "application/json",
+		ContentEncoding: encoding,
+		Headers:         headers,
+		Timestamp:       time.Now(),
+		CorrelationId:   id,
+		ReplyTo:         directReplyTo,
+		Body:            body,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ttl := max(time.Until(deadline).Milliseconds(), 1)
+		req.Expiration = strconv.FormatInt(ttl, 10)
+	}
+
+	// The request is mandatory, so that it is returned if there is no
+	// subscription to receive it, instead of waiting for the deadline.
+	err = ch.PublishWithContext(ctx, b.exchange, topic, true, false, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: publish request: %v", ErrChanBroken, err)
+	}
+
+	for {
+		select {
+		case reply, ok := <-replies:
+			if !ok {
+				return nil, fmt.Errorf("%w: replies consumer cancelled", ErrChanBroken)
+			}
+			if reply.CorrelationId != id {
+				continue
+			}
+			if message, ok := reply.Headers[errorHeader].(string); ok {
+				code, _ := reply.Headers[errorCodeHeader].(string) //nolint:errcheck // empty if missing
+				return nil, &service.ReplyError{Code: code, Message: message}
+			}
+			return b.codec.decode(reply.Body, reply.ContentEncoding)
+		case <-returns:
+			return nil, fmt.Errorf("%w: no responder for topic %s", service.ErrNotFound, topic)
+		case amqpErr := <-closed:
+			return nil, fmt.Errorf("%w: channel closed: %v", ErrChanBroken, amqpErr)
+		case <-ctx.Done():
+			return nil, ctx.Err() //nolint:wrapcheck // intentional
+		}
+	}
+}
+
+// replyFunc returns the function which replies to the request. The reply is
+// published to the default exchange, which routes it to the requester.
+func (b *Bus) replyFunc(req amqp.Delivery) service.ReplyFunc {
+	return func(ctx context.Context, resp []byte, err error) error {
+		var body []byte
+		var encoding string
+		if err == nil {
+			// A response which is too large is replaced with the error, so
+			// that the requester does not wait for it in vain.
+			body, encoding, err = b.codec.encode(resp)
+		}
+		headers := amqp.Table{}
+		if err != nil {
+			replyErr := service.NewReplyError(err)
+			headers[errorCodeHeader] = replyErr.Code
+			headers[errorHeader] = replyErr.Message
+		}
+
+		conn, err := b.broker.connect()
+		if err != nil {
+			return err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return fmt.Errorf("%w: open channel: %v", ErrConnBroken, err)
+		}
+		defer ch.Close() //nolint:errcheck // intentional
+
+		err = ch.PublishWithContext(ctx, "", req.ReplyTo, false, false, amqp.Publishing{
+			ContentType:     "application/json",
+			ContentEncoding: encoding,
+			Headers:         headers,
+			Timestamp:       time.Now(),
+			CorrelationId:   req.CorrelationId,
+			Body:            body,
+		})
+		if err != nil {
+			return fmt.Errorf("%w: publish reply: %v", ErrChanBroken, err)
+		}
+		return nil
+	}
+}
+
+// randomID returns a random id, e.g. the id with which the reply is matched to
+// the request.
+func randomID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err //nolint:wrapcheck // wrapped by the caller
+	}
+	return hex.EncodeToString(b[:]), nil
+}
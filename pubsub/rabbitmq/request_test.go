@@ -0,0 +1,42 @@
+package rabbitmq_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eventscompass/service-framework/pubsub/rabbitmq/amqptest"
+	"github.com/eventscompass/service-framework/service"
+)
+
+func TestRequest(t *testing.T) {
+	srv := amqptest.Start(t)
+	bus := newBus(t, srv, "events")
+	subscribeWith(t, srv, bus, "events", "event.get", service.Responder(func(_ context.Context, msg []byte) ([]byte, error) {
+		if string(msg) == "missing" {
+			return nil, service.ErrNotFound
+		}
+		return append([]byte("event "), msg...), nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := bus.Request(ctx, "event.get", []byte("1"))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if string(resp) != "event 1" {
+		t.Errorf("got response %q", resp)
+	}
+
+	_, err = bus.Request(ctx, "event.get", []byte("missing"))
+	var replyErr *service.ReplyError
+	if !errors.As(err, &replyErr) || !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want a reply error wrapping %v", err, service.ErrNotFound)
+	}
+
+	_, err = bus.Request(ctx, "event.unknown", []byte("1"))
+	if !errors.Is(err, service.ErrNotFound) || errors.As(err, &replyErr) {
+		t.Errorf("got error %v, want %v", err, service.ErrNotFound)
+	}
+}
@@ -0,0 +1,371 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrInvalidTopology is returned when a topology is not valid, e.g. a binding
+// has no destination.
+var ErrInvalidTopology = errors.New("invalid topology")
+
+// Topology describes the exchanges, queues and bindings which a service needs
+// on the broker, e.g. dead letter exchanges and the queues of delayed messages.
+// It is declared with [Bus.DeclareTopology]:
+//
+//	err := bus.DeclareTopology(rabbitmq.Topology{
+//		Exchanges: []rabbitmq.Exchange{
+//			{Name: "events.dlx", Kind: "fanout"},
+//		},
+//		Queues: []rabbitmq.Queue{
+//			{Name: "events.dead", Args: map[string]any{"x-queue-type": "quorum"}},
+//		},
+//		Bindings: []rabbitmq.Binding{
+//			{Source: "events.dlx", Queue: "events.dead"},
+//		},
+//	})
+//
+// Declaring is idempotent: entities which exist with the same properties are
+// left as they are. Entities which exist with different properties cannot be
+// changed, they have to be deleted first, e.g. in the management UI.
+type Topology struct {
+	// Exchanges are declared first, then the Queues, then the
+	// Bindings.
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+// Exchange describes an exchange.
+type Exchange struct {
+	Name string
+
+	// Kind is the type of the exchange, i.e. "direct", "fanout",
+	// "topic", "headers", or a type added by a plugin. If empty,
+	// the exchange is a topic exchange, like the exchanges of
+	// [Bus].
+	Kind string
+
+	// Transient exchanges are deleted when the broker restarts,
+	// exchanges are durable otherwise.
+	Transient bool
+
+	// AutoDelete exchanges are deleted once their last binding is
+	// removed.
+	AutoDelete bool
+
+	// Internal exchanges cannot be published to, they only
+	// receive messages from other exchanges.
+	Internal bool
+
+	// Args are the optional arguments of the exchange, e.g.
+	// "alternate-exchange".
+	Args map[string]any
+}
+
+func (ex *Exchange) kind() string {
+	if ex.Kind == "" {
+		return "topic"
+	}
+	return ex.Kind
+}
+
+// Queue describes a queue.
+type Queue struct {
+	Name string
+
+	// Transient queues are deleted when the broker restarts,
+	// queues are durable otherwise.
+	Transient bool
+
+	// Exclusive queues are used by a single connection, and are
+	// deleted when it is closed.
+	Exclusive bool
+
+	// AutoDelete queues are deleted once their last consumer is
+	// cancelled.
+	AutoDelete bool
+
+	// Args are the optional arguments of the queue, e.g.
+	// "x-message-ttl", "x-dead-letter-exchange" or "x-queue-type".
+	Args map[string]any
+}
+
+// Binding binds a queue, or another exchange, to an exchange. Messages which
+// are published to the source exchange, and match the key and arguments, are
+// routed to the destination.
+type Binding struct {
+	// Source is the name of the exchange from which messages are
+	// routed.
+	Source string
+
+	// Queue or Exchange is the name of the destination. Exactly
+	// one of them must be set.
+	Queue    string
+	Exchange string
+
+	// Key is the binding key, e.g. a topic pattern for topic
+	// exchanges.
+	Key string
+
+	// Args are the arguments of the binding, e.g. the headers
+	// matched by headers exchanges.
+	Args map[string]any
+}
+
+func (b *Binding) String() string {
+	if b.Queue != "" {
+		return fmt.Sprintf("%s -> queue %s (%q)", b.Source, b.Queue, b.Key)
+	}
+	return fmt.Sprintf("%s -> exchange %s (%q)", b.Source, b.Exchange, b.Key)
+}
+
+// validate checks that all entities have names and all bindings have a single
+// destination.
+func (t *Topology) validate() error {
+	for i, ex := range t.Exchanges {
+		if ex.Name == "" {
+			return fmt.Errorf("%w: exchange %d has no name", ErrInvalidTopology, i)
+		}
+	}
+	for i, q := range t.Queues {
+		if q.Name == "" {
+			return fmt.Errorf("%w: queue %d has no name", ErrInvalidTopology, i)
+		}
+	}
+	for i, b := range t.Bindings {
+		if b.Source == "" {
+			return fmt.Errorf("%w: binding %d has no source", ErrInvalidTopology, i)
+		}
+		if (b.Queue == "") == (b.Exchange == "") {
+			return fmt.Errorf("%w: binding %d must have either a queue or an exchange", ErrInvalidTopology, i)
+		}
+	}
+	return nil
+}
+
+// DeclareTopology declares the exchanges, queues and bindings of the topology
+// on the broker. The topology is declared again whenever the connection to the
+// broker is re-established, so that e.g. exclusive queues are restored. The
+// exchange of the bus is not declared by the bus itself if it is part of the
+// topology, which allows for other types than topic exchanges. This function
+// returns [ErrInvalidTopology] in case the topology is not valid. This function
+// returns [ErrConnClosed] in case the connection to the message broker is
+// closed. This function returns [ErrConnFailed] in case the connection was lost
+// and cannot be re-established. This function returns [ErrChanBroken] in case
+// an entity cannot be declared, e.g. because it exists with other properties.
+func (b *Bus) DeclareTopology(t Topology) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	return b.broker.declareTopology(t, false)
+}
+
+// declareTopology declares the topology and keeps it for declaring it again
+// after a reconnect. If once is true, then a topology which was declared
+// already is not declared again.
+func (br *broker) declareTopology(t Topology, once bool) error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	conn, err := br.connectLocked()
+	if err != nil {
+		return err
+	}
+	declared := false
+	for _, other := range br.topologies {
+		declared = declared || reflect.DeepEqual(other, t)
+	}
+	if declared && once {
+		return nil
+	}
+	if err := declare(conn, &t); err != nil {
+		return err
+	}
+	if !declared {
+		br.topologies = append(br.topologies, t)
+	}
+	return nil
+}
+
+// declare declares the topology on the connection.
+func declare(conn *amqp.Connection, t *Topology) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("%w: open channel: %v", ErrConnBroken, err)
+	}
+	defer ch.Close() //nolint:errcheck // intentional
+
+	for _, ex := range t.Exchanges {
+		err := ch.ExchangeDeclare(ex.Name, ex.kind(), !ex.Transient, ex.AutoDelete, ex.Internal, false, ex.Args)
+		if err != nil {
+			return fmt.Errorf("%w: declare exchange %s: %v", ErrChanBroken, ex.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		_, err := ch.QueueDeclare(q.Name, !q.Transient, q.AutoDelete, q.Exclusive, false, q.Args)
+		if err != nil {
+			return fmt.Errorf("%w: declare queue %s: %v", ErrChanBroken, q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if b.Queue != "" {
+			err = ch.QueueBind(b.Queue, b.Key, b.Source, false, b.Args)
+		} else {
+			err = ch.ExchangeBind(b.Exchange, b.Key, b.Source, false, b.Args)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: bind %s: %v", ErrChanBroken, b.String(), err)
+		}
+	}
+	return nil
+}
+
+// Action is the effect which declaring an entity of a topology has on the
+// broker, see [Change].
+type Action string
+
+const (
+	// ActionCreate means that the entity does not exist and is
+	// created.
+	ActionCreate Action = "create"
+
+	// ActionConflict means that the entity exists with different
+	// properties, and declaring it fails.
+	ActionConflict Action = "conflict"
+
+	// ActionEnsure means that the binding is declared, which has
+	// no effect if it exists already. AMQP provides no way to
+	// tell whether a binding exists.
+	ActionEnsure Action = "ensure"
+)
+
+// Change is a change of the broker which declaring a topology would make.
+type Change struct {
+	Action Action
+
+	// Entity is the kind of the entity, i.e. "exchange", "queue"
+	// or "binding".
+	Entity string
+
+	// Name is the name of the entity, e.g. "events.dlx". Bindings
+	// are named by their source, destination and key.
+	Name string
+
+	// Reason is the reason for a conflict, as reported by the
+	// broker.
+	Reason string
+}
+
+func (c Change) String() string {
+	if c.Reason != "" {
+		return fmt.Sprintf("%s %s %s: %s", c.Action, c.Entity, c.Name, c.Reason)
+	}
+	return fmt.Sprintf("%s %s %s", c.Action, c.Entity, c.Name)
+}
+
+// DiffTopology returns the changes which declaring the topology would make,
+// without making them. Entities which exist with the same properties are not
+// listed. This function returns the errors of [Bus.DeclareTopology].
+func (b *Bus) DiffTopology(t Topology) ([]Change, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	conn, err := b.broker.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	created := make(map[string]bool)
+	for _, ex := range t.Exchanges {
+		ex := ex
+		action, reason, err := probe(conn,
+			func(ch *amqp.Channel) error {
+				return ch.ExchangeDeclarePassive(ex.Name, ex.kind(), !ex.Transient, ex.AutoDelete, ex.Internal, false, nil)
+			},
+			func(ch *amqp.Channel) error {
+				return ch.ExchangeDeclare(ex.Name, ex.kind(), !ex.Transient, ex.AutoDelete, ex.Internal, false, ex.Args)
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w: check exchange %s: %v", ErrChanBroken, ex.Name, err)
+		}
+		if action != "" {
+			changes = append(changes, Change{Action: action, Entity: "exchange", Name: ex.Name, Reason: reason})
+			created["exchange "+ex.Name] = action == ActionCreate
+		}
+	}
+	for _, q := range t.Queues {
+		q := q
+		action, reason, err := probe(conn,
+			func(ch *amqp.Channel) error {
+				_, err := ch.QueueDeclarePassive(q.Name, !q.Transient, q.AutoDelete, q.Exclusive, false, nil)
+				return err //nolint:wrapcheck // intentional
+			},
+			func(ch *amqp.Channel) error {
+				_, err := ch.QueueDeclare(q.Name, !q.Transient, q.AutoDelete, q.Exclusive, false, q.Args)
+				return err //nolint:wrapcheck // intentional
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w: check queue %s: %v", ErrChanBroken, q.Name, err)
+		}
+		if action != "" {
+			changes = append(changes, Change{Action: action, Entity: "queue", Name: q.Name, Reason: reason})
+			created["queue "+q.Name] = action == ActionCreate
+		}
+	}
+	for _, bd := range t.Bindings {
+		dst := "queue " + bd.Queue
+		if bd.Exchange != "" {
+			dst = "exchange " + bd.Exchange
+		}
+		action := ActionEnsure
+		if created["exchange "+bd.Source] || created[dst] {
+			action = ActionCreate
+		}
+		changes = append(changes, Change{Action: action, Entity: "binding", Name: bd.String()})
+	}
+	return changes, nil
+}
+
+// probe tells how declaring an entity would change the broker. The entity is
+// looked up with a passive declaration, which fails if it does not exist. If it
+// exists, then it is declared again, which has no effect if the properties are
+// the same, and fails otherwise. An empty action is returned if the entity
+// exists with the same properties.
+func probe(conn *amqp.Connection, passive, declare func(*amqp.Channel) error) (Action, string, error) {
+	// The broker closes the channel when a declaration fails, thus every
+	// declaration is made on a new channel.
+	try := func(f func(*amqp.Channel) error) error {
+		ch, err := conn.Channel()
+		if err != nil {
+			return err //nolint:wrapcheck // intentional
+		}
+		defer ch.Close() //nolint:errcheck // intentional
+		return f(ch)
+	}
+
+	var amqpErr *amqp.Error
+	err := try(passive)
+	switch {
+	case errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound:
+		return ActionCreate, "", nil
+	case errors.As(err, &amqpErr) && amqpErr.Code == amqp.ResourceLocked:
+		return ActionConflict, amqpErr.Reason, nil
+	case err != nil:
+		return "", "", err
+	}
+
+	err = try(declare)
+	switch {
+	case errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed:
+		return ActionConflict, amqpErr.Reason, nil
+	case err != nil:
+		return "", "", err
+	}
+	return "", "", nil
+}
@@ -0,0 +1,30 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// tableCarrier adapts [amqp.Table] message headers to the [tracing.Carrier]
+// interface.
+type tableCarrier amqp.Table
+
+// Get implements the [tracing.Carrier] interface.
+func (c tableCarrier) Get(key string) string {
+	switch v := c[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Set implements the [tracing.Carrier] interface.
+func (c tableCarrier) Set(key, value string) {
+	c[key] = value
+}
@@ -0,0 +1,297 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/eventscompass/service-framework/service"
+)
+
+// ErrInvalidPayload is returned when a payload does not match the schema of its
+// topic, or its version cannot be migrated to the current version.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// VersionHeader is the header of the messages which holds the version of the
+// schema of the payload, see [service.WithHeader]. The header is not covered by
+// the payload, so buses whose messages can be changed on the way should sign
+// it, e.g. the buses of the envelope package.
+const VersionHeader = "schema-version"
+
+// Versioned is implemented by the payloads of the messages, which declare the
+// version of their schema. The version starts at 1, and is incremented
+// whenever a change breaks the consumers, e.g. a field is renamed or removed.
+// Adding an optional field does not need a new version.
+type Versioned interface {
+	SchemaVersion() int
+}
+
+// Upcaster migrates a payload from the version it is registered for to the
+// next version, e.g. by renaming a field:
+//
+//	func(p map[string]any) error {
+//		p["start_time"] = p["start"]
+//		delete(p, "start")
+//		return nil
+//	}
+type Upcaster func(payload map[string]any) error
+
+// Registry holds the schemas of the payloads of the topics. Publishers stamp
+// the version of the payload in the headers of the messages, see
+// [Registry.Publish], and consumers validate the messages and migrate older
+// versions to the current one, see [Registry.Decode] and [Handler].
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*registration // by topic
+}
+
+type registration struct {
+	typ       reflect.Type
+	schema    *Schema
+	upcasters map[int]Upcaster // by the version they migrate from
+}
+
+// NewRegistry creates an empty [Registry].
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string]*registration)}
+}
+
+// DefaultRegistry holds the schemas of the payloads of this package.
+var DefaultRegistry = newDefaultRegistry()
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for topic, payload := range map[string]Versioned{
+		EventCreatedTopic:    EventCreated{},
+		EventBookedTopic:     EventBooked{},
+		LocationCreatedTopic: LocationCreated{},
+	} {
+		if err := r.Register(topic, payload); err != nil {
+			panic(err) // the payloads of this package are valid
+		}
+	}
+	return r
+}
+
+// Register registers the type of the payload for the topic, and generates its
+// schema. The payload must be a struct. This function returns
+// [service.ErrAlreadyExists] in case the topic is registered already. This
+// function returns [service.ErrBadRequest] in case the version is less than 1
+// or no schema can be generated for the type.
+func (r *Registry) Register(topic string, payload Versioned) error {
+	t := reflect.TypeOf(payload)
+	version := payload.SchemaVersion()
+	if version < 1 {
+		return fmt.Errorf("%w: version of %s must be at least 1, got %d", service.ErrBadRequest, t, version)
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: payload %s must be a struct", service.ErrBadRequest, t)
+	}
+	schema, err := schemaOf(t, make(map[reflect.Type]bool))
+	if err != nil {
+		return fmt.Errorf("%w: generate schema of %s: %v", service.ErrBadRequest, t, err)
+	}
+	schema.Dialect = jsonSchemaDialect
+	schema.Title = t.Name()
+	schema.Version = version
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.schemas[topic]; ok {
+		return fmt.Errorf("%w: topic %s", service.ErrAlreadyExists, topic)
+	}
+	r.schemas[topic] = &registration{typ: t, schema: schema, upcasters: make(map[int]Upcaster)}
+	return nil
+}
+
+// RegisterUpcaster registers the function which migrates the payloads of the
+// topic from the given version to the next one. Payloads of older versions are
+// migrated step by step. This function returns [service.ErrNotFound] in case
+// the topic is not registered. This function returns [service.ErrBadRequest] in
+// case the version is not older than the current one.
+func (r *Registry) RegisterUpcaster(topic string, from int, up Upcaster) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg, ok := r.schemas[topic]
+	if !ok {
+		return fmt.Errorf("%w: topic %s", service.ErrNotFound, topic)
+	}
+	if from < 1 || from >= reg.schema.Version {
+		return fmt.Errorf("%w: upcaster from version %d of %s, the current version is %d",
+			service.ErrBadRequest, from, topic, reg.schema.Version)
+	}
+	reg.upcasters[from] = up
+	return nil
+}
+
+// Schema returns the JSON Schema of the payload of the topic, e.g. for sharing
+// it with the consumers in other languages. This function returns
+// [service.ErrNotFound] in case the topic is not registered.
+func (r *Registry) Schema(topic string) ([]byte, error) {
+	reg, err := r.lookup(topic)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(reg.schema, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("%w: encode schema: %v", service.ErrUnexpected, err)
+	}
+	return data, nil
+}
+
+// Publish encodes the payload as json and publishes it to the topic, with the
+// version of its schema in the [VersionHeader]. This function returns
+// [service.ErrNotFound] in case the topic is not registered. This function
+// returns [service.ErrBadRequest] in case the payload is not of the registered
+// type. This function returns the errors of the bus.
+func (r *Registry) Publish(
+	ctx context.Context,
+	bus service.MessageBus,
+	topic string,
+	payload Versioned,
+	opts ...service.PublishOption,
+) error {
+	reg, err := r.lookup(topic)
+	if err != nil {
+		return err
+	}
+	if t := reflect.TypeOf(payload); t != reg.typ {
+		return fmt.Errorf("%w: payload of topic %s must be %s, got %s", service.ErrBadRequest, topic, reg.typ, t)
+	}
+	msg, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: encode payload: %v", service.ErrBadRequest, err)
+	}
+	opts = append(opts, service.WithHeader(VersionHeader, strconv.Itoa(reg.schema.Version)))
+	return bus.Publish(ctx, topic, msg, opts...) //nolint:wrapcheck // intentional
+}
+
+// Decode validates the message received from the topic and decodes it into v,
+// which must be a pointer to the registered type. The version of the payload
+// is taken from the headers of the delivery in ctx, see
+// [service.DeliveryFromContext]. Messages without a version are of version 1.
+// Older versions are migrated with the registered upcasters before they are
+// validated against the current schema. This function returns
+// [service.ErrNotFound] in case the topic is not registered. This function
+// returns [service.ErrBadRequest] in case v is not a pointer to the registered
+// type. This function returns [ErrInvalidPayload] in case the message is not
+// valid, is newer than the current version, or cannot be migrated.
+func (r *Registry) Decode(ctx context.Context, topic string, msg []byte, v any) error {
+	reg, err := r.lookup(topic)
+	if err != nil {
+		return err
+	}
+	if t := reflect.TypeOf(v); t == nil || t.Kind() != reflect.Pointer || t.Elem() != reg.typ {
+		return fmt.Errorf("%w: payload of topic %s must be decoded into *%s, got %T", service.ErrBadRequest, topic, reg.typ, v)
+	}
+
+	version := 1
+	if d, ok := service.DeliveryFromContext(ctx); ok {
+		if s, ok := d.Headers[VersionHeader]; ok {
+			if version, err = strconv.Atoi(s); err != nil {
+				return fmt.Errorf("%w: invalid version %q", ErrInvalidPayload, s)
+			}
+		}
+	}
+	if version > reg.schema.Version {
+		return fmt.Errorf("%w: version %d is newer than version %d", ErrInvalidPayload, version, reg.schema.Version)
+	}
+
+	payload, err := decodeJSON(msg)
+	if err != nil {
+		return err
+	}
+	if version < reg.schema.Version {
+		r.mu.RLock()
+		payload, err = reg.upcast(payload, version)
+		r.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		// The upcasters may set values of any Go type, which are
+		// validated as they are encoded.
+		if msg, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("%w: encode migrated payload: %v", ErrInvalidPayload, err)
+		}
+		if payload, err = decodeJSON(msg); err != nil {
+			return err
+		}
+	}
+	if err := reg.schema.validate(payload, "$"); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err := json.Unmarshal(msg, v); err != nil {
+		return fmt.Errorf("%w: decode payload: %v", ErrInvalidPayload, err)
+	}
+	return nil
+}
+
+// decodeJSON decodes the message for validating it against a schema. Numbers
+// are kept as [json.Number], so that integers can be told apart.
+func decodeJSON(msg []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var payload any
+	if err := dec.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("%w: decode json: %v", ErrInvalidPayload, err)
+	}
+	return payload, nil
+}
+
+// upcast migrates the payload from the version to the current version.
+func (reg *registration) upcast(payload any, version int) (any, error) {
+	obj, ok := payload.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: payload must be an object", ErrInvalidPayload)
+	}
+	for ; version < reg.schema.Version; version++ {
+		up, ok := reg.upcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upcaster from version %d", ErrInvalidPayload, version)
+		}
+		if err := up(obj); err != nil {
+			return nil, fmt.Errorf("%w: upcast from version %d: %v", ErrInvalidPayload, version, err)
+		}
+	}
+	return obj, nil
+}
+
+func (r *Registry) lookup(topic string) (*registration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.schemas[topic]
+	if !ok {
+		return nil, fmt.Errorf("%w: no schema for topic %s", service.ErrNotFound, topic)
+	}
+	return reg, nil
+}
+
+// Handler returns an [service.EventHandler] which decodes the messages of the
+// topic with [Registry.Decode] and passes the payloads to h. Invalid messages
+// are logged and dropped:
+//
+//	func (s *Service) Events() map[string]service.EventHandler {
+//		return map[string]service.EventHandler{
+//			pubsub.EventCreatedTopic: pubsub.Handler(pubsub.DefaultRegistry,
+//				pubsub.EventCreatedTopic, s.onEventCreated),
+//		}
+//	}
+func Handler[T Versioned](r *Registry, topic string, h func(ctx context.Context, payload T)) service.EventHandler {
+	return func(ctx context.Context, msg []byte) {
+		var payload T
+		if err := r.Decode(ctx, topic, msg, &payload); err != nil {
+			slog.Error("rejected message",
+				slog.String("topic", topic),
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+		h(ctx, payload)
+	}
+}
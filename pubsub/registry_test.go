@@ -0,0 +1,216 @@
+package pubsub_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/pubsub"
+	"github.com/eventscompass/service-framework/pubsub/memory"
+	"github.com/eventscompass/service-framework/service"
+)
+
+const bookingTopic = "booking.created"
+
+// booking is the payload of the booking topic. Version 1 named the start time
+// "start".
+type booking struct {
+	EventID   string    `json:"event_id"`
+	Seats     int       `json:"seats"`
+	StartTime time.Time `json:"start_time"`
+	Note      *string   `json:"note,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+}
+
+func (booking) SchemaVersion() int { return 2 }
+
+type count int
+
+func (count) SchemaVersion() int { return 1 }
+
+func newRegistry(t *testing.T) *pubsub.Registry {
+	t.Helper()
+	r := pubsub.NewRegistry()
+	if err := r.Register(bookingTopic, booking{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	err := r.RegisterUpcaster(bookingTopic, 1, func(p map[string]any) error {
+		p["start_time"] = p["start"]
+		delete(p, "start")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("register upcaster: %v", err)
+	}
+	return r
+}
+
+func TestRegistryPublish(t *testing.T) {
+	r := newRegistry(t)
+	bus := memory.New()
+	defer bus.Close()
+
+	got := make(chan booking, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = bus.Subscribe(ctx, bookingTopic, pubsub.Handler(r, bookingTopic, func(ctx context.Context, b booking) {
+			if d, _ := service.DeliveryFromContext(ctx); d.Headers[pubsub.VersionHeader] != "2" {
+				t.Errorf("got headers %v, want version 2", d.Headers)
+			}
+			got <- b
+		}))
+	}()
+	if err := bus.WaitForSubscribers(ctx, bookingTopic, 1); err != nil {
+		t.Fatalf("wait for subscription: %v", err)
+	}
+
+	want := booking{EventID: "1", Seats: 2, StartTime: time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)}
+	if err := r.Publish(ctx, bus, bookingTopic, want); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case b := <-got:
+		if b.EventID != want.EventID || b.Seats != want.Seats || !b.StartTime.Equal(want.StartTime) {
+			t.Errorf("got payload %+v, want %+v", b, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no payload received")
+	}
+
+	if err := r.Publish(ctx, bus, bookingTopic, count(1)); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("got error %v, want %v", err, service.ErrBadRequest)
+	}
+	if err := r.Publish(ctx, bus, "unknown", count(1)); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, service.ErrNotFound)
+	}
+}
+
+func TestRegistryDecode(t *testing.T) {
+	r := newRegistry(t)
+	for name, tc := range map[string]struct {
+		version string // the version header, if not empty
+		msg     string
+		want    error
+	}{
+		"current": {
+			version: "2",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "2024-06-01T18:00:00Z", "tags": ["a"]}`,
+		},
+		"upcast": {
+			version: "1",
+			msg:     `{"event_id": "1", "seats": 2, "start": "2024-06-01T18:00:00Z"}`,
+		},
+		"no version": {
+			msg: `{"event_id": "1", "seats": 2, "start": "2024-06-01T18:00:00Z"}`,
+		},
+		"unknown field": {
+			version: "2",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "2024-06-01T18:00:00Z", "venue": "hall"}`,
+		},
+		"null pointer": {
+			version: "2",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "2024-06-01T18:00:00Z", "note": null}`,
+		},
+		"newer version": {
+			version: "3",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "2024-06-01T18:00:00Z"}`,
+			want:    pubsub.ErrInvalidPayload,
+		},
+		"invalid version": {
+			version: "two",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "2024-06-01T18:00:00Z"}`,
+			want:    pubsub.ErrInvalidPayload,
+		},
+		"missing field": {
+			version: "2",
+			msg:     `{"event_id": "1", "start_time": "2024-06-01T18:00:00Z"}`,
+			want:    pubsub.ErrInvalidPayload,
+		},
+		"not an integer": {
+			version: "2",
+			msg:     `{"event_id": "1", "seats": 1.5, "start_time": "2024-06-01T18:00:00Z"}`,
+			want:    pubsub.ErrInvalidPayload,
+		},
+		"not a date-time": {
+			version: "2",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "tomorrow"}`,
+			want:    pubsub.ErrInvalidPayload,
+		},
+		"not an array": {
+			version: "2",
+			msg:     `{"event_id": "1", "seats": 2, "start_time": "2024-06-01T18:00:00Z", "tags": "a"}`,
+			want:    pubsub.ErrInvalidPayload,
+		},
+		"not json": {
+			version: "2",
+			msg:     `{"event_id": `,
+			want:    pubsub.ErrInvalidPayload,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.version != "" {
+				ctx = service.ContextWithDelivery(ctx, service.Delivery{
+					Headers: map[string]string{pubsub.VersionHeader: tc.version},
+				})
+			}
+			var b booking
+			err := r.Decode(ctx, bookingTopic, []byte(tc.msg), &b)
+			if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tc.want)
+			}
+			if tc.want == nil && (b.EventID != "1" || b.Seats != 2 || b.StartTime.IsZero()) {
+				t.Errorf("got payload %+v", b)
+			}
+		})
+	}
+
+	var c count
+	if err := r.Decode(context.Background(), bookingTopic, []byte(`{}`), &c); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("got error %v, want %v", err, service.ErrBadRequest)
+	}
+	if err := r.Decode(context.Background(), "unknown", []byte(`{}`), &c); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, service.ErrNotFound)
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := newRegistry(t)
+	if err := r.Register(bookingTopic, booking{}); !errors.Is(err, service.ErrAlreadyExists) {
+		t.Errorf("got error %v, want %v", err, service.ErrAlreadyExists)
+	}
+	if err := r.Register("count", count(0)); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("got error %v, want %v", err, service.ErrBadRequest)
+	}
+	if err := r.RegisterUpcaster("unknown", 1, nil); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, service.ErrNotFound)
+	}
+	if err := r.RegisterUpcaster(bookingTopic, 2, nil); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("got error %v, want %v", err, service.ErrBadRequest)
+	}
+
+	data, err := r.Schema(bookingTopic)
+	if err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	var schema pubsub.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("decode schema: %v", err)
+	}
+	if schema.Version != 2 || schema.Title != "booking" || schema.Type != "object" {
+		t.Errorf("got schema %s", data)
+	}
+	required := make(map[string]bool)
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	if !required["event_id"] || !required["seats"] || !required["start_time"] || required["note"] || required["tags"] {
+		t.Errorf("got required fields %v", schema.Required)
+	}
+	if got := schema.Properties["start_time"].Format; got != "date-time" {
+		t.Errorf("got format %q of the start time, want date-time", got)
+	}
+}
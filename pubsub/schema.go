@@ -0,0 +1,240 @@
+package pubsub
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// jsonSchemaDialect is the version of JSON Schema of the generated schemas.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema, see https://json-schema.org. It is generated from
+// the Go type of a payload with [Registry.Register], and supports the subset
+// of JSON Schema needed to describe Go types.
+type Schema struct {
+	Dialect string `json:"$schema,omitempty"`
+	Title   string `json:"title,omitempty"`
+
+	// Version is the version of the schema of the payload.
+	Version int `json:"version,omitempty"`
+
+	// Type is the JSON type, i.e. "object", "array", "string",
+	// "integer", "number" or "boolean". Any value is allowed if
+	// empty.
+	Type   string `json:"type,omitempty"`
+	Format string `json:"format,omitempty"`
+
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+
+	// AnyOf is used for values which may be null, i.e. pointers.
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf generates the schema of the Go type, following the rules of
+// encoding/json. Fields without the omitempty option are required.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) (*Schema, error) {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case t == rawMessageType:
+		return &Schema{}, nil
+	case t.Kind() != reflect.Pointer && t.Implements(jsonMarshalerType):
+		// The encoding of the type is not known.
+		return &Schema{}, nil
+	case t.Kind() != reflect.Pointer && t.Implements(textMarshalerType):
+		return &Schema{Type: "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Pointer:
+		elem, err := schemaOf(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{AnyOf: []*Schema{elem, {Type: "null"}}}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// Byte slices are encoded as base64 strings.
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := schemaOf(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key of %s must be a string", t)
+		}
+		values, err := schemaOf(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if seen[t] {
+			return nil, fmt.Errorf("recursive type %s", t)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		if err := addFields(s, t, seen); err != nil {
+			return nil, err
+		}
+		sort.Strings(s.Required)
+		return s, nil
+	default:
+		return nil, fmt.Errorf("type %s cannot be encoded as json", t)
+	}
+}
+
+// addFields adds the fields of the struct to the properties of the schema. The
+// fields of embedded structs without a json name are added as well.
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			if err := addFields(s, f.Type, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs, err := schemaOf(f.Type, seen)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		if strings.Contains(opts, "string") && fs.Type != "" && fs.Type != "object" && fs.Type != "array" {
+			// The ",string" option encodes scalars as strings.
+			fs = &Schema{Type: "string"}
+		}
+		s.Properties[name] = fs
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+// validate checks the value, as decoded by encoding/json with UseNumber,
+// against the schema. The path locates the value in the payload for the error
+// messages.
+func (s *Schema) validate(v any, path string) error {
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, alt := range s.AnyOf {
+			err := alt.validate(v, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s", strings.Join(errs, ", or "))
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s must be null", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s must be a date-time", path)
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		for i, item := range items {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for name, value := range obj {
+			ps, ok := s.Properties[name]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			if ps == nil {
+				// Unknown properties are allowed, so that new
+				// optional fields can be added without a new
+				// version.
+				continue
+			}
+			if err := ps.validate(value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s has unsupported type %q", path, s.Type)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package pubsub
+
+import "strings"
+
+// MatchTopic reports whether the topic matches the pattern with which a queue is
+// bound to a topic exchange. Topics consist of words separated by dots. In the
+// pattern, "*" matches exactly one word and "#" matches zero or more words,
+// e.g. "event.*" matches "event.created" but not "event" or "event.a.b".
+func MatchTopic(pattern, topic string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+func matchWords(pattern, topic []string) bool {
+	for i, p := range pattern {
+		switch p {
+		case "#":
+			// "#" matches any number of words, so try every possible
+			// remainder of the topic.
+			for j := i; j <= len(topic); j++ {
+				if matchWords(pattern[i+1:], topic[j:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if i >= len(topic) {
+				return false
+			}
+		default:
+			if i >= len(topic) || p != topic[i] {
+				return false
+			}
+		}
+	}
+	return len(pattern) == len(topic)
+}
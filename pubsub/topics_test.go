@@ -0,0 +1,41 @@
+package pubsub_test
+
+import (
+	"testing"
+
+	"github.com/eventscompass/service-framework/pubsub"
+)
+
+func TestMatchTopic(t *testing.T) {
+	for name, tc := range map[string]struct {
+		pattern, topic string
+		want           bool
+	}{
+		"equal":               {"event.created", "event.created", true},
+		"different":           {"event.created", "event.deleted", false},
+		"prefix":              {"event", "event.created", false},
+		"longer pattern":      {"event.created.v1", "event.created", false},
+		"star":                {"event.*", "event.created", true},
+		"star without word":   {"event.*", "event", false},
+		"star with two words": {"event.*", "event.a.b", false},
+		"star in the middle":  {"event.*.v1", "event.created.v1", true},
+		"hash alone":          {"#", "event.created", true},
+		"hash without word":   {"event.#", "event", true},
+		"hash with words":     {"event.#", "event.a.b", true},
+		"hash other prefix":   {"event.#", "booking.created", false},
+		"hash in the middle":  {"event.#.v1", "event.a.b.v1", true},
+		"hash adjacent":       {"event.#.v1", "event.v1", true},
+		"hash wrong suffix":   {"event.#.v1", "event.a.v2", false},
+		"hash then star":      {"#.*", "event", true},
+		"two hashes":          {"#.created.#", "a.b.created.c", true},
+		"two hashes missing":  {"#.created.#", "a.b.deleted.c", false},
+		"star then hash":      {"*.#", "event.a.b", true},
+		"star then hash none": {"*.#.x", "event", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := pubsub.MatchTopic(tc.pattern, tc.topic); got != tc.want {
+				t.Errorf("MatchTopic(%q, %q): got %v, want %v", tc.pattern, tc.topic, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/eventscompass/service-framework/metrics"
+)
+
+// newAdminHandler returns the handler serving the admin endpoints of the
+// service. The readiness endpoint reports the given state of the service.
+func newAdminHandler(state *atomic.Int32) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loglevel", handleLogLevel)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", readyzHandler(state))
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// handleLogLevel writes the current log level in response to GET requests. PUT
+// requests change the log level to the one provided in the request body, e.g.
+//
+//	curl -X PUT -d debug localhost:8082/loglevel
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64)) //nolint:gomnd // level names are short
+		if err != nil {
+			HTTPError(r.Context(), w, fmt.Errorf("%w: read body: %v", ErrBadRequest, err))
+			return
+		}
+		var level slog.Level
+		if err := level.UnmarshalText(bytes.TrimSpace(body)); err != nil {
+			HTTPError(r.Context(), w, fmt.Errorf("%w: parse level: %v", ErrBadRequest, err))
+			return
+		}
+		logLevel.Set(level)
+		slog.Info("log level changed", slog.String("level", level.String()))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, logLevel.Level()) //nolint:errcheck // intentional
+}
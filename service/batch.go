@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a message which is published as part of a batch, see
+// [BatchPublisher].
+type Message struct {
+	Topic string
+	Body  []byte
+
+	// Options are applied like the options of
+	// [MessageBus.Publish].
+	Options []PublishOption
+}
+
+// BatchPublisher is implemented by message buses which publish many messages at
+// once more efficiently than one at a time, e.g. when importing data. Use
+// [PublishBatch] to publish a batch on any [MessageBus].
+type BatchPublisher interface {
+
+	// PublishBatch publishes the messages in order. Returns
+	// [BatchError] in case any of the messages could not be
+	// published.
+	PublishBatch(_ context.Context, msgs []Message) error
+}
+
+// BatchError is the error returned by [BatchPublisher.PublishBatch] in case
+// some of the messages could not be published. The messages which are not
+// reported as failed were published.
+type BatchError struct {
+	// Errors holds the result of every message of the batch, in
+	// the order of the messages. The result of a message which
+	// was published is nil.
+	Errors []error
+}
+
+// NewBatchError returns the [BatchError] with the results of the messages, or
+// nil if all messages were published.
+func NewBatchError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errors: errs}
+		}
+	}
+	return nil
+}
+
+// Failed returns the indices of the messages which could not be published.
+func (e *BatchError) Failed() []int {
+	var failed []int
+	for i, err := range e.Errors {
+		if err != nil {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
+func (e *BatchError) Error() string {
+	failed := e.Failed()
+	return fmt.Sprintf("%d of %d messages not published: %v", len(failed), len(e.Errors), e.Errors[failed[0]])
+}
+
+// Unwrap returns the errors of the messages which could not be published.
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// PublishBatch publishes the messages on the bus, with a single call if the bus
+// implements [BatchPublisher], and one message after the other otherwise. This
+// function returns [BatchError] in case any of the messages could not be
+// published.
+func PublishBatch(ctx context.Context, bus MessageBus, msgs []Message) error {
+	if bp, ok := bus.(BatchPublisher); ok {
+		return bp.PublishBatch(ctx, msgs) //nolint:wrapcheck // intentional
+	}
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = bus.Publish(ctx, m.Topic, m.Body, m.Options...)
+	}
+	return NewBatchError(errs)
+}
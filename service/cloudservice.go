@@ -22,16 +22,20 @@ type CloudService interface {
 
 	// GRPC returns the [grpc.Server] that is registered for
 	// this service. Returns nil if the service is not serving
-	// grpc requests.
+	// grpc requests. Use [NewGRPCServer] to create a server
+	// with the standard interceptors of the framework.
 	GRPC() *grpc.Server
 
 	// Bus returns the [MessageBus] that is used for publishing
 	// and subscribing to messages. Returns nil if the service is
-	// not publishing/subscribing messages.
+	// not publishing/subscribing messages. The bus is closed by
+	// [Start] once the service stops.
 	Bus() MessageBus
 
 	// Events returns a map of events for which the service is
-	// listening, and their associated handlers. Returns nil if
-	// the service is not listening to events.
+	// listening, and their associated handlers. Requests sent
+	// with [Requester] are answered by handlers created with
+	// [Responder]. Returns nil if the service is not listening
+	// to events.
 	Events() map[string]EventHandler
 }
@@ -1,13 +1,67 @@
 package service
 
 import (
+	"log/slog"
 	"time"
 )
 
+// Config is the configuration of the framework components. It can be embedded
+// in the configuration of a service, which is loaded with the config package
+// and passed to [Start] with [WithConfig]. Only the log level can be changed
+// by reloading the configuration, the other values require a restart.
+type Config struct {
+	Server  ServerConfig  `config:"server" reload:"restart"`
+	REST    RESTConfig    `config:"rest" reload:"restart"`
+	GRPC    GRPCConfig    `config:"grpc" reload:"restart"`
+	Log     LogConfig     `config:"log" reload:"restart"`
+	Admin   AdminConfig   `config:"admin" reload:"restart"`
+	Tracing TracingConfig `config:"tracing" reload:"restart"`
+}
+
+// ServerConfig encapsulates the configuration shared by the rest and grpc
+// components of the service.
+type ServerConfig struct {
+	// Listen is the port on which both the rest and the grpc
+	// endpoints of this service will be registered. If set, it
+	// is used instead of [RESTConfig.Listen] and
+	// [GRPCConfig.Listen], and connections are dispatched to the
+	// rest or grpc server based on their protocol. Addresses of
+	// the form "unix:///path" listen on a unix domain socket.
+	Listen string `env:"SERVER_LISTEN"`
+
+	// DrainDelay is the time between receiving a stop signal
+	// and closing the listeners. During this time the service
+	// reports that it is not ready, but keeps serving requests,
+	// giving load balancers time to stop routing traffic to it.
+	DrainDelay time.Duration `env:"SERVER_DRAIN_DELAY" envDefault:"0s" validate:"min=0s"`
+
+	// ShutdownTimeout is the time the servers have to finish
+	// serving the active requests after the drain phase. Once
+	// it passes, the remaining connections are closed forcibly.
+	ShutdownTimeout time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT" envDefault:"30s" validate:"min=0s"`
+
+	// RestartSignal is the name of the signal, e.g. "SIGUSR2",
+	// upon which the service restarts without downtime. A new
+	// process of the service executable is started and takes
+	// over the listeners, after which this process shuts down.
+	// Setting it to an empty string disables restarts.
+	RestartSignal string `env:"SERVER_RESTART_SIGNAL" envDefault:"SIGUSR2"`
+
+	// RestartTimeout is the time the new process has to report
+	// that it is ready. If it does not, the new process is
+	// killed and this process keeps serving.
+	RestartTimeout time.Duration `env:"SERVER_RESTART_TIMEOUT" envDefault:"30s" validate:"min=0s"`
+
+	// SocketMode is the octal file mode of the unix domain
+	// sockets on which the servers listen.
+	SocketMode string `env:"SERVER_SOCKET_MODE" envDefault:"0660"`
+}
+
 // RESTConfig encapsulates the configuration for the rest component of the service.
 type RESTConfig struct {
 	// Listen is the port on which the REST endpoints of this
-	// service will be registered.
+	// service will be registered. Addresses of the form
+	// "unix:///path" listen on a unix domain socket instead.
 	Listen string `env:"HTTP_SERVER_LISTEN" envDefault:":8080"`
 
 	ReadHeaderTimeout time.Duration `env:"HTTP_SERVER_READ_HEADER_TIMEOUT" envDefault:"10s"`
@@ -15,14 +69,124 @@ type RESTConfig struct {
 	WriteTimeout      time.Duration `env:"HTTP_SERVER_WRITE_TIMEOUT" envDefault:"30s"`
 
 	DumpRequests bool `env:"HTTP_SERVER_DUMP_REQUESTS"`
+
+	// The following fields configure the standard middleware
+	// stack. All of the middleware are disabled by default.
+
+	// RequestID enables generating request ids, or propagating
+	// the ids received in the "X-Request-ID" header.
+	RequestID bool `env:"HTTP_SERVER_REQUEST_ID"`
+
+	// AccessLog enables logging every served request.
+	AccessLog bool `env:"HTTP_SERVER_ACCESS_LOG"`
+
+	// Recover enables recovering from panics in the handlers
+	// and responding with 500 instead of closing the connection.
+	Recover bool `env:"HTTP_SERVER_RECOVER"`
+
+	// MaxBodySize is the maximum size in bytes of the request
+	// bodies. Larger requests are rejected with 413. Zero means
+	// no limit.
+	MaxBodySize int64 `env:"HTTP_SERVER_MAX_BODY_SIZE" validate:"min=0"`
+
+	// TrustedProxies is a comma separated list of CIDRs of the
+	// proxies whose "X-Forwarded-For" and "X-Real-IP" headers
+	// are trusted for determining the real client ip.
+	TrustedProxies []string `env:"HTTP_SERVER_TRUSTED_PROXIES" envSeparator:","`
+
+	// Gzip enables compressing the responses with gzip for the
+	// clients that accept it.
+	Gzip bool `env:"HTTP_SERVER_GZIP"`
 }
 
-// GRPCConfig encapsulates the configuration for the rest component of the service.
+// GRPCConfig encapsulates the configuration for the grpc component of the service.
 type GRPCConfig struct {
 	// Listen is the port on which the grpc endpoints of this
-	// service will be registered.
+	// service will be registered. Addresses of the form
+	// "unix:///path" listen on a unix domain socket instead.
 	Listen string `env:"GRPC_SERVER_LISTEN" envDefault:":8081"`
 
 	// ClientTimeout is a timeout used for RPC HTTP clients. #courier
 	ClientTimeout time.Duration `env:"GRPC_CLIENT_TIMEOUT"`
+
+	// The following fields configure the servers created with
+	// [NewGRPCServer].
+
+	// DefaultTimeout is the deadline applied to rpcs that are
+	// received without one. Zero means no deadline.
+	DefaultTimeout time.Duration `env:"GRPC_SERVER_DEFAULT_TIMEOUT"`
+
+	// MaxTimeout is the upper limit for the deadline of the
+	// rpcs. Longer deadlines are shortened. Zero means no limit.
+	MaxTimeout time.Duration `env:"GRPC_SERVER_MAX_TIMEOUT"`
+
+	// AccessLog enables logging every handled rpc.
+	AccessLog bool `env:"GRPC_SERVER_ACCESS_LOG"`
+
+	// Reflection enables the grpc reflection service.
+	Reflection bool `env:"GRPC_SERVER_REFLECTION"`
+
+	// Health enables the grpc health checking service.
+	Health bool `env:"GRPC_SERVER_HEALTH"`
+
+	// Gateway enables serving the unary rpcs as http endpoints
+	// with json bodies on the rest server. The endpoints are
+	// derived from the google.api.http annotations of the
+	// methods, and every method is also served on
+	// "POST /package.Service/Method".
+	Gateway bool `env:"GRPC_SERVER_GATEWAY"`
+}
+
+// LogConfig encapsulates the configuration for the logger of the service.
+type LogConfig struct {
+	// Level is the minimum level of the log records that will be
+	// emitted. One of "debug", "info", "warn" or "error".
+	Level slog.Level `env:"LOG_LEVEL" envDefault:"info" reload:"hot"`
+
+	// Format is the output format of the log records. Either
+	// "json" or "text", in lower case.
+	Format string `env:"LOG_FORMAT" envDefault:"text" validate:"oneof=json text"`
+
+	// AddSource adds the source code position of the log
+	// statement to every log record.
+	AddSource bool `env:"LOG_ADD_SOURCE"`
+
+	// SampleWindow and SampleBurst configure sampling of
+	// repetitive log records. Within every window, at most
+	// SampleBurst records with the same level and message are
+	// emitted and the rest are dropped. Sampling is disabled if
+	// either of the values is zero.
+	SampleWindow time.Duration `env:"LOG_SAMPLE_WINDOW"`
+	SampleBurst  int           `env:"LOG_SAMPLE_BURST" validate:"min=0"`
+}
+
+// AdminConfig encapsulates the configuration for the admin component of the
+// service. The admin server exposes operational endpoints, e.g. for changing
+// the log level at runtime.
+type AdminConfig struct {
+	// Listen is the port on which the admin endpoints of this
+	// service will be registered. The endpoints are not
+	// authenticated, so by default they are only reachable from
+	// the local host. Setting it to an empty string disables the
+	// admin server.
+	Listen string `env:"ADMIN_SERVER_LISTEN" envDefault:"127.0.0.1:8082"`
+}
+
+// TracingConfig encapsulates the configuration for the tracing of the service.
+type TracingConfig struct {
+	// Exporter is the exporter used for sending spans. Either
+	// "none" or "otlp". With "none" the trace context is still
+	// propagated, but spans are not exported.
+	Exporter string `env:"TRACING_EXPORTER" envDefault:"none" validate:"oneof=none otlp"`
+
+	// Endpoint is the base url of the OpenTelemetry collector
+	// used by the "otlp" exporter.
+	Endpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" envDefault:"http://localhost:4318" validate:"url"`
+
+	// ServiceName is the name reported for the spans of this
+	// service. Defaults to the name of the executable.
+	ServiceName string `env:"OTEL_SERVICE_NAME"`
+
+	// SampleRatio is the ratio of new traces that are sampled.
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1" validate:"min=0,max=1"`
 }
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// sniffTimeout is the time a client has to send enough data for the protocol
+// of the connection to be detected.
+const sniffTimeout = 10 * time.Second
+
+// connMux accepts connections from a single listener and dispatches them to
+// the rest and grpc servers. Connections are dispatched based on the protocol:
+// HTTP/2 connections whose first request has a grpc content type are sent to
+// the grpc server, and everything else to the rest server.
+type connMux struct {
+	root net.Listener
+	rest *muxListener
+	grpc *muxListener
+}
+
+func newConnMux(root net.Listener) *connMux {
+	return &connMux{
+		root: root,
+		rest: newMuxListener(root.Addr()),
+		grpc: newMuxListener(root.Addr()),
+	}
+}
+
+// serve accepts connections until the root listener is closed.
+func (m *connMux) serve() error {
+	defer m.rest.Close() //nolint:errcheck // never fails
+	defer m.grpc.Close() //nolint:errcheck // never fails
+	for {
+		conn, err := m.root.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err //nolint:wrapcheck // intentional
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// close stops accepting new connections.
+func (m *connMux) close() error {
+	return m.root.Close() //nolint:wrapcheck // intentional
+}
+
+func (m *connMux) dispatch(conn net.Conn) {
+	// The bytes read while detecting the protocol are replayed to the server
+	// that receives the connection.
+	var buf bytes.Buffer
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout)) //nolint:errcheck // intentional
+	proto, err := sniff(io.TeeReader(conn, &buf), conn)
+	_ = conn.SetReadDeadline(time.Time{}) //nolint:errcheck // intentional
+	if err != nil {
+		slog.Debug("failed to detect connection protocol", slog.String("error", err.Error()))
+		_ = conn.Close() //nolint:errcheck // intentional
+		return
+	}
+
+	r := io.MultiReader(&buf, conn)
+	switch proto {
+	case protoGRPC:
+		// The grpc server ignores the acknowledgement of the settings sent
+		// while sniffing.
+		m.grpc.deliver(&replayConn{Conn: conn, r: r})
+	case protoHTTP2:
+		m.rest.deliver(&replayConn{Conn: conn, r: &settingsAckFilter{r: r}})
+	default:
+		m.rest.deliver(&replayConn{Conn: conn, r: r})
+	}
+}
+
+// The protocols detected by [sniff].
+const (
+	protoHTTP1 = iota
+	protoHTTP2
+	protoGRPC
+)
+
+// sniff detects the protocol of the connection. The connection carries grpc if
+// it is HTTP/2 and the content type of the first request is grpc.
+//
+// Some grpc clients wait for the server settings before sending any request,
+// so an empty settings frame is written to w once the connection is known to
+// be HTTP/2. The client then acknowledges these settings in addition to the
+// settings of the server that receives the connection.
+func sniff(r io.Reader, w io.Writer) (int, error) {
+	// HTTP/1 requests may be shorter than the preface, so the connection
+	// is dispatched as soon as the bytes read do not match the preface.
+	preface := make([]byte, 0, len(http2.ClientPreface))
+	for len(preface) < cap(preface) {
+		n, err := r.Read(preface[len(preface):cap(preface)])
+		preface = preface[:len(preface)+n]
+		if !strings.HasPrefix(http2.ClientPreface, string(preface)) {
+			return protoHTTP1, nil
+		}
+		if err != nil {
+			return 0, err //nolint:wrapcheck // intentional
+		}
+	}
+
+	fr := http2.NewFramer(w, r)
+	if err := fr.WriteSettings(); err != nil {
+		return 0, err //nolint:wrapcheck // intentional
+	}
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil) //nolint:gomnd // default table size
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return 0, err //nolint:wrapcheck // intentional
+		}
+		if h, ok := f.(*http2.MetaHeadersFrame); ok {
+			if strings.HasPrefix(contentType(h), "application/grpc") {
+				return protoGRPC, nil
+			}
+			return protoHTTP2, nil
+		}
+	}
+}
+
+func contentType(h *http2.MetaHeadersFrame) string {
+	for _, f := range h.RegularFields() {
+		if f.Name == "content-type" {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// replayConn is a [net.Conn] whose reads are served from r.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read implements the [net.Conn] interface.
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b) //nolint:wrapcheck // intentional
+}
+
+// settingsAckFilter reads an HTTP/2 client stream, dropping the first
+// acknowledgement of settings. It hides the acknowledgement of the settings sent
+// while sniffing from servers that would treat it as a protocol error.
+type settingsAckFilter struct {
+	r       io.Reader
+	pending []byte // bytes read from r, not yet returned
+	dropped bool
+	preface bool
+}
+
+// Read implements the [io.Reader] interface.
+func (f *settingsAckFilter) Read(b []byte) (int, error) {
+	for len(f.pending) == 0 && !f.dropped {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	if len(f.pending) > 0 {
+		n := copy(b, f.pending)
+		f.pending = f.pending[n:]
+		return n, nil
+	}
+	return f.r.Read(b) //nolint:wrapcheck // intentional
+}
+
+// next reads the preface or the next frame into the pending bytes, unless it is
+// the acknowledgement that should be dropped.
+func (f *settingsAckFilter) next() error {
+	if !f.preface {
+		f.preface = true
+		f.pending = make([]byte, len(http2.ClientPreface))
+		_, err := io.ReadFull(f.r, f.pending)
+		return err //nolint:wrapcheck // intentional
+	}
+
+	const headerLen = 9
+	frame := make([]byte, headerLen)
+	if _, err := io.ReadFull(f.r, frame); err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	length := int(frame[0])<<16 | int(frame[1])<<8 | int(frame[2])
+	typ, flags := http2.FrameType(frame[3]), http2.Flags(frame[4])
+	if typ == http2.FrameSettings && flags.Has(http2.FlagSettingsAck) && length == 0 {
+		f.dropped = true
+		return nil
+	}
+	frame = append(frame, make([]byte, length)...)
+	if _, err := io.ReadFull(f.r, frame[headerLen:]); err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	f.pending = frame
+	return nil
+}
+
+// muxListener is a [net.Listener] that accepts the connections dispatched to it
+// by a [connMux].
+type muxListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close() //nolint:errcheck // intentional
+	}
+}
+
+// Accept implements the [net.Listener] interface.
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements the [net.Listener] interface.
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr implements the [net.Listener] interface.
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startConnMux serves a rest server, which responds with the protocol of the
+// request, and a grpc server with the health service on a single port. It
+// returns the address of the port.
+func startConnMux(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	mux := newConnMux(lis)
+
+	restSrv := &http.Server{
+		Handler: h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Proto)
+		}), &http2.Server{}),
+		ReadHeaderTimeout: time.Second,
+	}
+	grpcSrv := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcSrv, health.NewServer())
+
+	go func() { _ = mux.serve() }()
+	go func() { _ = restSrv.Serve(mux.rest) }()
+	go func() { _ = grpcSrv.Serve(mux.grpc) }()
+	t.Cleanup(func() {
+		_ = mux.close()
+		_ = restSrv.Close()
+		grpcSrv.Stop()
+	})
+	return lis.Addr().String()
+}
+
+func TestConnMuxHTTP1(t *testing.T) {
+	addr := startConnMux(t)
+
+	// The request is shorter than the HTTP/2 preface, and the client waits
+	// for the response before sending anything else.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n"); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/1.0" {
+		t.Errorf("got protocol %q, want HTTP/1.0", body)
+	}
+}
+
+func TestConnMuxHTTP2(t *testing.T) {
+	addr := startConnMux(t)
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		Timeout: time.Second,
+	}
+
+	// Several requests on the same connection show that the settings sent
+	// while sniffing do not break the connection.
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("got protocol %q, want HTTP/2.0", body)
+		}
+	}
+}
+
+func TestConnMuxGRPC(t *testing.T) {
+	addr := startConnMux(t)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("check health: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("got status %v, want %v", resp.Status, healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+func TestSniffIncomplete(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data string
+		want int
+	}{
+		"short":         {data: "GET", want: protoHTTP1},
+		"not a preface": {data: "PRI * HTTP/1.1", want: protoHTTP1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The reader never returns more data, thus sniff must decide
+			// on the bytes read so far.
+			r, w := io.Pipe()
+			defer w.Close()
+			go func() { _, _ = io.WriteString(w, tc.data) }()
+
+			done := make(chan int, 1)
+			go func() {
+				proto, _ := sniff(r, io.Discard)
+				done <- proto
+			}()
+			select {
+			case proto := <-done:
+				if proto != tc.want {
+					t.Errorf("got protocol %d, want %d", proto, tc.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("sniff blocked")
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+
+	"github.com/eventscompass/service-framework/internal/errs"
 )
 
 // All exported functions, are only allowed to return the following errors or
@@ -36,40 +38,44 @@ import (
 var (
 	// ErrAlreadyExists is returned when the client requests to
 	// create a resource that already exists.
-	ErrAlreadyExists = errors.New("already exists")
+	ErrAlreadyExists = errs.ErrAlreadyExists
 
 	// ErrBadRequest is returned when the client submits a
 	// request that cannot be understood and processed by the
 	// service. Usually when the request body cannot be decoded,
 	// or the request URL parameters cannot be handled, then this
 	// error is returned.
-	ErrBadRequest = errors.New("bad request")
+	ErrBadRequest = errs.ErrBadRequest
 
 	// ErrConnectionClosed is returned when the connection we are
 	// trying to use is closed.
-	ErrConnectionClosed = errors.New("connection closed")
+	ErrConnectionClosed = errs.ErrConnectionClosed
+
+	// ErrInvalidConfig is returned when the configuration of
+	// the service, or of one of its components, is invalid.
+	ErrInvalidConfig = errs.ErrInvalidConfig
 
 	// ErrNotAllowed is returned when the requested action is not
 	// allowed to be executed.
-	ErrNotAllowed = errors.New("not allowed")
+	ErrNotAllowed = errs.ErrNotAllowed
 
 	// ErrNotFound is returned when the requested resource is not
 	// found.
-	ErrNotFound = errors.New("not found")
+	ErrNotFound = errs.ErrNotFound
 
 	// ErrSpaceFull is returned when the storage of the service
 	// is full.
-	ErrSpaceFull = errors.New("no space")
+	ErrSpaceFull = errs.ErrSpaceFull
 
 	// ErrTimeOut is returned when an operation performed by the
 	// service is taking longer than the allowed time limit.
-	ErrTimeOut = errors.New("time out")
+	ErrTimeOut = errs.ErrTimeOut
 
 	// ErrUnexpected is reserved for errors that look like they
 	// would never happen. Instead of panicking use
 	// ErrUnexpected. This error can be returned by any function
 	// even if not explicitly mentioned.
-	ErrUnexpected = errors.New("unexpected")
+	ErrUnexpected = errs.ErrUnexpected
 )
 
 // Unexpected returns err if it's the error of ctx, otherwise it logs err and
@@ -123,6 +129,11 @@ func HTTPError(ctx context.Context, w http.ResponseWriter, err error) {
 		http.Error(w, err.Error(), http.StatusBadRequest) // 400
 		slog.Info("client made a bad request", slog.String("error", err.Error()))
 
+	// The client sent a request body that is larger than allowed.
+	case errors.As(err, new(*http.MaxBytesError)):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge) // 413
+		slog.Info("client sent a request that is too large", slog.String("error", err.Error()))
+
 	// The client requested an action that is not allowed.
 	case errors.Is(err, ErrNotAllowed):
 		http.Error(w, err.Error(), http.StatusForbidden) // 403
@@ -0,0 +1,417 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// gateway serves the unary rpcs of a grpc server as http endpoints with json
+// bodies. The endpoints are derived from the google.api.http annotations of the
+// methods. Every method is also served on "POST /package.Service/Method" with
+// the request message as the body.
+//
+// The rpcs are invoked through an in-process connection to the grpc server,
+// so they pass through the interceptors of the server.
+type gateway struct {
+	routes []*gatewayRoute
+	lis    *muxListener
+	conn   *grpc.ClientConn
+}
+
+type gatewayRoute struct {
+	httpMethod   string
+	tmpl         *pathTemplate
+	rpc          string // full method name, e.g. "/package.Service/Method"
+	md           protoreflect.MethodDescriptor
+	body         string
+	responseBody string
+}
+
+// newGateway creates a gateway for the services registered on the server. The
+// caller must serve the grpc server on the listener of the gateway. This
+// function returns [ErrInvalidConfig] if the descriptors of the services cannot
+// be found, or if their http annotations are malformed.
+func newGateway(srv *grpc.Server) (*gateway, error) {
+	gw := &gateway{lis: newMuxListener(pipeAddr{})}
+
+	names := make([]string, 0)
+	infos := srv.GetServiceInfo()
+	for name := range infos {
+		// Skip the reflection and health services of the framework.
+		if !strings.HasPrefix(name, "grpc.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var fallbacks []*gatewayRoute
+	for _, name := range names {
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("%w: find descriptor of service %s: %v", ErrInvalidConfig, name, err)
+		}
+		sd, ok := d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a service", ErrInvalidConfig, name)
+		}
+
+		for _, info := range infos[name].Methods {
+			md := sd.Methods().ByName(protoreflect.Name(info.Name))
+			if md == nil || info.IsClientStream || info.IsServerStream {
+				continue // streaming rpcs are not supported
+			}
+			rpc := "/" + name + "/" + info.Name
+
+			for _, rule := range httpRules(md) {
+				tmpl, err := parsePathTemplate(rule.pattern)
+				if err != nil {
+					return nil, fmt.Errorf("http rule of %s: %w", rpc, err)
+				}
+				gw.routes = append(gw.routes, &gatewayRoute{
+					httpMethod:   rule.method,
+					tmpl:         tmpl,
+					rpc:          rpc,
+					md:           md,
+					body:         rule.body,
+					responseBody: rule.responseBody,
+				})
+			}
+
+			tmpl, err := parsePathTemplate(rpc)
+			if err != nil {
+				return nil, err
+			}
+			fallbacks = append(fallbacks, &gatewayRoute{
+				httpMethod: http.MethodPost,
+				tmpl:       tmpl,
+				rpc:        rpc,
+				md:         md,
+				body:       "*",
+			})
+		}
+	}
+	gw.routes = append(gw.routes, fallbacks...)
+
+	conn, err := grpc.Dial("passthrough:///gateway",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go gw.lis.deliver(server)
+			return client, nil
+		}),
+		grpc.WithChainUnaryInterceptor(TracingUnaryClientInterceptor()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: dial grpc server: %v", ErrUnexpected, err)
+	}
+	gw.conn = conn
+	return gw, nil
+}
+
+// handler returns an [http.Handler] serving the gateway routes. Requests that
+// do not match any route are passed to next, or receive 404 if next is nil.
+func (gw *gateway) handler(next http.Handler) http.Handler {
+	if mux, ok := next.(*http.ServeMux); ok {
+		next = muxRoutes(mux)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range gw.routes {
+			if route.httpMethod != r.Method {
+				continue
+			}
+			if vars, ok := route.tmpl.match(r.URL.EscapedPath()); ok {
+				Route(route.tmpl.raw, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gw.serve(w, r, route, vars)
+				})).ServeHTTP(w, r)
+				return
+			}
+		}
+		if next == nil {
+			HTTPError(r.Context(), w, fmt.Errorf("%w: %s %s", ErrNotFound, r.Method, r.URL.Path))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// close closes the connection to the grpc server.
+func (gw *gateway) close() error {
+	_ = gw.lis.Close()     //nolint:errcheck // never fails
+	return gw.conn.Close() //nolint:wrapcheck // intentional
+}
+
+func (gw *gateway) serve(
+	w http.ResponseWriter,
+	r *http.Request,
+	route *gatewayRoute,
+	vars map[string]string,
+) {
+	ctx := r.Context()
+	in := dynamicpb.NewMessage(route.md.Input())
+	if err := decodeRequest(r, route, vars, in); err != nil {
+		HTTPError(ctx, w, err)
+		return
+	}
+
+	out := dynamicpb.NewMessage(route.md.Output())
+	ctx = metadata.NewOutgoingContext(ctx, forwardedMetadata(r.Header))
+	if err := gw.conn.Invoke(ctx, route.rpc, in, out); err != nil {
+		HTTPError(ctx, w, errorFromStatus(err))
+		return
+	}
+
+	body, err := encodeResponse(out, route.responseBody)
+	if err != nil {
+		HTTPError(ctx, w, fmt.Errorf("%w: encode response: %v", ErrUnexpected, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body) //nolint:errcheck // the client went away
+}
+
+// decodeRequest fills the request message from the body, the path variables
+// and the query parameters of the http request. This function returns
+// [ErrBadRequest] if the request cannot be decoded.
+func decodeRequest(
+	r *http.Request,
+	route *gatewayRoute,
+	vars map[string]string,
+	msg *dynamicpb.Message,
+) error {
+	// The body is decoded first, because decoding resets the message.
+	if route.body != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("%w: read body: %v", ErrBadRequest, err)
+		}
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && route.body != "*" {
+			body = []byte(fmt.Sprintf("{%q:%s}", route.body, body))
+		}
+		if len(body) > 0 {
+			if err := protojson.Unmarshal(body, msg); err != nil {
+				return fmt.Errorf("%w: decode body: %v", ErrBadRequest, err)
+			}
+		}
+	}
+
+	for path, v := range vars {
+		if err := setField(msg, path, v); err != nil {
+			return fmt.Errorf("%w: path variable %s: %v", ErrBadRequest, path, err)
+		}
+	}
+
+	// Query parameters are only used for fields not bound by the body.
+	if route.body == "*" {
+		return nil
+	}
+	for key, values := range r.URL.Query() {
+		if _, ok := vars[key]; ok {
+			continue
+		}
+		for _, v := range values {
+			if err := setField(msg, key, v); err != nil {
+				return fmt.Errorf("%w: query parameter %s: %v", ErrBadRequest, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setField sets the field at the given dot separated path to the value. If the
+// field is repeated then the value is appended.
+func setField(msg protoreflect.Message, path string, value string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fields := msg.Descriptor().Fields()
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("unknown field %q", name)
+		}
+
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field %q is not a message", name)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+
+		if fd.IsMap() {
+			return fmt.Errorf("map field %q cannot be set", name)
+		}
+		v, err := parseValue(msg, fd, value)
+		if err != nil {
+			return err
+		}
+		if fd.IsList() {
+			msg.Mutable(fd).List().Append(v)
+		} else {
+			msg.Set(fd, v)
+		}
+	}
+	return nil
+}
+
+// parseValue parses the string value of the field. Message fields are parsed
+// from their json representation as a string, which covers the well-known
+// types, e.g. timestamps.
+func parseValue(
+	msg protoreflect.Message,
+	fd protoreflect.FieldDescriptor,
+	s string,
+) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.URLEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.StdEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err //nolint:wrapcheck // intentional
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err //nolint:wrapcheck // intentional
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err //nolint:wrapcheck // intentional
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(i), err //nolint:wrapcheck // intentional
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(i)), err //nolint:wrapcheck // intentional
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		i, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(i), err //nolint:wrapcheck // intentional
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err //nolint:wrapcheck // intentional
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err //nolint:wrapcheck // intentional
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		i, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), err //nolint:wrapcheck // intentional
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		var m protoreflect.Message
+		if fd.IsList() {
+			m = msg.Mutable(fd).List().NewElement().Message()
+		} else {
+			m = msg.NewField(fd).Message()
+		}
+		err := protojson.Unmarshal([]byte(strconv.Quote(s)), m.Interface())
+		return protoreflect.ValueOfMessage(m), err //nolint:wrapcheck // intentional
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+	}
+}
+
+// encodeResponse encodes the response message as json. If the response body
+// is set, then only that field of the message is encoded.
+func encodeResponse(msg *dynamicpb.Message, responseBody string) ([]byte, error) {
+	b, err := protojson.Marshal(msg)
+	if err != nil || responseBody == "" {
+		return b, err //nolint:wrapcheck // intentional
+	}
+
+	fd := msg.Descriptor().Fields().ByName(protoreflect.Name(responseBody))
+	if fd == nil {
+		return nil, fmt.Errorf("unknown response body field %q", responseBody)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err //nolint:wrapcheck // intentional
+	}
+	if v, ok := fields[fd.JSONName()]; ok {
+		return v, nil
+	}
+	return []byte("null"), nil
+}
+
+// forwardedMetadata returns the grpc metadata forwarded from the http headers.
+// Headers with the "Grpc-Metadata-" prefix are forwarded without the prefix.
+func forwardedMetadata(h http.Header) metadata.MD {
+	const prefix = "Grpc-Metadata-"
+	md := metadata.MD{}
+	for key, values := range h {
+		switch {
+		case strings.HasPrefix(key, prefix):
+			md.Append(strings.TrimPrefix(key, prefix), values...)
+		case key == "Authorization", key == RequestIDHeader:
+			md.Append(key, values...)
+		}
+	}
+	return md
+}
+
+// errorFromStatus maps the status of a grpc error to the errors of the
+// framework. It is the inverse of [GRPCError].
+func errorFromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	var target error
+	switch st.Code() {
+	case codes.OK:
+		return nil
+	case codes.Canceled:
+		target = context.Canceled
+	case codes.DeadlineExceeded:
+		target = context.DeadlineExceeded
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		target = ErrBadRequest
+	case codes.ResourceExhausted:
+		target = ErrSpaceFull
+	case codes.PermissionDenied, codes.Unauthenticated:
+		target = ErrNotAllowed
+	case codes.NotFound, codes.Unimplemented:
+		target = ErrNotFound
+	case codes.AlreadyExists:
+		target = ErrAlreadyExists
+	case codes.Unavailable:
+		target = ErrConnectionClosed
+	default:
+		target = ErrUnexpected
+	}
+	// Errors mapped by [GRPCError] already start with the classification.
+	msg := strings.TrimPrefix(st.Message(), target.Error()+": ")
+	return fmt.Errorf("%w: %s", target, msg)
+}
+
+// pipeAddr is the address of the in-process gateway listener.
+type pipeAddr struct{}
+
+// Network implements the [net.Addr] interface.
+func (pipeAddr) Network() string { return "pipe" }
+
+// String implements the [net.Addr] interface.
+func (pipeAddr) String() string { return "gateway" }
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// echoMethod returns the descriptor of the gatewaytest.Events.Echo method,
+// which is registered once. The method is annotated with the http rule:
+//
+//	patch: "/v1/{name=events/*}"
+//	body: "event"
+//	additional_bindings { get: "/v1/{name=events/*}" response_body: "event" }
+var echoMethod = sync.OnceValue(func() protoreflect.MethodDescriptor {
+	str := func(name string, num int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	event := &descriptorpb.DescriptorProto{
+		Name:  proto.String("Event"),
+		Field: []*descriptorpb.FieldDescriptorProto{str("title", 1)},
+	}
+	event.Field = append(event.Field, &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("seats"),
+		Number:   proto.Int32(2),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: proto.String("seats"),
+	})
+	tags := str("tags", 3)
+	tags.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	request := &descriptorpb.DescriptorProto{
+		Name: proto.String("Request"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			str("name", 1),
+			{
+				Name:     proto.String("event"),
+				Number:   proto.Int32(2),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".gatewaytest.Event"),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				JsonName: proto.String("event"),
+			},
+			tags,
+		},
+	}
+
+	appendString := func(b []byte, num protowire.Number, s string) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, s)
+	}
+	var binding, rule []byte
+	binding = appendString(binding, 2, "/v1/{name=events/*}") // get
+	binding = appendString(binding, 12, "event")              // response_body
+	rule = appendString(rule, 6, "/v1/{name=events/*}")       // patch
+	rule = appendString(rule, 7, "event")                     // body
+	rule = protowire.AppendTag(rule, 11, protowire.BytesType) // additional_bindings
+	rule = protowire.AppendBytes(rule, binding)
+	opts := &descriptorpb.MethodOptions{}
+	opts.ProtoReflect().SetUnknown(protowire.AppendBytes(
+		protowire.AppendTag(nil, httpRuleField, protowire.BytesType), rule))
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("service/gateway_test.proto"),
+		Package:     proto.String("gatewaytest"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{event, request},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Events"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Echo"),
+				InputType:  proto.String(".gatewaytest.Request"),
+				OutputType: proto.String(".gatewaytest.Request"),
+				Options:    opts,
+			}},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+	return fd.Services().Get(0).Methods().Get(0)
+})
+
+// startGateway serves the gateway of a grpc server with the Echo method, which
+// returns the request, or NOT_FOUND for the event "events/missing".
+func startGateway(t *testing.T) http.Handler {
+	t.Helper()
+	md := echoMethod()
+	srv := grpc.NewServer()
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "gatewaytest.Events",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Echo",
+			Handler: func(_ any, _ context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				in := dynamicpb.NewMessage(md.Input())
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if in.Get(md.Input().Fields().ByName("name")).String() == "events/missing" {
+					return nil, status.Error(codes.NotFound, "no such event")
+				}
+				return in, nil
+			},
+		}},
+	}, struct{}{})
+
+	gw, err := newGateway(srv)
+	if err != nil {
+		t.Fatalf("new gateway: %v", err)
+	}
+	go func() { _ = srv.Serve(gw.lis) }()
+	t.Cleanup(func() {
+		_ = gw.close()
+		srv.Stop()
+	})
+	return gw.handler(nil)
+}
+
+func TestGateway(t *testing.T) {
+	h := startGateway(t)
+	for name, tc := range map[string]struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string
+	}{
+		"body field": {
+			method: http.MethodPatch, path: "/v1/events/1", body: `{"title": "a"}`,
+			wantStatus: http.StatusOK, wantBody: `{"name":"events/1","event":{"title":"a"}}`,
+		},
+		"empty body": {
+			method: http.MethodPatch, path: "/v1/events/1?tags=a", body: " \n",
+			wantStatus: http.StatusOK, wantBody: `{"name":"events/1","tags":["a"]}`,
+		},
+		"response body": {
+			method: http.MethodGet, path: "/v1/events/1?event.seats=2&tags=a&tags=b",
+			wantStatus: http.StatusOK, wantBody: `{"seats":2}`,
+		},
+		"escaped path": {
+			method: http.MethodPatch, path: "/v1/events/a%20b",
+			wantStatus: http.StatusOK, wantBody: `{"name":"events/a b"}`,
+		},
+		"rpc": {
+			method: http.MethodPost, path: "/gatewaytest.Events/Echo", body: `{"name": "events/1", "tags": ["a"]}`,
+			wantStatus: http.StatusOK, wantBody: `{"name":"events/1","tags":["a"]}`,
+		},
+		"invalid query": {
+			method: http.MethodGet, path: "/v1/events/1?event.seats=many",
+			wantStatus: http.StatusBadRequest,
+		},
+		"unknown field": {
+			method: http.MethodPatch, path: "/v1/events/1", body: `{"name": "events/2"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"not found": {
+			method: http.MethodGet, path: "/v1/events/missing",
+			wantStatus: http.StatusNotFound,
+		},
+		"no route": {
+			method: http.MethodDelete, path: "/v1/events/1",
+			wantStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			if tc.wantBody == "" {
+				return
+			}
+			// The json is compacted, as protojson varies the whitespace.
+			var got bytes.Buffer
+			body, _ := io.ReadAll(rec.Body)
+			if err := json.Compact(&got, body); err != nil {
+				t.Fatalf("compact %q: %v", body, err)
+			}
+			if got.String() != tc.wantBody {
+				t.Errorf("got body %s, want %s", got.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		tmpl, path string
+		want       map[string]string // nil if the path does not match
+	}{
+		{"/v1/events", "/v1/events", map[string]string{}},
+		{"/v1/events/{id}", "/v1/events/1", map[string]string{"id": "1"}},
+		{"/v1/events/{id}", "/v1/events/1/seats", nil},
+		{"/v1/{name=events/*}:cancel", "/v1/events/1:cancel", map[string]string{"name": "events/1"}},
+		{"/v1/{name=events/*}:cancel", "/v1/events/1", nil},
+		{"/v1/{path=files/**}", "/v1/files/a/b%2Fc", map[string]string{"path": "files/a/b/c"}},
+		{"/v1/{event.id}/seats/{seat}", "/v1/1/seats/2", map[string]string{"event.id": "1", "seat": "2"}},
+		{"/v1.events/{id}", "/v1xevents/1", nil},
+	} {
+		pt, err := parsePathTemplate(tc.tmpl)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.tmpl, err)
+		}
+		got, ok := pt.match(tc.path)
+		if ok != (tc.want != nil) {
+			t.Errorf("%q matched %q: %t, want %t", tc.tmpl, tc.path, ok, tc.want != nil)
+			continue
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%q on %q: got %s=%q, want %q", tc.tmpl, tc.path, k, got[k], v)
+			}
+		}
+	}
+
+	for _, tmpl := range []string{"v1/events", "/v1/{id"} {
+		if _, err := parsePathTemplate(tmpl); err == nil {
+			t.Errorf("parsed invalid template %q", tmpl)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// NewGRPCServer creates a new [grpc.Server] with the standard interceptor chain
+// of the framework installed. The unary and stream interceptors take care of
+// tracing, metrics, logging, mapping errors to status codes, enforcing
+// deadlines and recovering from panics, in this order. Interceptors passed
+// in opts are run after the ones of the framework.
+//
+// Depending on the [GRPCConfig], the reflection and health services are also
+// registered. The health service reports serving until [Start] shuts the
+// server down.
+//
+// The server is configured from the environment variables of the process, until
+// [Start] configures it with the [GRPCConfig] loaded for the service, see
+// [WithConfig] and [WithEnv]. The configuration is released once [Start]
+// returns. This function returns [ErrInvalidConfig] if the environment
+// variables cannot be parsed.
+func NewGRPCServer(opts ...grpc.ServerOption) (*grpc.Server, error) {
+	var cfg GRPCConfig
+	if err := parseEnv(&cfg, nil); err != nil {
+		return nil, fmt.Errorf("%w: parse grpc environment variables: %v", ErrInvalidConfig, err)
+	}
+	sc := &grpcServerConfig{}
+	sc.cfg.Store(&cfg)
+
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			TracingUnaryInterceptor(),
+			MetricsUnaryInterceptor(),
+			sc.logUnary,
+			errorsUnary,
+			sc.deadlineUnary,
+			recoverUnary,
+		),
+		grpc.ChainStreamInterceptor(
+			TracingStreamInterceptor(),
+			MetricsStreamInterceptor(),
+			sc.logStream,
+			errorsStream,
+			sc.deadlineStream,
+			recoverStream,
+		),
+	}, opts...)
+	srv := grpc.NewServer(opts...)
+	sc.register(srv)
+	grpcServers.Store(srv, sc)
+	return srv, nil
+}
+
+// grpcServerConfig holds the configuration of a server created with
+// [NewGRPCServer], which is read by its interceptors.
+type grpcServerConfig struct {
+	cfg atomic.Pointer[GRPCConfig]
+
+	mu     sync.Mutex
+	health *health.Server // nil if the health service is not registered
+}
+
+// grpcServers maps the servers created with [NewGRPCServer] to their
+// configuration, so that [Start] can configure them. The servers are removed
+// by [releaseGRPCServer].
+var grpcServers sync.Map
+
+// configureGRPCServer configures the server with the configuration loaded by
+// [Start]. It must be called before the server is started. Servers that were
+// not created with [NewGRPCServer] are not changed.
+func configureGRPCServer(srv *grpc.Server, cfg *GRPCConfig) {
+	if v, ok := grpcServers.Load(srv); ok {
+		sc := v.(*grpcServerConfig) //nolint:forcetypeassert // always a server config
+		sc.cfg.Store(cfg)
+		sc.register(srv)
+	}
+}
+
+// releaseGRPCServer removes the configuration of the server once [Start] is
+// done with it. The interceptors of the server keep their configuration.
+func releaseGRPCServer(srv *grpc.Server) {
+	grpcServers.Delete(srv)
+}
+
+// register registers the reflection and health services, if they are enabled
+// and not registered already. Services cannot be unregistered, so disabling
+// them is only logged.
+func (sc *grpcServerConfig) register(srv *grpc.Server) {
+	cfg := sc.cfg.Load()
+	services := srv.GetServiceInfo()
+	reflectionOK := false
+	for _, name := range reflectionServices {
+		if _, ok := services[name]; ok {
+			reflectionOK = true
+		}
+	}
+	switch {
+	case cfg.Reflection && !reflectionOK:
+		reflection.Register(srv)
+	case !cfg.Reflection && reflectionOK:
+		slog.Warn("grpc reflection service cannot be disabled, it is registered already")
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	switch {
+	case cfg.Health && sc.health == nil:
+		sc.health = health.NewServer()
+		healthpb.RegisterHealthServer(srv, sc.health)
+	case !cfg.Health && sc.health != nil:
+		slog.Warn("grpc health service cannot be disabled, it is registered already")
+	}
+}
+
+// reflectionServices are the names of the versions of the reflection service
+// registered by [reflection.Register]. A server may have only one of them, e.g.
+// when registered with [reflection.RegisterV1].
+var reflectionServices = []string{
+	reflectionv1.ServerReflection_ServiceDesc.ServiceName,
+	reflectionv1alpha.ServerReflection_ServiceDesc.ServiceName,
+}
+
+// grpcHealthShutdown sets the health service of the server, if any, to report
+// not serving.
+func grpcHealthShutdown(srv *grpc.Server) {
+	v, ok := grpcServers.Load(srv)
+	if !ok {
+		return
+	}
+	sc := v.(*grpcServerConfig) //nolint:forcetypeassert // always a server config
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.health != nil {
+		sc.health.Shutdown()
+	}
+}
+
+// GRPCError maps the provided error to a grpc status error with the correct
+// status code. Errors that already carry a status are returned unchanged.
+func GRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var code codes.Code
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrTimeOut):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, ErrBadRequest):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrSpaceFull):
+		code = codes.ResourceExhausted
+	case errors.Is(err, ErrNotAllowed):
+		code = codes.PermissionDenied
+	case errors.Is(err, ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, ErrConnectionClosed):
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error()) //nolint:wrapcheck // intentional
+}
+
+func errorsUnary(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	resp, err := handler(ctx, req)
+	return resp, GRPCError(err)
+}
+
+func errorsStream(
+	srv any,
+	ss grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return GRPCError(handler(srv, ss))
+}
+
+// deadlineUnary is an interceptor that applies the default timeout to rpcs
+// without a deadline, and shortens the deadlines longer than the max timeout.
+func (sc *grpcServerConfig) deadlineUnary(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	cfg := sc.cfg.Load()
+	ctx, cancel := enforceDeadline(ctx, cfg.DefaultTimeout, cfg.MaxTimeout)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err // the deadline passed before the rpc was handled
+	}
+	return handler(ctx, req)
+}
+
+// deadlineStream is the stream version of [grpcServerConfig.deadlineUnary].
+func (sc *grpcServerConfig) deadlineStream(
+	srv any,
+	ss grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	cfg := sc.cfg.Load()
+	ctx, cancel := enforceDeadline(ss.Context(), cfg.DefaultTimeout, cfg.MaxTimeout)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return err // the deadline passed before the rpc was handled
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+func enforceDeadline(
+	ctx context.Context,
+	defaultTimeout time.Duration,
+	maxTimeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok && defaultTimeout > 0:
+		if maxTimeout > 0 {
+			defaultTimeout = min(defaultTimeout, maxTimeout)
+		}
+		return context.WithTimeout(ctx, defaultTimeout)
+	case maxTimeout > 0 && (!ok || time.Until(deadline) > maxTimeout):
+		return context.WithTimeout(ctx, maxTimeout)
+	default:
+		return ctx, func() {}
+	}
+}
+
+func recoverUnary(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (resp any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func recoverStream(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// recovered logs the recovered panic and returns the error sent to the client.
+func recovered(method string, p any) error {
+	slog.Error("panic while handling rpc",
+		slog.String("method", method),
+		slog.Any("panic", p),
+		slog.String("stack", string(debug.Stack())),
+	)
+	return status.Error(codes.Internal, fmt.Sprintf("%s: panic: %v", ErrUnexpected, p)) //nolint:wrapcheck // intentional
+}
+
+// logUnary is an interceptor that logs the rpcs if the access log is enabled.
+func (sc *grpcServerConfig) logUnary(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if !sc.cfg.Load().AccessLog {
+		return handler(ctx, req)
+	}
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logRPC(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// logStream is the stream version of [grpcServerConfig.logUnary].
+func (sc *grpcServerConfig) logStream(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if !sc.cfg.Load().AccessLog {
+		return handler(srv, ss)
+	}
+	start := time.Now()
+	err := handler(srv, ss)
+	logRPC(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func logRPC(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		if code == codes.Internal || code == codes.Unknown {
+			level = slog.LevelError
+		}
+	}
+	slog.LogAttrs(ctx, level, "handled rpc", attrs...)
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+func TestEnforceDeadline(t *testing.T) {
+	for name, tc := range map[string]struct {
+		deadline  time.Duration // zero means no deadline
+		defaultTO time.Duration
+		maxTO     time.Duration
+		want      time.Duration // zero means no deadline
+	}{
+		"no limits":             {},
+		"default":               {defaultTO: time.Minute, want: time.Minute},
+		"default above max":     {defaultTO: time.Hour, maxTO: time.Minute, want: time.Minute},
+		"max without default":   {maxTO: time.Minute, want: time.Minute},
+		"deadline below max":    {deadline: time.Second, maxTO: time.Minute, want: time.Second},
+		"deadline above max":    {deadline: time.Hour, maxTO: time.Minute, want: time.Minute},
+		"deadline with default": {deadline: time.Hour, defaultTO: time.Minute, want: time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+				defer cancel()
+			}
+			ctx, cancel := enforceDeadline(ctx, tc.defaultTO, tc.maxTO)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if ok != (tc.want > 0) {
+				t.Fatalf("got deadline %v, want one in %v", deadline, tc.want)
+			}
+			if got := time.Until(deadline); ok && (got > tc.want || got < tc.want-time.Second) {
+				t.Errorf("got deadline in %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	if GRPCError(nil) != nil {
+		t.Error("got error for nil")
+	}
+	st := status.Error(codes.Aborted, "aborted")
+	if got := GRPCError(st); got != st { //nolint:errorlint // must be unchanged
+		t.Errorf("got %v, want %v unchanged", got, st)
+	}
+
+	for err, want := range map[error]codes.Code{
+		context.Canceled:             codes.Canceled,
+		context.DeadlineExceeded:     codes.DeadlineExceeded,
+		ErrTimeOut:                   codes.DeadlineExceeded,
+		ErrBadRequest:                codes.InvalidArgument,
+		ErrSpaceFull:                 codes.ResourceExhausted,
+		ErrNotAllowed:                codes.PermissionDenied,
+		ErrNotFound:                  codes.NotFound,
+		ErrAlreadyExists:             codes.AlreadyExists,
+		ErrConnectionClosed:          codes.Unavailable,
+		ErrUnexpected:                codes.Internal,
+		errors.New("something else"): codes.Internal, //nolint:goerr113 // test
+	} {
+		wrapped := fmt.Errorf("%w: details", err)
+		got := GRPCError(wrapped)
+		if status.Code(got) != want {
+			t.Errorf("%v: got code %v, want %v", err, status.Code(got), want)
+		}
+		if status.Convert(got).Message() != wrapped.Error() {
+			t.Errorf("%v: got message %q", err, status.Convert(got).Message())
+		}
+	}
+}
+
+func TestRecoverInterceptors(t *testing.T) {
+	panics := func(context.Context, any) (any, error) { panic("boom") }
+	_, err := recoverUnary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Test/Unary"}, panics)
+	if status.Code(err) != codes.Internal || !strings.Contains(err.Error(), "unexpected: panic: boom") {
+		t.Errorf("got error %v, want internal panic", err)
+	}
+
+	err = recoverStream(nil, nil, &grpc.StreamServerInfo{FullMethod: "/test.Test/Stream"},
+		func(any, grpc.ServerStream) error { panic("boom") })
+	if status.Code(err) != codes.Internal || !strings.Contains(err.Error(), "unexpected: panic: boom") {
+		t.Errorf("got error %v, want internal panic", err)
+	}
+}
+
+func TestLogInterceptor(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	sc := &grpcServerConfig{}
+	sc.cfg.Store(&GRPCConfig{})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Test/Unary"}
+	handler := func(err error) grpc.UnaryHandler {
+		return func(context.Context, any) (any, error) { return nil, err }
+	}
+
+	_, _ = sc.logUnary(context.Background(), nil, info, handler(nil))
+	if logs.Len() != 0 {
+		t.Errorf("rpc logged with the access log disabled: %q", logs.String())
+	}
+
+	sc.cfg.Store(&GRPCConfig{AccessLog: true})
+	for err, want := range map[error]string{
+		nil:                                     "level=INFO msg=\"handled rpc\" method=/test.Test/Unary code=OK",
+		status.Error(codes.NotFound, "missing"): "level=INFO msg=\"handled rpc\" method=/test.Test/Unary code=NotFound",
+		status.Error(codes.Internal, "broken"):  "level=ERROR msg=\"handled rpc\" method=/test.Test/Unary code=Internal",
+	} {
+		logs.Reset()
+		if _, got := sc.logUnary(context.Background(), nil, info, handler(err)); got != err { //nolint:errorlint // must be unchanged
+			t.Errorf("got error %v, want %v", got, err)
+		}
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("got logs %q, want %q", logs.String(), want)
+		}
+	}
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	sc := &grpcServerConfig{}
+	sc.cfg.Store(&GRPCConfig{DefaultTimeout: time.Minute})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Test/Unary"}
+
+	var deadline time.Time
+	_, err := sc.deadlineUnary(context.Background(), nil, info, func(ctx context.Context, _ any) (any, error) {
+		deadline, _ = ctx.Deadline()
+		return nil, nil
+	})
+	if err != nil || time.Until(deadline) <= 0 || time.Until(deadline) > time.Minute {
+		t.Errorf("got deadline %v and error %v", deadline, err)
+	}
+
+	// The rpc is not handled once its deadline passed.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = sc.deadlineUnary(ctx, nil, info, func(context.Context, any) (any, error) {
+		t.Error("handler called after the deadline")
+		return nil, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConfigureGRPCServer(t *testing.T) {
+	srv, err := NewGRPCServer()
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer releaseGRPCServer(srv)
+
+	configureGRPCServer(srv, &GRPCConfig{Reflection: true, Health: true})
+	services := srv.GetServiceInfo()
+	for _, name := range []string{
+		"grpc.reflection.v1.ServerReflection",
+		"grpc.reflection.v1alpha.ServerReflection",
+		healthpb.Health_ServiceDesc.ServiceName,
+	} {
+		if _, ok := services[name]; !ok {
+			t.Errorf("service %s not registered", name)
+		}
+	}
+	// Configuring the server again does not register the services twice.
+	configureGRPCServer(srv, &GRPCConfig{Reflection: true, Health: true})
+
+	v, _ := grpcServers.Load(srv)
+	sc := v.(*grpcServerConfig) //nolint:forcetypeassert // test
+	grpcHealthShutdown(srv)
+	resp, err := sc.health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("got health %v and error %v, want %v", resp, err, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	releaseGRPCServer(srv)
+	if _, ok := grpcServers.Load(srv); ok {
+		t.Error("server config not released")
+	}
+}
+
+func TestConfigureGRPCServerReflectionV1(t *testing.T) {
+	// The reflection service registered by the user is not registered again,
+	// which would panic.
+	srv, err := NewGRPCServer()
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer releaseGRPCServer(srv)
+	reflection.RegisterV1(srv)
+	configureGRPCServer(srv, &GRPCConfig{Reflection: true})
+}
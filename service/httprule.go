@@ -0,0 +1,197 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpRuleField is the field number of the google.api.http method option, see
+// https://github.com/googleapis/googleapis/blob/master/google/api/annotations.proto.
+// The option is decoded from the wire format, so that the services are not
+// required to link the annotations package.
+const httpRuleField = 72295728
+
+// httpRule is the decoded google.api.HttpRule message.
+type httpRule struct {
+	method       string
+	pattern      string
+	body         string
+	responseBody string
+	additional   []httpRule
+}
+
+// httpRules returns the http rules declared for the method, including the
+// additional bindings.
+func httpRules(md protoreflect.MethodDescriptor) []httpRule {
+	opts := md.Options()
+	if opts == nil {
+		return nil
+	}
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+
+	var rules []httpRule
+	forEachField(b, func(num protowire.Number, v []byte) {
+		if num != httpRuleField {
+			return
+		}
+		rule := parseHTTPRule(v)
+		rules = append(rules, rule)
+		rules = append(rules, rule.additional...)
+	})
+	return rules
+}
+
+func parseHTTPRule(b []byte) httpRule {
+	var rule httpRule
+	forEachField(b, func(num protowire.Number, v []byte) {
+		switch num {
+		case 2: //nolint:gomnd // HttpRule.get
+			rule.method, rule.pattern = http.MethodGet, string(v)
+		case 3: //nolint:gomnd // HttpRule.put
+			rule.method, rule.pattern = http.MethodPut, string(v)
+		case 4: //nolint:gomnd // HttpRule.post
+			rule.method, rule.pattern = http.MethodPost, string(v)
+		case 5: //nolint:gomnd // HttpRule.delete
+			rule.method, rule.pattern = http.MethodDelete, string(v)
+		case 6: //nolint:gomnd // HttpRule.patch
+			rule.method, rule.pattern = http.MethodPatch, string(v)
+		case 7: //nolint:gomnd // HttpRule.body
+			rule.body = string(v)
+		case 8: //nolint:gomnd // HttpRule.custom
+			forEachField(v, func(num protowire.Number, v []byte) {
+				switch num {
+				case 1: // CustomHttpPattern.kind
+					rule.method = strings.ToUpper(string(v))
+				case 2: //nolint:gomnd // CustomHttpPattern.path
+					rule.pattern = string(v)
+				}
+			})
+		case 11: //nolint:gomnd // HttpRule.additional_bindings
+			rule.additional = append(rule.additional, parseHTTPRule(v))
+		case 12: //nolint:gomnd // HttpRule.response_body
+			rule.responseBody = string(v)
+		}
+	})
+	return rule
+}
+
+// forEachField calls fn for every length-delimited field of the encoded
+// message. Other fields are skipped.
+func forEachField(b []byte, fn func(protowire.Number, []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return
+		}
+		fn(num, v)
+		b = b[n:]
+	}
+}
+
+// pathTemplate matches request paths against an http rule path template, e.g.
+// "/v1/{name=events/*}:cancel", and extracts the values of its variables.
+type pathTemplate struct {
+	raw  string
+	re   *regexp.Regexp
+	vars []string // field paths of the variables, in order
+}
+
+// parsePathTemplate compiles the path template. This function returns
+// [ErrInvalidConfig] if the template is malformed.
+func parsePathTemplate(tmpl string) (*pathTemplate, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("%w: path template %q must start with /", ErrInvalidConfig, tmpl)
+	}
+
+	var (
+		re   strings.Builder
+		vars []string
+		rest = tmpl
+	)
+	re.WriteString("^")
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			re.WriteString(segmentsPattern(rest))
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated variable in path template %q", ErrInvalidConfig, tmpl)
+		}
+		re.WriteString(segmentsPattern(rest[:start]))
+
+		name, pattern, ok := strings.Cut(rest[start+1:start+end], "=")
+		if !ok {
+			pattern = "*"
+		}
+		vars = append(vars, name)
+		re.WriteString("(" + segmentsPattern(pattern) + ")")
+		rest = rest[start+end+1:]
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: compile path template %q: %v", ErrInvalidConfig, tmpl, err)
+	}
+	return &pathTemplate{raw: tmpl, re: compiled, vars: vars}, nil
+}
+
+// segmentsPattern converts a part of a path template into a regular
+// expression. A "*" segment matches a single path segment, and a "**" segment
+// matches any number of segments.
+func segmentsPattern(s string) string {
+	segs := strings.Split(s, "/")
+	for i, seg := range segs {
+		switch seg {
+		case "*":
+			segs[i] = "[^/]+"
+		case "**":
+			segs[i] = ".+"
+		default:
+			segs[i] = regexp.QuoteMeta(seg)
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// match reports whether the escaped path matches the template, and returns the
+// unescaped values of the variables keyed by their field paths.
+func (t *pathTemplate) match(escapedPath string) (map[string]string, bool) {
+	m := t.re.FindStringSubmatch(escapedPath)
+	if m == nil {
+		return nil, false
+	}
+	values := make(map[string]string, len(t.vars))
+	for i, name := range t.vars {
+		v, err := url.PathUnescape(m[i+1])
+		if err != nil {
+			return nil, false
+		}
+		values[name] = v
+	}
+	return values, true
+}
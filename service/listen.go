@@ -0,0 +1,211 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The environment variables through which a parent process describes the
+// listeners it passes on to the new process, see [listeners.handoff].
+const (
+	// listenersEnv is a comma separated list with the names of the inherited
+	// listeners. The i-th listener is inherited as file descriptor 3+i.
+	listenersEnv = "SERVICE_LISTENERS"
+
+	// readyFDEnv is the file descriptor of the pipe on which the new process
+	// reports that it is ready.
+	readyFDEnv = "SERVICE_READY_FD"
+)
+
+// The names of the listeners opened by [Start].
+const (
+	listenerServer = "server"
+	listenerREST   = "rest"
+	listenerGRPC   = "grpc"
+	listenerAdmin  = "admin"
+)
+
+// listenFDsStart is the first file descriptor passed on to a new process.
+const listenFDsStart = 3
+
+// unixScheme is the prefix of the addresses of unix domain sockets, e.g.
+// "unix:///run/service.sock".
+const unixScheme = "unix://"
+
+// listeners opens the listeners of the servers by name and keeps track of
+// them. Listeners inherited from the parent process or passed by systemd socket
+// activation are used instead of opening new ones.
+type listeners struct {
+	provided  map[string]net.Listener // set with options of [Start]
+	inherited map[string]net.Listener
+	open      []namedListener
+	ready     *os.File    // reports the readiness to the parent process
+	mode      os.FileMode // permissions of the unix domain sockets
+}
+
+type namedListener struct {
+	name string
+	net.Listener
+}
+
+// newListeners collects the provided listeners and the listeners inherited from
+// the parent process or passed by systemd. The unix domain sockets created by
+// the listeners are given the provided permissions.
+func newListeners(provided map[string]net.Listener, mode os.FileMode) (*listeners, error) {
+	ls := &listeners{
+		provided:  provided,
+		inherited: make(map[string]net.Listener),
+		mode:      mode,
+	}
+	if err := ls.inheritSystemd(); err != nil {
+		return nil, err
+	}
+
+	if names := os.Getenv(listenersEnv); names != "" {
+		for i, name := range strings.Split(names, ",") {
+			if err := ls.inherit(name, listenFDsStart+i); err != nil {
+				return nil, err
+			}
+			// The socket files created by the parent process are now owned
+			// by this process, so they are removed when it shuts down.
+			if ul, ok := ls.inherited[name].(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(true)
+			}
+		}
+	}
+	if fd := os.Getenv(readyFDEnv); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, readyFDEnv, err)
+		}
+		ls.ready = os.NewFile(uintptr(n), "ready")
+	}
+
+	// The variables describe the files of this process only, so they must
+	// not leak into processes started by the service.
+	_ = os.Unsetenv(listenersEnv) //nolint:errcheck // intentional
+	_ = os.Unsetenv(readyFDEnv)   //nolint:errcheck // intentional
+	return ls, nil
+}
+
+// inherit adds the listener with the given file descriptor.
+func (ls *listeners) inherit(name string, fd int) error {
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close() //nolint:errcheck // the listener holds a duplicate
+	lis, err := net.FileListener(f)
+	if err != nil {
+		return fmt.Errorf("inherit listener %q: %w", name, err)
+	}
+	ls.inherited[name] = lis
+	return nil
+}
+
+// listen returns the inherited listener with the given name, or opens a new one
+// on the given address. Addresses with the "unix://" scheme are unix domain
+// sockets, which are removed when the listener is closed.
+func (ls *listeners) listen(name, addr string) (net.Listener, error) {
+	lis, ok := ls.provided[name]
+	if ok {
+		delete(ls.provided, name)
+	} else if lis, ok = ls.inherited[name]; ok {
+		delete(ls.inherited, name)
+		slog.Info("using inherited listener",
+			slog.String("name", name),
+			slog.String("address", lis.Addr().String()),
+		)
+	} else {
+		var err error
+		if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+			lis, err = ls.listenUnix(path)
+		} else {
+			lis, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			return nil, err //nolint:wrapcheck // intentional
+		}
+	}
+	ls.open = append(ls.open, namedListener{name: name, Listener: lis})
+	return lis, nil
+}
+
+// listenUnix opens a unix domain socket at the given path. A socket file left
+// behind by a process that did not shut down cleanly is removed first.
+//
+// The socket is created with the permissions allowed by the umask of the
+// process, which is shared by all goroutines and thus left alone, and its mode
+// is set afterwards. Sockets that must never be reachable by other users belong
+// in a directory that is not accessible to them.
+func (ls *listeners) listenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // intentional
+	}
+	if err := os.Chmod(path, ls.mode); err != nil {
+		_ = lis.Close() //nolint:errcheck // intentional
+		return nil, fmt.Errorf("set socket permissions: %w", err)
+	}
+	return lis, nil
+}
+
+// removeStaleSocket removes the socket file at the given path, unless another
+// process is still listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%w: %s exists and is not a socket", ErrInvalidConfig, path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close() //nolint:errcheck // intentional
+		return fmt.Errorf("%w: socket %s is in use", ErrAlreadyExists, path)
+	}
+	slog.Info("removing stale socket", slog.String("path", path))
+	return os.Remove(path) //nolint:wrapcheck // intentional
+}
+
+// closeUnused closes the listeners that were not used by any server.
+func (ls *listeners) closeUnused() {
+	for _, lis := range ls.provided {
+		_ = lis.Close() //nolint:errcheck // intentional
+	}
+	clear(ls.provided)
+	for name, lis := range ls.inherited {
+		slog.Warn("closing unused inherited listener", slog.String("name", name))
+		_ = lis.Close() //nolint:errcheck // intentional
+	}
+	clear(ls.inherited)
+}
+
+// close closes all listeners. The listeners of the servers are closed when the
+// servers shut down, so this matters only if the service fails to start.
+func (ls *listeners) close() {
+	ls.closeUnused()
+	for _, lis := range ls.open {
+		_ = lis.Close() //nolint:errcheck // intentional
+	}
+}
+
+// notifyReady reports to the parent process that the service is ready.
+func (ls *listeners) notifyReady() {
+	if ls.ready == nil {
+		return
+	}
+	if _, err := ls.ready.Write([]byte{1}); err != nil {
+		slog.Error("failed to notify parent process", slog.String("error", err.Error()))
+	}
+	_ = ls.ready.Close() //nolint:errcheck // intentional
+	ls.ready = nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestListenUnix(t *testing.T) {
+	umask := unix.Umask(0o022)
+	defer unix.Umask(umask)
+
+	ls, err := newListeners(nil, 0o600)
+	if err != nil {
+		t.Fatalf("new listeners: %v", err)
+	}
+	defer ls.close()
+	path := filepath.Join(t.TempDir(), "rest.sock")
+	if _, err := ls.listen(listenerREST, unixScheme+path); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if got := fi.Mode().Perm(); got != 0o600 {
+		t.Errorf("got socket mode %v, want %v", got, os.FileMode(0o600))
+	}
+	if got := unix.Umask(0o022); got != 0o022 {
+		t.Errorf("got umask %o, want %o", got, 0o022)
+	}
+
+	// The socket is in use, so it is not replaced.
+	if _, err := ls.listenUnix(path); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("got error %v, want %v", err, ErrAlreadyExists)
+	}
+	ls.close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file not removed on close: %v", err)
+	}
+}
+
+func TestListenUnixStale(t *testing.T) {
+	ls, err := newListeners(nil, 0o600)
+	if err != nil {
+		t.Fatalf("new listeners: %v", err)
+	}
+	defer ls.close()
+
+	// A socket left behind by a process that did not shut down cleanly is
+	// replaced.
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	lis, err := ls.listenUnix(path)
+	if err != nil {
+		t.Fatalf("listen on stale socket: %v", err)
+	}
+	_ = lis.Close()
+
+	// Other files are never removed.
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := ls.listenUnix(file); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidConfig)
+	}
+}
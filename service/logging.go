@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// logLevel is the level of the logger installed by [Start]. It can be changed
+// at runtime through the admin server or by sending [levelSignal] to the
+// process.
+var logLevel = new(slog.LevelVar)
+
+// setupLogger creates a new logger as described by the config and installs it
+// as the default [slog] logger.
+func setupLogger(cfg *LogConfig) error {
+	h, err := newLogHandler(cfg, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// newLogHandler creates the handler of the logger described by the config,
+// which writes the log records to w. The format must be one of the values
+// allowed by [LogConfig.Format]. This function returns [ErrInvalidConfig] in
+// case the format is unknown.
+func newLogHandler(cfg *LogConfig, w io.Writer) (slog.Handler, error) {
+	logLevel.Set(cfg.Level)
+	opts := &slog.HandlerOptions{
+		AddSource: cfg.AddSource,
+		Level:     logLevel,
+	}
+
+	var h slog.Handler
+	switch cfg.Format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("%w: unknown log format %q", ErrInvalidConfig, cfg.Format)
+	}
+
+	if cfg.SampleWindow > 0 && cfg.SampleBurst > 0 {
+		h = &samplingHandler{
+			Handler: h,
+			sampler: &sampler{
+				window: cfg.SampleWindow,
+				burst:  cfg.SampleBurst,
+				counts: make(map[sampleKey]int),
+			},
+		}
+	}
+	return h, nil
+}
+
+// toggleDebug switches the level of the logger between debug and the given
+// level. It is used when [levelSignal] is received.
+func toggleDebug(level slog.Level) {
+	if logLevel.Level() != slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	logLevel.Set(level)
+	slog.Info("log level changed", slog.String("level", level.String()))
+}
+
+// samplingHandler is a [slog.Handler] that drops repetitive log records. Records
+// are considered repetitive if they have the same level and message.
+type samplingHandler struct {
+	slog.Handler
+
+	// sampler is shared between the handler and all of the
+	// handlers derived from it with WithAttrs and WithGroup.
+	sampler *sampler
+}
+
+// Handle implements the [slog.Handler] interface.
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.sampler.allow(r.Level, r.Message) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r) //nolint:wrapcheck // intentional
+}
+
+// WithAttrs implements the [slog.Handler] interface.
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), sampler: h.sampler}
+}
+
+// WithGroup implements the [slog.Handler] interface.
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), sampler: h.sampler}
+}
+
+type sampleKey struct {
+	level slog.Level
+	msg   string
+}
+
+// sampler counts the log records within fixed time windows.
+type sampler struct {
+	window time.Duration
+	burst  int
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[sampleKey]int
+}
+
+// allow reports whether a record with the given level and message should be
+// emitted.
+func (s *sampler) allow(level slog.Level, msg string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := time.Now(); now.Sub(s.start) >= s.window {
+		s.start = now
+		clear(s.counts)
+	}
+	k := sampleKey{level: level, msg: msg}
+	s.counts[k]++
+	return s.counts[k] <= s.burst
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	defer logLevel.Set(logLevel.Level())
+
+	for _, format := range []string{"json", "text"} {
+		var buf bytes.Buffer
+		h, err := newLogHandler(&LogConfig{Level: slog.LevelInfo, Format: format}, &buf)
+		if err != nil {
+			t.Fatalf("%s: new handler: %v", format, err)
+		}
+		slog.New(h).Info("hello", slog.String("key", "value"))
+		if format == "json" {
+			var rec map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &rec); err != nil || rec["msg"] != "hello" || rec["key"] != "value" {
+				t.Errorf("got json record %q, %v", buf.String(), err)
+			}
+		} else if !strings.Contains(buf.String(), "msg=hello key=value") {
+			t.Errorf("got text record %q", buf.String())
+		}
+	}
+
+	// The formats are the ones accepted by the validation of the config.
+	for _, format := range []string{"JSON", "", "yaml"} {
+		_, err := newLogHandler(&LogConfig{Format: format}, &bytes.Buffer{})
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("format %q: got error %v, want %v", format, err, ErrInvalidConfig)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	defer logLevel.Set(logLevel.Level())
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	h, err := newLogHandler(&LogConfig{Level: slog.LevelInfo, Format: "text"}, &buf)
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	log := slog.New(h)
+	slog.SetDefault(log)
+
+	log.Debug("hidden")
+	toggleDebug(slog.LevelInfo)
+	log.Debug("shown")
+	toggleDebug(slog.LevelInfo)
+	log.Debug("hidden again")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "shown") {
+		t.Errorf("got records %q", got)
+	}
+	if logLevel.Level() != slog.LevelInfo {
+		t.Errorf("got level %v, want info", logLevel.Level())
+	}
+
+	// The level is changed through the admin server.
+	for _, tc := range []struct {
+		method, body string
+		wantStatus   int
+		wantLevel    slog.Level
+	}{
+		{http.MethodPut, "warn\n", http.StatusOK, slog.LevelWarn},
+		{http.MethodPut, "loud", http.StatusBadRequest, slog.LevelWarn},
+		{http.MethodGet, "", http.StatusOK, slog.LevelWarn},
+		{http.MethodPost, "debug", http.StatusMethodNotAllowed, slog.LevelWarn},
+	} {
+		rec := httptest.NewRecorder()
+		handleLogLevel(rec, httptest.NewRequest(tc.method, "/loglevel", strings.NewReader(tc.body)))
+		if rec.Code != tc.wantStatus || logLevel.Level() != tc.wantLevel {
+			t.Errorf("%s %q: got status %d and level %v, want %d and %v",
+				tc.method, tc.body, rec.Code, logLevel.Level(), tc.wantStatus, tc.wantLevel)
+		}
+		if tc.wantStatus == http.StatusOK && strings.TrimSpace(rec.Body.String()) != "WARN" {
+			t.Errorf("%s %q: got body %q, want WARN", tc.method, tc.body, rec.Body)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	defer logLevel.Set(logLevel.Level())
+
+	var buf bytes.Buffer
+	h, err := newLogHandler(&LogConfig{
+		Level:        slog.LevelInfo,
+		Format:       "text",
+		SampleWindow: time.Hour,
+		SampleBurst:  2,
+	}, &buf)
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+
+	// The handlers derived with attributes share the counts.
+	log := slog.New(h)
+	for i := 0; i < 3; i++ {
+		log.Info("repeated")
+		log.With(slog.Int("i", i)).Info("repeated")
+		log.Warn("repeated")
+	}
+	log.Info("other")
+	if got := strings.Count(buf.String(), "level=INFO msg=repeated"); got != 2 {
+		t.Errorf("got %d info records, want 2", got)
+	}
+	if got := strings.Count(buf.String(), "level=WARN msg=repeated"); got != 2 {
+		t.Errorf("got %d warn records, want 2", got)
+	}
+	if !strings.Contains(buf.String(), "msg=other") {
+		t.Error("other record dropped")
+	}
+
+	// The counts are reset once the window passes.
+	s := h.(*samplingHandler).sampler
+	s.start = s.start.Add(-time.Hour)
+	if !s.allow(slog.LevelInfo, "repeated") {
+		t.Error("record dropped in a new window")
+	}
+}
@@ -2,20 +2,24 @@ package service
 
 import (
 	"context"
+	"time"
 )
 
 // EventHandler is a callback function, which is executed when a subscriber
 // receives a message. Note that this function does not return an error, because
 // the message bus does not know how to handle that error and would simply
 // cancel the subscription. Errors have to be handled inside the event handler.
+// The metadata of the message is passed with the context, see
+// [DeliveryFromContext].
 type EventHandler func(ctx context.Context, msg []byte)
 
 // MessageBus defines the interface for publishing messages to a topic and
 // subscribing for receiving messages from a topic.
 type MessageBus interface {
 
-	// Publish publishes a message to a given topic.
-	Publish(_ context.Context, topic string, msg []byte) error
+	// Publish publishes a message to a given topic. The options
+	// are applied with [NewPublishOptions].
+	Publish(_ context.Context, topic string, msg []byte, opts ...PublishOption) error
 
 	// Subscribe subscribes to the given topic. The event handler
 	// callback will be executed on every received message. This
@@ -27,3 +31,97 @@ type MessageBus interface {
 	// all associated resources.
 	Close() error
 }
+
+// PublishOption configures how a message is published, see
+// [MessageBus.Publish].
+type PublishOption func(*PublishOptions)
+
+// PublishOptions holds the options for publishing a message.
+type PublishOptions struct {
+	// DeliverAt is the time at which the message is delivered
+	// to the subscribers. The message is delivered immediately
+	// if it is zero or in the past.
+	DeliverAt time.Time
+
+	// Headers are passed to the subscribers with the message, see
+	// [Delivery].
+	Headers map[string]string
+}
+
+// NewPublishOptions returns the options configured by opts. It is used by the
+// implementations of [MessageBus].
+func NewPublishOptions(opts ...PublishOption) PublishOptions {
+	var o PublishOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithDelay delays the delivery of the message by d, e.g. for sending a
+// reminder before an event starts.
+func WithDelay(d time.Duration) PublishOption {
+	return func(o *PublishOptions) { o.DeliverAt = time.Now().Add(d) }
+}
+
+// WithDeliveryTime delays the delivery of the message until the time t.
+func WithDeliveryTime(t time.Time) PublishOption {
+	return func(o *PublishOptions) { o.DeliverAt = t }
+}
+
+// WithHeader adds a header to the message, e.g. the version of the schema of
+// the payload. Headers starting with "x-" are reserved for the message buses.
+func WithHeader(key, value string) PublishOption {
+	return func(o *PublishOptions) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+		o.Headers[key] = value
+	}
+}
+
+// Delivery holds the metadata of a message received by a subscriber.
+type Delivery struct {
+	// Topic is the topic to which the message was published.
+	Topic string
+
+	// PublishedAt is the time at which the message was
+	// published, or zero if it is not known.
+	PublishedAt time.Time
+
+	// DeliverAt is the time at which the delivery was scheduled
+	// with [WithDelay] or [WithDeliveryTime], or zero if the
+	// message was not delayed.
+	DeliverAt time.Time
+
+	// Headers are the headers of the message, see [WithHeader].
+	// The message bus may add headers of its own, e.g. for
+	// tracing.
+	Headers map[string]string
+}
+
+// Delay returns the time by which the delivery of the message was delayed on
+// purpose, or zero if the message was not delayed.
+func (d Delivery) Delay() time.Duration {
+	if d.DeliverAt.IsZero() || d.PublishedAt.IsZero() || d.DeliverAt.Before(d.PublishedAt) {
+		return 0
+	}
+	return d.DeliverAt.Sub(d.PublishedAt)
+}
+
+type deliveryKey struct{}
+
+// ContextWithDelivery returns a copy of ctx with the metadata of a received
+// message. It is used by the implementations of [MessageBus] for the context
+// passed to the [EventHandler].
+func ContextWithDelivery(ctx context.Context, d Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, d)
+}
+
+// DeliveryFromContext returns the metadata of the message passed to an
+// [EventHandler] with ctx. It reports false if the message bus does not
+// provide it.
+func DeliveryFromContext(ctx context.Context) (Delivery, bool) {
+	d, ok := ctx.Value(deliveryKey{}).(Delivery)
+	return d, ok
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/eventscompass/service-framework/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter(
+		"http_server_requests_total",
+		"Total number of http requests handled by the rest server.",
+		"method", "route", "code",
+	)
+	httpDuration = metrics.NewHistogram(
+		"http_server_request_duration_seconds",
+		"Latency of the http requests handled by the rest server.",
+		nil, "method", "route",
+	)
+	grpcRequests = metrics.NewCounter(
+		"grpc_server_handled_total",
+		"Total number of rpcs completed by the grpc server.",
+		"method", "code",
+	)
+	grpcDuration = metrics.NewHistogram(
+		"grpc_server_handling_seconds",
+		"Latency of the rpcs handled by the grpc server.",
+		nil, "method",
+	)
+)
+
+// unmatchedRoute is the route label of requests that were not handled by any
+// known route.
+const unmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+// Route wraps the given handler so that the requests served by it are labeled
+// with the given route pattern in the metrics and traces, e.g.
+//
+//	r.Handle("/events/{id}", service.Route("/events/{id}", h))
+//
+// If the rest handler of the service is an [http.ServeMux] then the request
+// routes are taken from the mux patterns and there is no need to use Route.
+func Route(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*atomic.Pointer[string]); ok {
+			route.Store(&pattern)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withRoute returns a request whose context holds the route of the request,
+// once the route is set by the wrapped handler. The wrapped handler might be
+// running in a separate goroutine, e.g. if it is wrapped in a timeout handler,
+// so the route is stored atomically.
+func withRoute(r *http.Request) (*http.Request, *atomic.Pointer[string]) {
+	if route, ok := r.Context().Value(routeKey{}).(*atomic.Pointer[string]); ok {
+		return r, route
+	}
+	route := new(atomic.Pointer[string])
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route)), route
+}
+
+// routePattern returns the route that was set by the wrapped handler.
+func routePattern(route *atomic.Pointer[string]) string {
+	if p := route.Load(); p != nil {
+		return *p
+	}
+	return unmatchedRoute
+}
+
+// muxRoutes wraps the mux so that requests are labeled with the pattern of
+// the mux that matched them.
+func muxRoutes(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			Route(pattern, mux).ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// instrumentHTTP wraps the given handler recording the rate, latency and status
+// of the served requests.
+func instrumentHTTP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, route := withRoute(r)
+		rec := &statusRecorder{ResponseWriter: w}
+		defer httpInFlight.track(r.Method + " " + r.URL.Path)()
+
+		start := time.Now()
+		h.ServeHTTP(rec, r)
+
+		pattern := routePattern(route)
+		httpRequests.Inc(r.Method, pattern, strconv.Itoa(rec.statusCode()))
+		httpDuration.Observe(time.Since(start).Seconds(), r.Method, pattern)
+	})
+}
+
+// statusRecorder is an [http.ResponseWriter] that records the status code of
+// the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements the [http.ResponseWriter] interface.
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the [http.ResponseWriter] interface.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b) //nolint:wrapcheck // intentional
+}
+
+// Flush implements the [http.Flusher] interface.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for use by [http.ResponseController].
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// MetricsUnaryInterceptor returns a unary server interceptor that records the
+// rate, latency and status codes of the rpcs. Install it when constructing the
+// grpc server of the service:
+//
+//	grpc.NewServer(grpc.ChainUnaryInterceptor(service.MetricsUnaryInterceptor()))
+//
+// Servers created with [NewGRPCServer] have it installed already.
+func MetricsUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		defer grpcInFlight.track(info.FullMethod)()
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		grpcRequests.Inc(info.FullMethod, status.Code(err).String())
+		grpcDuration.Observe(time.Since(start).Seconds(), info.FullMethod)
+		return resp, err
+	}
+}
+
+// MetricsStreamInterceptor returns a stream server interceptor that records
+// the rate, latency and status codes of the streaming rpcs. Install it when
+// constructing the grpc server of the service:
+//
+//	grpc.NewServer(grpc.ChainStreamInterceptor(service.MetricsStreamInterceptor()))
+//
+// Servers created with [NewGRPCServer] have it installed already.
+func MetricsStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		defer grpcInFlight.track(info.FullMethod)()
+
+		start := time.Now()
+		err := handler(srv, ss)
+		grpcRequests.Inc(info.FullMethod, status.Code(err).String())
+		grpcDuration.Observe(time.Since(start).Seconds(), info.FullMethod)
+		return err
+	}
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+// Middleware wraps an [http.Handler] adding functionality to it.
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps the handler with the given middleware. The first middleware is
+// the outermost one.
+func chain(h http.Handler, mw ...Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// newRESTHandler wraps the rest handler of the service with the framework
+// middleware as configured by cfg, and with the user middleware.
+func newRESTHandler(h http.Handler, cfg *RESTConfig, o *options) (http.Handler, error) {
+	if mux, ok := h.(*http.ServeMux); ok {
+		h = muxRoutes(mux)
+	}
+	h = chain(h, o.middleware...)
+
+	// The timeout values set on the server are used as TCP connection
+	// deadlines. They will close the connection for read/write operations,
+	// but will not stop the handler from processing the request. We wrap
+	// the handler with a timeout in order to stop processing once it is too
+	// late to write the result.
+	// https://ieftimov.com/posts/make-resilient-golang-net-http-servers-using-timeouts-deadlines-context-cancellation/
+	h = http.TimeoutHandler(h, cfg.WriteTimeout, "timeout")
+
+	var mw []Middleware
+	if cfg.RequestID {
+		mw = append(mw, RequestIDMiddleware)
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		realIP, err := RealIPMiddleware(cfg.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		mw = append(mw, realIP)
+	}
+	mw = append(mw, traceHTTP, instrumentHTTP)
+	if cfg.AccessLog {
+		mw = append(mw, AccessLogMiddleware)
+	}
+	if cfg.Recover {
+		mw = append(mw, RecoverMiddleware)
+	}
+	if cfg.MaxBodySize > 0 {
+		mw = append(mw, MaxBodySizeMiddleware(cfg.MaxBodySize))
+	}
+	// The dump reads the whole body, thus it must be limited first.
+	if cfg.DumpRequests {
+		mw = append(mw, dumpRequests)
+	}
+	if cfg.Gzip {
+		mw = append(mw, GzipMiddleware)
+	}
+	return chain(h, mw...), nil
+}
+
+// RequestIDHeader is the header used for propagating request ids.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the id of the request being served, or an empty
+// string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware assigns an id to every request. The id received in the
+// "X-Request-ID" header is reused, otherwise a new one is generated. The id is
+// set in the response header and can be retrieved from the request context
+// using [RequestIDFromContext].
+func RequestIDMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			var b [16]byte
+			_, _ = rand.Read(b[:]) //nolint:errcheck // never fails
+			id = hex.EncodeToString(b[:])
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether the id received from the client is safe to be
+// logged and propagated.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 { //nolint:gomnd // long enough for any id format
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogMiddleware logs every served request.
+func AccessLogMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(rec, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.statusCode()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if id := RequestIDFromContext(r.Context()); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID.String()))
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "served request", attrs...)
+	})
+}
+
+// RecoverMiddleware recovers from panics in the wrapped handler. The panic is
+// logged together with the stack trace, and the client receives a 500
+// response.
+func RecoverMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler { //nolint:errorlint,goerr113 // sentinel panic value
+				panic(p) // let the server abort the response
+			}
+			slog.Error("panic while handling request",
+				slog.Any("panic", p),
+				slog.String("stack", string(debug.Stack())),
+			)
+			HTTPError(r.Context(), w, fmt.Errorf("%w: panic: %v", ErrUnexpected, p))
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// MaxBodySizeMiddleware returns a middleware that limits the size of the
+// request bodies to n bytes. Reading more than n bytes from the body fails with
+// an [http.MaxBytesError], which [HTTPError] maps to 413.
+func MaxBodySizeMiddleware(n int64) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				HTTPError(r.Context(), w, &http.MaxBytesError{Limit: n})
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RealIPMiddleware returns a middleware that sets the remote address of the
+// request to the real ip of the client. The "X-Forwarded-For" and "X-Real-IP"
+// headers are only trusted if the request comes from one of the trusted
+// proxies, given as CIDRs. This function returns [ErrInvalidConfig] if a CIDR
+// cannot be parsed.
+func RealIPMiddleware(trustedProxies []string) (Middleware, error) {
+	nets := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, p := range trustedProxies {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("%w: parse trusted proxy: %v", ErrInvalidConfig, err)
+		}
+		nets = append(nets, n)
+	}
+	trusted := func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := realIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			h.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// realIP returns the ip of the client, or an empty string if the remote
+// address of the request should be used.
+func realIP(r *http.Request, trusted func(net.IP) bool) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !trusted(ip) {
+		return ""
+	}
+
+	// Walk the forwarded addresses from right to left. The first address
+	// that is not a trusted proxy is the client.
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		addrs := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			if !trusted(ip) || i == 0 {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// GzipMiddleware compresses the responses with gzip for the clients that
+// accept it. Responses that already have a content encoding are not
+// compressed.
+func GzipMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") &&
+			strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipResponseWriter compresses the response body. Whether the response is
+// compressed is decided once the status code is written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+// WriteHeader implements the [http.ResponseWriter] interface.
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < http.StatusOK { // informational responses have no body
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+
+	hdr := w.Header()
+	if hdr.Get("Content-Encoding") == "" &&
+		code != http.StatusNoContent && code != http.StatusNotModified {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		w.gz, _ = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the [http.ResponseWriter] interface.
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			// Sniff the content type of the uncompressed body, otherwise
+			// it is sniffed from the compressed one.
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b) //nolint:wrapcheck // intentional
+	}
+	return w.gz.Write(b) //nolint:wrapcheck // intentional
+}
+
+// Flush implements the [http.Flusher] interface.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		_ = w.gz.Flush() //nolint:errcheck // the client went away
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for use by [http.ResponseController].
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	_ = w.gz.Close() //nolint:errcheck // the client went away
+	w.gz.Reset(nil)
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// dumpRequests logs the full content of every request at debug level. The
+// credentials sent in the headers are redacted.
+func dumpRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+			// The clone shares the body, which is replaced with a
+			// buffered copy by the dump.
+			dr := r.Clone(r.Context())
+			for _, k := range redactedHeaders {
+				if _, ok := dr.Header[k]; ok {
+					dr.Header[k] = []string{"[REDACTED]"}
+				}
+			}
+			dump, err := httputil.DumpRequest(dr, true)
+			r.Body = dr.Body
+			if err == nil {
+				slog.Debug("received request", slog.String("request", string(dump)))
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// redactedHeaders are the canonical names of the headers that carry
+// credentials, which must not be logged.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo responds with the request body, or with the error of reading it.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		HTTPError(r.Context(), w, err)
+		return
+	}
+	_, _ = w.Write(body)
+})
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var got string
+	h := RequestIDMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = RequestIDFromContext(r.Context())
+	}))
+
+	for name, tc := range map[string]struct {
+		header string
+		reused bool
+	}{
+		"missing":  {header: ""},
+		"valid":    {header: "abc-123", reused: true},
+		"space":    {header: "abc 123"},
+		"too long": {header: strings.Repeat("a", 129)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				r.Header.Set(RequestIDHeader, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			if tc.reused && got != tc.header {
+				t.Errorf("got id %q, want %q", got, tc.header)
+			}
+			if !tc.reused && (len(got) != 32 || got == tc.header) {
+				t.Errorf("got id %q, want a new one", got)
+			}
+			if rec.Header().Get(RequestIDHeader) != got {
+				t.Errorf("got response id %q, want %q", rec.Header().Get(RequestIDHeader), got)
+			}
+		})
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	if _, err := RealIPMiddleware([]string{"10.0.0.0"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidConfig)
+	}
+
+	mw, err := RealIPMiddleware([]string{"10.0.0.0/8", " 192.168.1.1/32"})
+	if err != nil {
+		t.Fatalf("new middleware: %v", err)
+	}
+	var got string
+	h := mw(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { got = r.RemoteAddr }))
+
+	for name, tc := range map[string]struct {
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		"untrusted proxy": {
+			remote:  "203.0.113.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:    "203.0.113.1:1234",
+		},
+		"no headers": {
+			remote: "10.0.0.1:1234",
+			want:   "10.0.0.1:1234",
+		},
+		"forwarded": {
+			remote:  "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:    "198.51.100.1",
+		},
+		"spoofed by the client": {
+			remote:  "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.0.0.2", "192.168.1.1"}},
+			want:    "198.51.100.1",
+		},
+		"only proxies": {
+			remote:  "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:    "10.0.0.3",
+		},
+		"invalid address": {
+			remote: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"garbage"},
+				"X-Real-Ip":       {"198.51.100.2"},
+			},
+			want: "198.51.100.2",
+		},
+		"real ip": {
+			remote:  "192.168.1.1:1234",
+			headers: map[string][]string{"X-Real-Ip": {" 198.51.100.2 "}},
+			want:    "198.51.100.2",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				r.Header[k] = v
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tc.want {
+				t.Errorf("got remote address %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	h := RecoverMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	// The panic used to abort the response is not recovered.
+	h = RecoverMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler { //nolint:errorlint,goerr113 // sentinel panic value
+			t.Errorf("got panic %v, want %v", p, http.ErrAbortHandler)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestMaxBodySizeMiddleware(t *testing.T) {
+	h := MaxBodySizeMiddleware(4)(echo)
+	for name, tc := range map[string]struct {
+		body    string
+		chunked bool
+		want    int
+	}{
+		"small":         {body: "1234", want: http.StatusOK},
+		"large":         {body: "12345", want: http.StatusRequestEntityTooLarge},
+		"large chunked": {body: "12345", chunked: true, want: http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			if tc.chunked {
+				r.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.want {
+				t.Errorf("got status %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat("<html>compressible</html>", 100)
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+
+	for name, tc := range map[string]struct {
+		path, accept string
+		gzipped      bool
+	}{
+		"gzip":          {path: "/", accept: "deflate, gzip;q=0.8", gzipped: true},
+		"not accepted":  {path: "/", accept: "deflate"},
+		"q=0":           {path: "/", accept: "gzip; q=0"},
+		"encoded":       {path: "/encoded", accept: "gzip"},
+		"no content":    {path: "/empty", accept: "gzip"},
+		"upper case":    {path: "/", accept: "GZIP", gzipped: true},
+		"no encodings":  {path: "/"},
+		"identity only": {path: "/", accept: "identity"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept-Encoding", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("got vary header %q", rec.Header().Get("Vary"))
+			}
+			if got := rec.Header().Get("Content-Encoding") == "gzip"; got != tc.gzipped {
+				t.Fatalf("got content encoding %q", rec.Header().Get("Content-Encoding"))
+			}
+			if !tc.gzipped {
+				return
+			}
+			// The content type is sniffed from the uncompressed body.
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("got content type %q, want text/html", ct)
+			}
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("new gzip reader: %v", err)
+			}
+			if got, _ := io.ReadAll(zr); string(got) != body {
+				t.Errorf("got body %q", got)
+			}
+		})
+	}
+}
+
+func TestDumpRequests(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	level := new(slog.LevelVar)
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: level})))
+
+	// The dump happens inside the body limit.
+	h, err := newRESTHandler(echo, &RESTConfig{
+		DumpRequests: true,
+		MaxBodySize:  8,
+		WriteTimeout: time.Second,
+	}, &options{})
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	serve := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.ContentLength = -1
+		r.Header.Set("Authorization", "Bearer secret-token")
+		r.Header.Set("Cookie", "session=secret-cookie")
+		r.Header.Set("X-Tenant", "a")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	level.Set(slog.LevelDebug)
+	if rec := serve("payload"); rec.Body.String() != "payload" {
+		t.Errorf("got body %q, want payload", rec.Body)
+	}
+	got := logs.String()
+	if strings.Contains(got, "secret") {
+		t.Errorf("credentials logged: %q", got)
+	}
+	for _, want := range []string{"Authorization: [REDACTED]", "Cookie: [REDACTED]", "X-Tenant: a", "payload"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in logs %q", want, got)
+		}
+	}
+
+	logs.Reset()
+	if rec := serve("too large payload"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if strings.Contains(logs.String(), "received request") {
+		t.Errorf("large body logged: %q", logs.String())
+	}
+
+	// Nothing is dumped above the debug level.
+	logs.Reset()
+	level.Set(slog.LevelInfo)
+	if rec := serve("payload"); rec.Body.String() != "payload" {
+		t.Errorf("got body %q, want payload", rec.Body)
+	}
+	if strings.Contains(logs.String(), "received request") {
+		t.Errorf("request dumped at info level: %q", logs.String())
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"net"
+	"reflect"
+
+	"github.com/caarlos0/env/v6"
+
+	"github.com/eventscompass/service-framework/internal/validate"
+)
+
+// Option configures how [Start] runs a service.
+type Option func(*options)
+
+// options holds the configuration set through [Option] values.
+type options struct {
+	// middleware is the user middleware for the rest handler.
+	middleware []Middleware
+
+	ctx       context.Context //nolint:containedctx // the context of the service
+	noSignals bool
+	env       map[string]string
+	config    any
+	listeners map[string]net.Listener
+}
+
+// WithMiddleware adds the given middleware to the rest handler of the service.
+// The middleware are applied in the given order, i.e. the first one is the
+// outermost, and all of them are wrapped by the framework middleware, see
+// [RESTConfig]. This option can be passed multiple times.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(o *options) { o.middleware = append(o.middleware, mw...) }
+}
+
+// WithContext sets the context of the service. The service is shut down when
+// the context is cancelled, in addition to the stop signals.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) { o.ctx = ctx }
+}
+
+// WithoutSignals disables the handling of signals, i.e. the service neither
+// stops, restarts nor toggles the log level upon receiving a signal. Use it
+// together with [WithContext] to stop the service.
+func WithoutSignals() Option {
+	return func(o *options) { o.noSignals = true }
+}
+
+// WithEnv sets the environment from which the configuration of the service is
+// read, instead of the environment variables of the process. Variables that
+// are not set take their default values. The servers created with
+// [NewGRPCServer] are configured from this environment as well.
+func WithEnv(env map[string]string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// WithConfig sets the configuration tree, usually loaded with the config
+// package, from which the sections of the framework components are taken,
+// e.g. the [ServerConfig] and the [RESTConfig]. The sections are found by their
+// type, usually in an embedded [Config]. The sections missing from the tree are
+// read from the environment. The servers created with [NewGRPCServer] are
+// configured with the [GRPCConfig] of the tree as well.
+//
+// If cfg is a [ConfigSource], the configuration is reloaded while the service
+// is running, and the changes are applied to the log level and passed to the
+// service if it is [Reconfigurable].
+func WithConfig(cfg any) Option {
+	return func(o *options) { o.config = cfg }
+}
+
+// WithRESTListener sets the listener of the rest server, instead of listening on
+// the configured address.
+func WithRESTListener(lis net.Listener) Option {
+	return withListener(listenerREST, lis)
+}
+
+// WithGRPCListener sets the listener of the grpc server, instead of listening on
+// the configured address.
+func WithGRPCListener(lis net.Listener) Option {
+	return withListener(listenerGRPC, lis)
+}
+
+// WithAdminListener sets the listener of the admin server, instead of listening
+// on the configured address.
+func WithAdminListener(lis net.Listener) Option {
+	return withListener(listenerAdmin, lis)
+}
+
+func withListener(name string, lis net.Listener) Option {
+	return func(o *options) {
+		if o.listeners == nil {
+			o.listeners = make(map[string]net.Listener)
+		}
+		o.listeners[name] = lis
+	}
+}
+
+// closeListeners closes the listeners set with [WithRESTListener],
+// [WithGRPCListener] and [WithAdminListener].
+func (o *options) closeListeners() {
+	for _, lis := range o.listeners {
+		_ = lis.Close() //nolint:errcheck // intentional
+	}
+}
+
+// loadConfig loads the configuration section of a framework component. The
+// section is copied from the tree set with [WithConfig], if it is found there.
+// Otherwise it is parsed from the environment set with [WithEnv], or from the
+// environment variables of the process.
+func (o *options) loadConfig(cfg any) error {
+	tree := o.config
+	if src, ok := tree.(ConfigSource); ok {
+		tree = src.Current()
+	}
+	if tree != nil && findSection(reflect.ValueOf(tree), reflect.ValueOf(cfg).Elem()) {
+		return nil
+	}
+	return parseEnv(cfg, o.env)
+}
+
+// parseEnv parses the configuration section from the environment, or from the
+// environment variables of the process if environ is nil, and validates it.
+func parseEnv(cfg any, environ map[string]string) error {
+	var opts []env.Options
+	if environ != nil {
+		opts = append(opts, env.Options{Environment: environ})
+	}
+	if err := env.Parse(cfg, opts...); err != nil {
+		return err //nolint:wrapcheck // intentional
+	}
+	return validate.Struct(cfg)
+}
+
+// findSection searches the tree for a section of the type of dst, which is set
+// to the first one found, in depth-first order.
+func findSection(tree, dst reflect.Value) bool {
+	for tree.Kind() == reflect.Pointer || tree.Kind() == reflect.Interface {
+		if tree.IsNil() {
+			return false
+		}
+		tree = tree.Elem()
+	}
+	if tree.Type() == dst.Type() {
+		dst.Set(tree)
+		return true
+	}
+	if tree.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < tree.NumField(); i++ {
+		if tree.Type().Field(i).IsExported() && findSection(tree.Field(i), dst) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// ConfigSource is the source of a configuration tree which can be reloaded
+// while the service is running, e.g. the Loader of the config package. Pass it
+// to [Start] with [WithConfig].
+type ConfigSource interface {
+	// Current returns the current configuration tree.
+	Current() any
+
+	// Reload loads the configuration tree again, and returns
+	// it if it is valid and can be applied while the service is
+	// running. Otherwise it returns [ErrInvalidConfig] and the
+	// current configuration is kept.
+	Reload() (any, error)
+
+	// File returns the file from which the configuration is
+	// loaded. Changes of the file trigger a reload. Returns an
+	// empty string if there is no file.
+	File() string
+}
+
+// Reconfigurable is implemented by services that apply changes of their
+// configuration while running, e.g. rate limits or feature toggles, see
+// [ConfigSource].
+type Reconfigurable interface {
+	// Reconfigure is called with the new configuration tree
+	// after it was reloaded. The returned error is logged.
+	Reconfigure(ctx context.Context, cfg any) error
+}
+
+// reloadSignal is the signal upon which the configuration is reloaded.
+var reloadSignal = syscall.SIGHUP
+
+// configPollInterval is the interval at which the configuration file is checked
+// for changes. Polling works for all ways of updating a file, including the
+// symlink swaps used for Kubernetes config maps.
+const configPollInterval = time.Second
+
+// fileVersion identifies the contents of a file, without reading it.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}
+}
+
+// watchConfig reloads the configuration whenever the file of the source
+// changes or a signal is received on sigCh, and passes the new configuration
+// to apply. It returns when the ctx is done.
+func watchConfig(ctx context.Context, src ConfigSource, sigCh <-chan os.Signal, apply func(cfg any)) {
+	file := src.File()
+	version := statFile(file)
+	var tick <-chan time.Time
+	if file != "" {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-sigCh:
+			slog.Info("received reload signal, reloading config")
+			version = statFile(file)
+		case <-tick:
+			v := statFile(file)
+			if v == version {
+				continue
+			}
+			version = v
+			slog.Info("config file changed, reloading config", slog.String("file", file))
+		case <-ctx.Done():
+			return
+		}
+
+		cfg, err := src.Reload()
+		if err != nil {
+			slog.Error("failed to reload config, keeping the current config", slog.String("error", err.Error()))
+			continue
+		}
+		apply(cfg)
+		slog.Info("config reloaded")
+	}
+}
+
+// applyConfig applies the reloaded configuration. The log level is changed if
+// the configured level changed, and the service is reconfigured if it
+// implements [Reconfigurable].
+func applyConfig(ctx context.Context, s CloudService, cfg any, baseLevel *slog.LevelVar) {
+	var logCfg LogConfig
+	if findSection(reflect.ValueOf(cfg), reflect.ValueOf(&logCfg).Elem()) && logCfg.Level != baseLevel.Level() {
+		baseLevel.Set(logCfg.Level)
+		logLevel.Set(logCfg.Level)
+		slog.Info("log level changed", slog.String("level", logCfg.Level.String()))
+	}
+	if r, ok := s.(Reconfigurable); ok {
+		if err := r.Reconfigure(ctx, cfg); err != nil {
+			slog.Error("failed to reconfigure service", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Requester is implemented by message buses which support request/reply, i.e.
+// sending a message to another service and waiting for its response, e.g. for
+// querying data owned by the other service. The other service handles the
+// requests with a [Responder].
+type Requester interface {
+
+	// Request publishes the message to the given topic and waits
+	// for the response, or until the context is done. Only one
+	// response is returned, even if several services handle the
+	// topic. Returns [ErrNotFound] in case no service handles
+	// requests to the topic, and [ReplyError] in case the request
+	// handler failed.
+	Request(_ context.Context, topic string, msg []byte) ([]byte, error)
+}
+
+// RequestHandler handles a request received with [Responder] and returns the
+// response. A returned error is sent back to the requester as [ReplyError].
+type RequestHandler func(ctx context.Context, msg []byte) ([]byte, error)
+
+// Responder returns an [EventHandler] which replies to the requests of a
+// [Requester] with the responses of h. List it in [CloudService.Events] like
+// any other event handler:
+//
+//	func (s *Service) Events() map[string]service.EventHandler {
+//		return map[string]service.EventHandler{
+//			"events.get": service.Responder(s.getEvent),
+//		}
+//	}
+//
+// Messages which were published without waiting for a response are handled by
+// h as well, and the response is dropped.
+func Responder(h RequestHandler) EventHandler {
+	return func(ctx context.Context, msg []byte) {
+		resp, err := h(ctx, msg)
+		reply, ok := ReplyFromContext(ctx)
+		if !ok {
+			if err != nil {
+				slog.Error("failed to handle message", slog.String("error", err.Error()))
+			}
+			return
+		}
+		if err := reply(ctx, resp, err); err != nil {
+			slog.Error("failed to send reply", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// ReplyFunc sends the reply to a request, which is either the response or the
+// error of the [RequestHandler].
+type ReplyFunc func(ctx context.Context, resp []byte, err error) error
+
+type replyKey struct{}
+
+// ContextWithReply returns a copy of ctx with the function which replies to the
+// received request. It is used by the implementations of [Requester] for the
+// context passed to the [EventHandler].
+func ContextWithReply(ctx context.Context, reply ReplyFunc) context.Context {
+	return context.WithValue(ctx, replyKey{}, reply)
+}
+
+// ReplyFromContext returns the function which replies to the request passed to
+// an [EventHandler] with ctx. It reports false if the message is not a request.
+func ReplyFromContext(ctx context.Context) (ReplyFunc, bool) {
+	reply, ok := ctx.Value(replyKey{}).(ReplyFunc)
+	return reply, ok
+}
+
+// replyCodes are the errors which are told apart by the requester, see
+// [ReplyError].
+var replyCodes = []error{
+	ErrAlreadyExists,
+	ErrBadRequest,
+	ErrConnectionClosed,
+	ErrInvalidConfig,
+	ErrNotAllowed,
+	ErrNotFound,
+	ErrSpaceFull,
+	ErrTimeOut,
+	ErrUnexpected,
+}
+
+// ReplyError is the error returned by [Requester.Request] in case the request
+// handler failed. The errors of this package survive the trip back to the
+// requester, e.g. errors.Is(err, ErrNotFound) reports true if the handler
+// returned a wrapped [ErrNotFound]. All other errors are [ErrUnexpected].
+type ReplyError struct {
+	// Code is the message of the error of this package that is
+	// wrapped by the error of the handler, e.g. "not found", or
+	// empty if there is none.
+	Code string
+
+	// Message is the message of the error of the handler.
+	Message string
+}
+
+// NewReplyError returns the [ReplyError] which is sent to the requester for
+// the error of a request handler.
+func NewReplyError(err error) *ReplyError {
+	e := &ReplyError{Message: err.Error()}
+	var replyErr *ReplyError
+	if errors.As(err, &replyErr) {
+		e.Code = replyErr.Code
+		return e
+	}
+	for _, code := range replyCodes {
+		if errors.Is(err, code) {
+			e.Code = code.Error()
+			break
+		}
+	}
+	return e
+}
+
+func (e *ReplyError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error of this package identified by the code, or
+// [ErrUnexpected].
+func (e *ReplyError) Unwrap() error {
+	for _, code := range replyCodes {
+		if code.Error() == e.Code {
+			return code
+		}
+	}
+	return ErrUnexpected
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// errNotReady is returned when the new process exits without reporting that
+// it is ready.
+var errNotReady = errors.New("new process exited before becoming ready")
+
+// handoff starts a new process of the service executable, passing on the open
+// listeners. It blocks until the new process reports that it is ready, or the
+// timeout passes, in which case the new process is killed.
+func (ls *listeners) handoff(timeout time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	files := make([]*os.File, 0, len(ls.open)+1)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close() //nolint:errcheck // intentional
+		}
+	}()
+	names := make([]string, 0, len(ls.open))
+	for _, lis := range ls.open {
+		fl, ok := lis.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %q cannot be passed on", lis.name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("listener %q: %w", lis.name, err)
+		}
+		files = append(files, f)
+		names = append(names, lis.name)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("create ready pipe: %w", err)
+	}
+	defer r.Close() //nolint:errcheck // intentional
+	files = append(files, w)
+
+	cmd := exec.Command(exe, os.Args[1:]...) //nolint:gosec // the same executable
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	// The watchdog of the service manager is notified by the new process
+	// once it becomes the main process.
+	env := make([]string, 0, len(os.Environ())+2) //nolint:gomnd // the variables added below
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	cmd.Env = append(env,
+		listenersEnv+"="+strings.Join(names, ","),
+		readyFDEnv+"="+strconv.Itoa(listenFDsStart+len(names)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start new process: %w", err)
+	}
+	// Close the write end right away, so that reading fails once the new
+	// process exits.
+	_ = w.Close() //nolint:errcheck // intentional
+	files = files[:len(files)-1]
+
+	_ = r.SetReadDeadline(time.Now().Add(timeout)) //nolint:errcheck // intentional
+	if _, err := io.ReadFull(r, make([]byte, 1)); err != nil {
+		_ = cmd.Process.Kill() //nolint:errcheck // intentional
+		_ = cmd.Wait()         //nolint:errcheck // intentional
+		if errors.Is(err, io.EOF) {
+			return errNotReady
+		}
+		return fmt.Errorf("wait for new process: %w", err)
+	}
+
+	// The socket files are used by the new process, so they must not be
+	// removed when this process closes the listeners.
+	for _, lis := range ls.open {
+		if ul, ok := lis.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return cmd.Process.Release() //nolint:wrapcheck // intentional
+}
+
+// parseSignal returns the signal with the given name, e.g. "SIGUSR2". An empty
+// name returns a nil signal.
+func parseSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return nil, nil //nolint:nilnil // no signal
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return nil, fmt.Errorf("%w: unknown signal %q", ErrInvalidConfig, name)
+	}
+	return sig, nil
+}
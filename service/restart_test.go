@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// handoffFailEnv makes the new process started by the handoff test exit
+// without reporting that it is ready.
+const handoffFailEnv = "SERVICE_TEST_HANDOFF_FAIL"
+
+// runHandoffChild is the new process started by [TestHandoff]. It serves the
+// inherited listeners until a request to "/exit", and reports that it is
+// ready.
+func runHandoffChild() {
+	if os.Getenv(handoffFailEnv) != "" {
+		os.Exit(0)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ls, err := newListeners(nil, 0o600)
+	if err != nil {
+		os.Exit(1)
+	}
+	exit := make(chan struct{})
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "new process")
+			if r.URL.Path == "/exit" {
+				close(exit)
+			}
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	for _, name := range []string{listenerREST, listenerGRPC} {
+		lis, err := ls.listen(name, "")
+		if err != nil {
+			os.Exit(1)
+		}
+		go func() { _ = srv.Serve(lis) }()
+	}
+	ls.notifyReady()
+	select {
+	case <-exit:
+	case <-time.After(10 * time.Second):
+	}
+	ls.close()
+	os.Exit(0)
+}
+
+// body returns the body of the response to a get request, sent with the given
+// client.
+func body(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+// startHandoff opens a tcp and a unix listener, and runs the handoff to a new
+// process of the test binary, which runs only this test.
+func startHandoff(t *testing.T) (*listeners, string, error) {
+	t.Helper()
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHandoff$"}
+	defer func() { os.Args = args }()
+
+	ls, err := newListeners(nil, 0o600)
+	if err != nil {
+		t.Fatalf("new listeners: %v", err)
+	}
+	t.Cleanup(ls.close)
+	if _, err := ls.listen(listenerREST, "127.0.0.1:0"); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "grpc.sock")
+	if _, err := ls.listen(listenerGRPC, unixScheme+path); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return ls, path, ls.handoff(5 * time.Second)
+}
+
+func TestHandoff(t *testing.T) {
+	if os.Getenv(listenersEnv) != "" {
+		runHandoffChild()
+	}
+
+	ls, path, err := startHandoff(t)
+	if err != nil {
+		t.Fatalf("handoff: %v", err)
+	}
+	restAddr := ls.open[0].Addr().String()
+	ls.close()
+
+	// The new process serves the listeners that this process closed, and
+	// the socket file is kept for it.
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	if got := body(t, unixClient, "http://unix/"); got != "new process" {
+		t.Errorf("got response %q on the unix socket, want new process", got)
+	}
+	if got := body(t, http.DefaultClient, "http://"+restAddr+"/exit"); got != "new process" {
+		t.Errorf("got response %q, want new process", got)
+	}
+}
+
+func TestHandoffNotReady(t *testing.T) {
+	t.Setenv(handoffFailEnv, "1")
+	_, _, err := startHandoff(t)
+	if !errors.Is(err, errNotReady) {
+		t.Errorf("got error %v, want %v", err, errNotReady)
+	}
+}
+
+// runRestartChild is the new process started by [TestRestart]. It runs the
+// service on the inherited listeners until a request to "/exit".
+func runRestartChild() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	Start(&testService{rest: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "new process")
+		if r.URL.Path == "/exit" {
+			cancel()
+		}
+	})}, WithContext(ctx), WithoutSignals(), WithEnv(map[string]string{"LOG_LEVEL": "error"}))
+	os.Exit(0)
+}
+
+func TestRestart(t *testing.T) {
+	if os.Getenv(listenersEnv) != "" {
+		runRestartChild()
+	}
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestRestart$"}
+	defer func() { os.Args = args }()
+
+	// The service handles the restart signal, which is sent once it is
+	// ready. This process shuts down once the new one is ready.
+	svc := &testService{rest: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "old process")
+	})}
+	rs := startService(t, svc, map[string]string{"LOG_LEVEL": "error"})
+	waitStatus(t, "http://"+rs.adminAddr+"/readyz", http.StatusOK)
+	if got := body(t, http.DefaultClient, "http://"+rs.restAddr+"/"); got != "old process" {
+		t.Fatalf("got response %q, want old process", got)
+	}
+	if err := unix.Kill(os.Getpid(), unix.SIGUSR2); err != nil {
+		t.Fatalf("send restart signal: %v", err)
+	}
+	rs.wait(t)
+
+	// The new process serves the listeners of the rest and admin servers.
+	if code := get("http://" + rs.adminAddr + "/readyz"); code != http.StatusOK {
+		t.Errorf("got readiness %d from the new process, want %d", code, http.StatusOK)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if got := body(t, client, "http://"+rs.restAddr+"/exit"); got != "new process" {
+		t.Errorf("got response %q, want new process", got)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for name, want := range map[string]os.Signal{
+		"":        nil,
+		"SIGUSR2": unix.SIGUSR2,
+		"SIGHUP":  unix.SIGHUP,
+	} {
+		if got, err := parseSignal(name); err != nil || got != want {
+			t.Errorf("%q: got signal %v and error %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := parseSignal("SIGNOPE"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,289 @@
+// Package servicetest runs a [service.CloudService] in-process for end-to-end
+// tests. The service is started with [service.Start] on ephemeral ports,
+// without handling signals, and is shut down when the test finishes.
+//
+// Services that publish or subscribe for events should use an in-memory bus,
+// see [memory.Bus], so that tests can publish events and wait until they are
+// handled:
+//
+//	func TestBooking(t *testing.T) {
+//		svc := servicetest.Start(t, newService(memory.New()))
+//		svc.Publish(pubsub.EventCreatedTopic, pubsub.EventCreated{ID: "1"})
+//
+//		resp, err := svc.HTTPClient().Get(svc.URL("/events/1"))
+//		...
+//	}
+//
+// The services share the state of the process, e.g. the default logger, the
+// log level and the default tracer, which are set by [service.Start]. Tests
+// that start services must therefore not run in parallel, see [testing.T.Parallel].
+package servicetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/eventscompass/service-framework/pubsub/memory"
+	"github.com/eventscompass/service-framework/service"
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+// runningEnv is set while a service is running for a test. Setting it with
+// [testing.T.Setenv] ensures that the test is not parallel.
+const runningEnv = "SERVICETEST_RUNNING"
+
+// Service is a service running in-process for a test.
+type Service struct {
+	t       testing.TB
+	svc     service.CloudService
+	timeout time.Duration
+
+	restAddr  string
+	adminAddr string
+	client    *http.Client
+	conn      *grpc.ClientConn
+}
+
+// Option configures how [Start] runs a service.
+type Option func(*options)
+
+type options struct {
+	env     map[string]string
+	opts    []service.Option
+	timeout time.Duration
+}
+
+// WithEnv sets environment variables from which the configuration of the
+// service is read. The environment variables of the process are ignored.
+func WithEnv(env map[string]string) Option {
+	return func(o *options) {
+		for k, v := range env {
+			o.env[k] = v
+		}
+	}
+}
+
+// WithOptions adds options with which the service is started, e.g.
+// [service.WithMiddleware].
+func WithOptions(opts ...service.Option) Option {
+	return func(o *options) { o.opts = append(o.opts, opts...) }
+}
+
+// WithTimeout sets the time the service has to become ready, to handle the
+// published events and to shut down. The default is 10 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// Start starts the service and blocks until it is ready. The test fails if the
+// service stops before becoming ready. The service is shut down when the test
+// and all its subtests complete. The readiness of the service is checked on the
+// admin server, so the admin server must not be disabled.
+//
+// Start must not be called by parallel tests: like [testing.T.Setenv], it
+// panics if the test or one of its ancestors is parallel. The default logger and the default tracer set by the service are restored when
+// the test completes.
+func Start(t testing.TB, s service.CloudService, opts ...Option) *Service {
+	t.Helper()
+	t.Setenv(runningEnv, "1")
+	logger, tracer := slog.Default(), tracing.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		tracing.SetDefault(tracer)
+	})
+
+	o := options{
+		// Logs are kept short, and the shutdown is not delayed by requests
+		// that are left running by the test.
+		env: map[string]string{
+			"LOG_LEVEL":               "warn",
+			"SERVER_SHUTDOWN_TIMEOUT": "1s",
+		},
+		timeout: 10 * time.Second, //nolint:gomnd // reasonable default
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if addr, ok := o.env["ADMIN_SERVER_LISTEN"]; ok && addr == "" {
+		t.Fatal("the admin server is disabled, but it is needed for checking readiness")
+	}
+
+	restLis, grpcLis, adminLis := listen(t), listen(t), listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.Start(s, append([]service.Option{
+			service.WithContext(ctx),
+			service.WithoutSignals(),
+			service.WithEnv(o.env),
+			service.WithRESTListener(restLis),
+			service.WithGRPCListener(grpcLis),
+			service.WithAdminListener(adminLis),
+		}, o.opts...)...)
+	}()
+
+	conn, err := grpc.Dial(grpcLis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(service.TracingUnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(service.TracingStreamClientInterceptor()),
+	)
+	if err != nil {
+		cancel()
+		t.Fatalf("dial grpc server: %v", err)
+	}
+
+	svc := &Service{
+		t:         t,
+		svc:       s,
+		timeout:   o.timeout,
+		restAddr:  restLis.Addr().String(),
+		adminAddr: adminLis.Addr().String(),
+		client:    &http.Client{Transport: &tracing.Transport{}, Timeout: o.timeout},
+		conn:      conn,
+	}
+	t.Cleanup(func() {
+		_ = conn.Close() //nolint:errcheck // intentional
+		svc.client.CloseIdleConnections()
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(o.timeout):
+			t.Errorf("service did not shut down within %v", o.timeout)
+		}
+	})
+
+	svc.waitReady(done)
+	return svc
+}
+
+// listen opens a listener on an ephemeral port of the loopback interface.
+func listen(t testing.TB) net.Listener {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return lis
+}
+
+// waitReady blocks until the readiness endpoint of the service reports that it
+// is ready, and its subscriptions on the in-memory bus are active.
+func (s *Service) waitReady(done <-chan struct{}) {
+	s.t.Helper()
+	deadline := time.Now().Add(s.timeout)
+	for {
+		resp, err := s.client.Get(s.AdminURL("/readyz"))
+		if err == nil {
+			_ = resp.Body.Close() //nolint:errcheck // intentional
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// The admin listener is closed if the service does not use
+			// it, or when the service stops.
+			select {
+			case <-done:
+				s.t.Fatal("service stopped before becoming ready")
+			case <-time.After(100 * time.Millisecond): //nolint:gomnd // grace period
+				s.t.Fatal("the admin server is disabled, but it is needed for checking readiness")
+			}
+		}
+		select {
+		case <-done:
+			s.t.Fatal("service stopped before becoming ready")
+		case <-time.After(10 * time.Millisecond): //nolint:gomnd // poll interval
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("service not ready within %v", s.timeout)
+		}
+	}
+
+	bus, ok := s.svc.Bus().(*memory.Bus)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	for topic := range s.svc.Events() {
+		if err := bus.WaitForSubscribers(ctx, topic, 1); err != nil {
+			s.t.Fatalf("wait for subscription to %q: %v", topic, err)
+		}
+	}
+}
+
+// URL returns the url of the given path on the rest server.
+func (s *Service) URL(path string) string {
+	return "http://" + s.restAddr + "/" + strings.TrimPrefix(path, "/")
+}
+
+// AdminURL returns the url of the given path on the admin server.
+func (s *Service) AdminURL(path string) string {
+	return "http://" + s.adminAddr + "/" + strings.TrimPrefix(path, "/")
+}
+
+// HTTPClient returns a client for the rest and admin servers. The client
+// propagates the trace context of the requests.
+func (s *Service) HTTPClient() *http.Client {
+	return s.client
+}
+
+// GRPCConn returns a connection to the grpc server, on which clients of the
+// grpc services can be created.
+func (s *Service) GRPCConn() *grpc.ClientConn {
+	return s.conn
+}
+
+// Bus returns the in-memory bus of the service. The test fails if the service
+// does not use an in-memory bus.
+func (s *Service) Bus() *memory.Bus {
+	s.t.Helper()
+	bus, ok := s.svc.Bus().(*memory.Bus)
+	if !ok {
+		s.t.Fatalf("service bus is %T, not an in-memory bus", s.svc.Bus())
+	}
+	return bus
+}
+
+// Publish publishes the payload to the given topic on the bus of the service,
+// and blocks until all handlers are done with it and with any messages they
+// published in turn. Payloads other than []byte are encoded as json.
+func (s *Service) Publish(topic string, payload any) {
+	s.t.Helper()
+	msg, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if msg, err = json.Marshal(payload); err != nil {
+			s.t.Fatalf("encode payload: %v", err)
+		}
+	}
+	bus := s.Bus()
+	if err := bus.Publish(context.Background(), topic, msg); err != nil {
+		s.t.Fatalf("publish to %q: %v", topic, err)
+	}
+	s.Wait()
+}
+
+// Wait blocks until all messages published on the bus of the service have been
+// handled. Use it after triggering requests that publish events.
+func (s *Service) Wait() {
+	s.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if err := s.Bus().Wait(ctx); err != nil {
+		s.t.Fatalf("wait for handlers: %v", err)
+	}
+}
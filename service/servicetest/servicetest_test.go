@@ -0,0 +1,51 @@
+package servicetest_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/eventscompass/service-framework/service"
+	"github.com/eventscompass/service-framework/service/servicetest"
+)
+
+type helloService struct {
+	service.BaseService
+}
+
+func (s *helloService) REST() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+}
+
+func TestStart(t *testing.T) {
+	logger := slog.Default()
+	t.Run("serve", func(t *testing.T) {
+		svc := servicetest.Start(t, &helloService{})
+		resp, err := svc.HTTPClient().Get(svc.URL("/"))
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+		if b, _ := io.ReadAll(resp.Body); string(b) != "hello" {
+			t.Errorf("got response %q, want hello", b)
+		}
+	})
+	if slog.Default() != logger {
+		t.Error("default logger not restored")
+	}
+}
+
+func TestStartParallel(t *testing.T) {
+	t.Run("parallel", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			if recover() == nil {
+				t.Error("service started by a parallel test")
+			}
+		}()
+		servicetest.Start(t, &helloService{})
+	})
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// The states of the service reported by the readiness endpoint. The service
+// becomes ready once all servers are started, and stopping as soon as the
+// shutdown begins, so that load balancers stop routing new requests during the
+// drain phase.
+const (
+	stateStarting int32 = iota
+	stateReady
+	stateStopping
+)
+
+// handleHealthz reports that the process is alive.
+func handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte("ok\n")) //nolint:errcheck // intentional
+}
+
+// readyzHandler reports whether the service is ready to accept traffic. It
+// fails with 503 before the servers are started and while the service shuts
+// down.
+func readyzHandler(state *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if state.Load() != stateReady {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n")) //nolint:errcheck // intentional
+	}
+}
+
+// inFlight counts the requests that are being handled, keyed by the request
+// method, so that the requests interrupted by a forced shutdown can be logged.
+type inFlight struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+var (
+	httpInFlight = &inFlight{m: make(map[string]int)}
+	grpcInFlight = &inFlight{m: make(map[string]int)}
+)
+
+// track records the start of a request. The returned function must be called
+// when the request is done.
+func (f *inFlight) track(key string) func() {
+	f.mu.Lock()
+	f.m[key]++
+	f.mu.Unlock()
+	return func() {
+		f.mu.Lock()
+		if f.m[key]--; f.m[key] == 0 {
+			delete(f.m, key)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// attr returns a log attribute summarising the requests in flight.
+func (f *inFlight) attr() slog.Attr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs := make([]any, 0, len(f.m))
+	for key, n := range f.m {
+		attrs = append(attrs, slog.Int(key, n))
+	}
+	return slog.Group("in_flight", attrs...)
+}
+
+// drain is closed once the drain phase of the shutdown is over. The servers
+// then have until the deadline to shut down gracefully.
+type drain struct {
+	done     chan struct{}
+	deadline time.Time
+}
+
+// start runs the drain phase: it waits for the delay and then sets the
+// deadline for the shutdown.
+func (d *drain) start(delay, timeout time.Duration) {
+	if delay > 0 {
+		slog.Info("draining before shutdown", slog.Duration("delay", delay))
+		time.Sleep(delay)
+	}
+	d.deadline = time.Now().Add(timeout)
+	close(d.done)
+}
+
+// wait blocks until the drain phase is over and returns a context that expires
+// at the shutdown deadline.
+func (d *drain) wait() (context.Context, context.CancelFunc) {
+	<-d.done
+	return context.WithDeadline(context.Background(), d.deadline)
+}
+
+// ignoreServerClosed returns nil if err reports that the http server was shut
+// down, which is how serving normally ends.
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// shutdownHTTP gracefully shuts down the server. Once the context expires the
+// connections that are still active are closed.
+func shutdownHTTP(ctx context.Context, name string, srv *http.Server) error {
+	err := srv.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err //nolint:wrapcheck // intentional
+	}
+	slog.Warn("shutdown deadline exceeded, closing server",
+		slog.String("server", name),
+		httpInFlight.attr(),
+	)
+	return srv.Close() //nolint:wrapcheck // intentional
+}
+
+// shutdownGRPC gracefully stops the server. Once the context expires the rpcs
+// that are still running are cancelled.
+func shutdownGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("shutdown deadline exceeded, stopping server",
+			slog.String("server", "grpc"),
+			grpcInFlight.attr(),
+		)
+		srv.Stop()
+		<-done
+	}
+}
+
+// closeService releases the resources of the service after it stopped. The
+// service is closed before its message bus, which it may still use.
+func closeService(s CloudService) {
+	if c, ok := s.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("failed to close service", slog.String("error", err.Error()))
+		}
+	}
+	if bus := s.Bus(); bus != nil {
+		if err := bus.Close(); err != nil {
+			slog.Error("failed to close message bus", slog.String("error", err.Error()))
+		}
+	}
+}
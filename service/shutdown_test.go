@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestShutdownGRPC(t *testing.T) {
+	lis := listenTCP(t)
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// The watch stream never ends on its own, thus the server is stopped
+	// once the deadline passes.
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	shutdownGRPC(ctx, srv)
+	if d := time.Since(start); d < 100*time.Millisecond || d > 2*time.Second {
+		t.Errorf("shutdown took %v with a timeout of 100ms", d)
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Error("stream still open after shutdown")
+	}
+	if _, err := net.Dial("tcp", lis.Addr().String()); err == nil {
+		t.Error("server still listening after shutdown")
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	f := &inFlight{m: make(map[string]int)}
+	doneA := f.track("GET")
+	doneB := f.track("GET")
+	doneC := f.track("POST")
+	doneB()
+
+	got := f.attr().String()
+	if !strings.Contains(got, "GET=1") || !strings.Contains(got, "POST=1") {
+		t.Errorf("got in flight %s, want GET=1 and POST=1", got)
+	}
+	doneA()
+	doneC()
+	if len(f.m) != 0 {
+		t.Errorf("got in flight %v after all requests are done", f.m)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	d := &drain{done: make(chan struct{})}
+	start := time.Now()
+	go d.start(50*time.Millisecond, time.Minute)
+
+	ctx, cancel := d.wait()
+	defer cancel()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("drain phase ended before the delay")
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > time.Minute || time.Until(deadline) < 59*time.Second {
+		t.Errorf("got shutdown deadline in %v, want a minute", time.Until(deadline))
+	}
+}
@@ -2,14 +2,18 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/caarlos0/env/v6"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 )
@@ -18,14 +22,78 @@ import (
 // accepts requests to the service.
 //
 // If the service exposes both rest and grpc apis, then two separate servers are
-// started to serve each api. If the service is subscribed for events from a
-// message broker, then we will also start listening for these events.
+// started to serve each api. The servers listen on separate ports, unless a
+// single port is configured, see [ServerConfig]. Instead of ports, the servers
+// can also listen on unix domain sockets. The grpc services can be exposed as
+// http endpoints on the rest server, see [GRPCConfig]. If the service is
+// subscribed for events from a message broker, then we will also start
+// listening for these events.
+//
+// The rest server is instrumented with metrics about the served requests, which
+// are exposed together with the metrics of the other framework components on
+// the "/metrics" endpoint of the admin server.
+//
+// Every request served by the rest server is also traced, and the trace context
+// is propagated using the W3C trace context headers, see [TracingConfig].
+//
+// The rest handler of the service can be wrapped with a standard middleware
+// stack, see [RESTConfig], and with user middleware, see [WithMiddleware].
+//
+// Before initializing the service, a structured logger is installed as the
+// default [slog] logger, see [LogConfig]. The log level can be changed at
+// runtime either through the admin server, see [AdminConfig], or by sending
+// SIGUSR1 to the process, which toggles between debug and the configured level.
+// The admin server is not authenticated and listens only on the loopback
+// interface by default.
+//
+// On SIGINT or SIGTERM the service is shut down gracefully. The readiness
+// endpoint of the admin server starts failing and the servers keep serving for
+// a drain delay, after which they stop accepting connections and have until
+// the shutdown timeout to finish the active requests, see [ServerConfig]. The
+// requests still active after the timeout are interrupted and logged. A second
+// stop signal forces the process to exit immediately.
+//
+// The service can be restarted without downtime, e.g. to upgrade the
+// executable, by sending the restart signal, SIGUSR2 by default. A new process
+// of the executable is started and inherits the listeners of the servers. Once
+// it reports that it is ready, this process shuts down gracefully.
+//
+// When run by systemd, the listeners passed by socket activation are used by
+// the servers with the matching name ("rest", "grpc", "server" or "admin"), as
+// set with FileDescriptorName= in the socket unit. The service manager is
+// notified when the service is ready and stopping, and the watchdog is notified
+// periodically if enabled.
+//
+// The configuration can be reloaded while the service is running, when its
+// file changes or on SIGHUP, see [WithConfig]. Changes of the values that
+// cannot be applied without a restart, e.g. the listen addresses, are rejected.
+//
+// The options change how the service is run, e.g. to run it in-process with a
+// context, an injected configuration and pre-opened listeners, as done by the
+// servicetest package for end-to-end tests.
+//
+// Once the servers are shut down and the subscriptions are cancelled, the message
+// bus of the service is closed, and so is the service itself if it implements
+// [io.Closer], e.g. to close its database client.
 //
 // This is a blocking function that waits for the api server(s) to stop running.
 //
-//nolint:funlen,gocognit,gocyclo,cyclop,wrapcheck // we will make up with extensive testing
-func Start(s CloudService) {
-	ctx, cancel := context.WithCancel(context.Background())
+//nolint:funlen,gocognit,gocyclo,cyclop,wrapcheck // the startup steps are tested end-to-end
+func Start(s CloudService, opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// The listeners provided with the options are closed also when the
+	// service fails to start, see [listeners.close].
+	defer o.closeListeners()
+
+	parent := context.Background()
+	if o.ctx != nil {
+		parent = o.ctx
+	}
+	ctx, cancel := context.WithCancel(parent)
+	var state atomic.Int32 // the state reported by the readiness endpoint
 	defer cancel()
 	defer func() {
 		if msg := recover(); msg != nil {
@@ -33,13 +101,45 @@ func Start(s CloudService) {
 		}
 	}()
 
+	// Set up the logger before anything else, so that all of the following
+	// log records are emitted in the configured format.
+	var logCfg LogConfig
+	if err := o.loadConfig(&logCfg); err != nil {
+		slog.Error("failed to load log config", slog.String("error", err.Error()))
+		return
+	}
+	if err := setupLogger(&logCfg); err != nil {
+		slog.Error("failed to set up logger", slog.String("error", err.Error()))
+		return
+	}
+
+	var tracingCfg TracingConfig
+	if err := o.loadConfig(&tracingCfg); err != nil {
+		slog.Error("failed to load tracing config", slog.String("error", err.Error()))
+		return
+	}
+	tracer, err := setupTracer(&tracingCfg)
+	if err != nil {
+		slog.Error("failed to set up tracer", slog.String("error", err.Error()))
+		return
+	}
+	defer func() {
+		// Flush the spans that are still buffered.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) //nolint:gomnd // reasonable default
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Error("failed to shut down tracer", slog.String("error", err.Error()))
+		}
+	}()
+
 	// Init the service components.
 	if err := s.Init(ctx); err != nil {
-		slog.Error("failed to init service", err)
+		slog.Error("failed to init service", slog.String("error", err.Error()))
 		return
 	}
-	// TODO: defer a call that closes all initialized resources.
-	// Stop listening for events, close the message bus, close the database client.
+	// The subscriptions end with the context, and the resources of the
+	// service are released once the servers are shut down.
+	defer closeService(s)
 
 	// We will use an error group to start the server(s).
 	// Start one goroutine that runs the server and another that waits to
@@ -47,63 +147,189 @@ func Start(s CloudService) {
 	// returns an error, the ctx is cancelled and the shutdown is triggered.
 	g, ctx := errgroup.WithContext(ctx)
 
-	if restHandler := s.REST(); restHandler != nil { // run the http server
+	var srvCfg ServerConfig
+	if err := o.loadConfig(&srvCfg); err != nil {
+		slog.Error("failed to load server config", slog.String("error", err.Error()))
+		return
+	}
+	restartSig, err := parseSignal(srvCfg.RestartSignal)
+	if err != nil {
+		slog.Error("failed to parse restart signal", slog.String("error", err.Error()))
+		return
+	}
+	socketMode, err := strconv.ParseUint(srvCfg.SocketMode, 8, 32)
+	if err != nil {
+		slog.Error("failed to parse socket mode", slog.String("error", err.Error()))
+		return
+	}
+	ls, err := newListeners(o.listeners, os.FileMode(socketMode))
+	if err != nil {
+		slog.Error("failed to init inherited listeners", slog.String("error", err.Error()))
+		return
+	}
+	defer ls.close()
+	restHandler, grpcSrv := s.REST(), s.GRPC()
+	if grpcSrv != nil {
+		defer releaseGRPCServer(grpcSrv)
+	}
+
+	// Once the ctx is cancelled the service reports that it is not ready and
+	// keeps serving for the drain delay. Only then are the servers shut down.
+	// After a restart, the new process is the main process of the service
+	// and systemd would stop it if this process reported that it is stopping.
+	dr := &drain{done: make(chan struct{})}
+	var handedOff atomic.Bool
+	g.Go(func() error {
+		<-ctx.Done() // block until context is cancelled
+		state.Store(stateStopping)
+		if !handedOff.Load() {
+			notifySystemd(sdStopping)
+		}
+		if grpcSrv != nil {
+			grpcHealthShutdown(grpcSrv)
+		}
+		dr.start(srvCfg.DrainDelay, srvCfg.ShutdownTimeout)
+		return nil
+	})
+
+	var grpcCfg GRPCConfig
+	if err := o.loadConfig(&grpcCfg); err != nil {
+		slog.Error("failed to load grpc config", slog.String("error", err.Error()))
+		return
+	}
+	if grpcSrv != nil {
+		configureGRPCServer(grpcSrv, &grpcCfg)
+	}
+
+	// The gateway serves the grpc services as http endpoints, so it is
+	// mounted on the rest server in front of the rest handler of the service.
+	if grpcSrv != nil && grpcCfg.Gateway {
+		gw, err := newGateway(grpcSrv)
+		if err != nil {
+			slog.Error("failed to init grpc gateway", slog.String("error", err.Error()))
+			return
+		}
+		defer gw.close() //nolint:errcheck // intentional
+		restHandler = gw.handler(restHandler)
+		g.Go(func() error { return grpcSrv.Serve(gw.lis) })
+	}
+
+	// In single port mode, the connections accepted on the shared listener
+	// are dispatched to the rest and grpc servers by the mux.
+	var mux *connMux
+	if srvCfg.Listen != "" && (restHandler != nil || grpcSrv != nil) {
+		lis, err := ls.listen(listenerServer, srvCfg.Listen)
+		if err != nil {
+			slog.Error("failed to init listener", slog.String("error", err.Error()))
+			return
+		}
+		mux = newConnMux(lis)
+		if restHandler == nil {
+			_ = mux.rest.Close() //nolint:errcheck // never fails
+		}
+		if grpcSrv == nil {
+			_ = mux.grpc.Close() //nolint:errcheck // never fails
+		}
+		slog.Info("starting single port server", slog.String("port", srvCfg.Listen))
+		g.Go(mux.serve)
+		g.Go(func() error {
+			// The servers close their listeners when they shut down. Once
+			// both are closed, no more connections can be dispatched.
+			<-mux.rest.done
+			<-mux.grpc.done
+			return mux.close()
+		})
+	}
+
+	if restHandler != nil { // run the http server
 		var cfg RESTConfig
-		if err := env.Parse(&cfg); err != nil {
-			slog.Error("failed to parse rest environment variables", err)
+		if err := o.loadConfig(&cfg); err != nil {
+			slog.Error("failed to load rest config", slog.String("error", err.Error()))
 			return
 		}
 
-		// The timeout values set on the server are used as TCP connection
-		// deadlines. They will close the connection for read/write operations,
-		// but will not stop the handler from processing the request. We wrap
-		// the handler with a timeout in order to stop processing once it is too
-		// late to write the result.
-		// https://ieftimov.com/posts/make-resilient-golang-net-http-servers-using-timeouts-deadlines-context-cancellation/
-		h := http.TimeoutHandler(restHandler, cfg.WriteTimeout, "timeout")
+		h, err := newRESTHandler(restHandler, &cfg, &o)
+		if err != nil {
+			slog.Error("failed to set up rest middleware", slog.String("error", err.Error()))
+			return
+		}
+		var lis net.Listener
+		if mux != nil {
+			// Clients on the single port may use plaintext HTTP/2 (h2c),
+			// which is negotiated by the h2c handler.
+			lis = mux.rest
+			h = h2c.NewHandler(h, &http2.Server{})
+		} else if lis, err = ls.listen(listenerREST, cfg.Listen); err != nil {
+			slog.Error("failed to init rest listener", slog.String("error", err.Error()))
+			return
+		}
 		restSrv := &http.Server{
 			// Increase the write timeout by a small margin (2s) to allow the
 			// handler to write the timeout response in case of a timeout.
 			WriteTimeout:      cfg.WriteTimeout + 2*time.Second,
 			ReadTimeout:       cfg.ReadTimeout,
 			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
-			Addr:              cfg.Listen,
 			Handler:           h,
 		}
-		slog.Info("starting rest server", slog.String("port", cfg.Listen))
+		slog.Info("starting rest server", slog.String("port", lis.Addr().String()))
 		// TODO: Secure.
-		// g.Go(func() error { return restSrv.ListenAndServeTLS("", "") })
-		g.Go(func() error { return restSrv.ListenAndServe() })
+		// g.Go(func() error { return restSrv.ServeTLS(lis, "", "") })
+		g.Go(func() error { return ignoreServerClosed(restSrv.Serve(lis)) })
 		g.Go(func() error {
-			<-ctx.Done() // block until context is cancelled
+			ctx, cancel := dr.wait() //nolint:contextcheck // intentional
+			defer cancel()
 			slog.Info("shutting down rest server")
-			return restSrv.Shutdown(context.Background()) //nolint:contextcheck // intentional
+			return shutdownHTTP(ctx, "rest", restSrv)
 		})
 	}
 
-	if grpcSrv := s.GRPC(); grpcSrv != nil { // run the grpc server
-		var cfg GRPCConfig
-		if err := env.Parse(&cfg); err != nil {
-			slog.Error("failed to parse grpc environment variables", err)
-			return
-		}
-
-		lis, err := net.Listen("tcp", cfg.Listen)
-		if err != nil {
-			slog.Error("failed to init grpc listener", err)
+	if grpcSrv != nil { // run the grpc server
+		var lis net.Listener
+		if mux != nil {
+			lis = mux.grpc
+		} else if lis, err = ls.listen(listenerGRPC, grpcCfg.Listen); err != nil {
+			slog.Error("failed to init grpc listener", slog.String("error", err.Error()))
 			return
 		}
 		defer lis.Close() //nolint:errcheck // intentional
-		slog.Info("starting grpc server", slog.String("port", cfg.Listen))
+		slog.Info("starting grpc server", slog.String("port", lis.Addr().String()))
 		g.Go(func() error { return grpcSrv.Serve(lis) })
 		g.Go(func() error {
-			<-ctx.Done() // block until context is cancelled
+			ctx, cancel := dr.wait() //nolint:contextcheck // intentional
+			defer cancel()
 			slog.Info("shutting down grpc server")
-			grpcSrv.GracefulStop()
+			shutdownGRPC(ctx, grpcSrv)
 			return nil
 		})
 	}
 
+	var adminCfg AdminConfig
+	if err := o.loadConfig(&adminCfg); err != nil {
+		slog.Error("failed to load admin config", slog.String("error", err.Error()))
+		return
+	}
+	if adminCfg.Listen != "" { // run the admin server
+		lis, err := ls.listen(listenerAdmin, adminCfg.Listen)
+		if err != nil {
+			slog.Error("failed to init admin listener", slog.String("error", err.Error()))
+			return
+		}
+		adminSrv := &http.Server{
+			ReadHeaderTimeout: 10 * time.Second, //nolint:gomnd // admin requests are small
+			Handler:           newAdminHandler(&state),
+		}
+		slog.Info("starting admin server", slog.String("port", lis.Addr().String()))
+		g.Go(func() error { return ignoreServerClosed(adminSrv.Serve(lis)) })
+		g.Go(func() error {
+			// The admin server keeps running during the drain phase, so
+			// that the readiness endpoint can report the shutdown.
+			ctx, cancel := dr.wait() //nolint:contextcheck // intentional
+			defer cancel()
+			slog.Info("shutting down admin server")
+			return shutdownHTTP(ctx, "admin", adminSrv)
+		})
+	}
+
 	// In case the service is subscribed to a message broker, we will listen for
 	// events inside the error group.
 	if events := s.Events(); events != nil { // listen for events
@@ -119,27 +345,116 @@ func Start(s CloudService) {
 		}
 	}
 
+	// Toggle the debug log level whenever the level signal is received. The
+	// configured level may change when the configuration is reloaded.
+	baseLevel := new(slog.LevelVar)
+	baseLevel.Set(logCfg.Level)
+	levelCh := make(chan os.Signal, 1)
+	if !o.noSignals {
+		signal.Notify(levelCh, levelSignal)
+	}
+	defer signal.Stop(levelCh)
+	g.Go(func() error {
+		for {
+			select {
+			case <-levelCh:
+				toggleDebug(baseLevel.Level())
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	// Reload the configuration whenever its file changes or the reload signal
+	// is received, and apply the changes that do not require a restart.
+	if src, ok := o.config.(ConfigSource); ok {
+		reloadCh := make(chan os.Signal, 1)
+		if !o.noSignals {
+			signal.Notify(reloadCh, reloadSignal)
+		}
+		defer signal.Stop(reloadCh)
+		g.Go(func() error {
+			watchConfig(ctx, src, reloadCh, func(cfg any) { applyConfig(ctx, s, cfg, baseLevel) })
+			return nil
+		})
+	}
+
+	// Restart the service whenever the restart signal is received. Once the
+	// new process is ready, this process shuts down.
+	if restartSig != nil && !o.noSignals {
+		restartCh := make(chan os.Signal, 1)
+		signal.Notify(restartCh, restartSig)
+		defer signal.Stop(restartCh)
+		g.Go(func() error {
+			for {
+				select {
+				case <-restartCh:
+					slog.Info("restarting service")
+					if err := ls.handoff(srvCfg.RestartTimeout); err != nil {
+						slog.Error("failed to restart service", slog.String("error", err.Error()))
+						continue
+					}
+					slog.Info("new process is ready, shutting down")
+					handedOff.Store(true)
+					cancel()
+					return nil
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+
 	// Wait for interrupt signals. Upon receiving one of these signals, the ctx
 	// will be cancelled, initiating a graceful shutdown of the server(s).
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, stopSignals...)
+	if !o.noSignals {
+		signal.Notify(ch, stopSignals...)
+	}
+	defer signal.Stop(ch)
 	g.Go(func() error {
 		select {
 		case sig := <-ch:
 			slog.Info("received stop signal", slog.Any("signal", sig))
 			cancel()
 		case <-ctx.Done():
-			// Currently, the only way to cancel the context is by sending a
-			// stop signal. However, if the context were to be cancelled in some
-			// other way, then this goroutine would hang, blocking g.Wait().
-			// For that reason we include this case here.
+			// The context is also cancelled when the context of the service
+			// is done, see [WithContext], or when one of the goroutines in
+			// the group fails. Then this goroutine would hang, blocking
+			// g.Wait(). For that reason we include this case here.
 		}
 		return nil
 	})
 
+	// A stop signal received while shutting down forces the process to exit
+	// without waiting for the active requests.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		<-ctx.Done() // block until context is cancelled
+		select {
+		case sig := <-ch:
+			slog.Error("received second stop signal, forcing exit",
+				slog.Any("signal", sig),
+				slog.Group("rest", httpInFlight.attr()),
+				slog.Group("grpc", grpcInFlight.attr()),
+			)
+			os.Exit(1)
+		case <-stopped:
+		}
+	}()
+	go watchdog(stopped)
+
 	// Block until the service stops.
+	ls.closeUnused()
+	if state.CompareAndSwap(stateStarting, stateReady) {
+		ls.notifyReady()
+		// After a restart the new process becomes the main process of the
+		// service, which systemd accepts only with NotifyAccess=all.
+		notifySystemd(fmt.Sprintf("%s\nMAINPID=%d", sdReady, os.Getpid()))
+	}
 	if err := g.Wait(); err != nil {
-		slog.Error("received an error during serving", err)
+		slog.Error("received an error during serving", slog.String("error", err.Error()))
 	}
 }
 
@@ -147,4 +462,7 @@ var (
 	// stopSignals are the interrupt and termination signals from the operating
 	// system that the service listens for.
 	stopSignals = []os.Signal{unix.SIGINT, unix.SIGTERM}
+
+	// levelSignal is the signal that toggles the debug log level.
+	levelSignal os.Signal = unix.SIGUSR1
 )
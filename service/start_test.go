@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+// testService serves the rest handler, and records whether it and its bus are
+// closed.
+type testService struct {
+	BaseService
+	rest    http.Handler
+	initErr error
+	bus     closeBus
+	closed  atomic.Bool
+}
+
+func (s *testService) Init(context.Context) error { return s.initErr }
+func (s *testService) REST() http.Handler         { return s.rest }
+func (s *testService) Bus() MessageBus            { return &s.bus }
+func (s *testService) Close() error               { s.closed.Store(true); return nil }
+
+// closeBus is a message bus that only records whether it is closed.
+type closeBus struct {
+	closed atomic.Bool
+}
+
+func (b *closeBus) Publish(context.Context, string, []byte, ...PublishOption) error { return nil }
+func (b *closeBus) Subscribe(context.Context, string, EventHandler) error           { return nil }
+func (b *closeBus) Close() error                                                    { b.closed.Store(true); return nil }
+
+// runningService is a service started with [startService].
+type runningService struct {
+	restAddr  string
+	adminAddr string
+	stop      context.CancelFunc
+	done      chan struct{} // closed once Start returns
+}
+
+// startService runs [Start] with the given environment and options until the
+// returned service is stopped. The process-wide state changed by Start is
+// restored when the test finishes.
+func startService(t *testing.T, s CloudService, env map[string]string, opts ...Option) *runningService {
+	t.Helper()
+	logger, level, tracer := slog.Default(), logLevel.Level(), tracing.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		logLevel.Set(level)
+		tracing.SetDefault(tracer)
+	})
+
+	restLis, adminLis := listenTCP(t), listenTCP(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	rs := &runningService{
+		restAddr:  restLis.Addr().String(),
+		adminAddr: adminLis.Addr().String(),
+		stop:      cancel,
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(rs.done)
+		Start(s, append([]Option{
+			WithContext(ctx),
+			WithEnv(env),
+			WithRESTListener(restLis),
+			WithAdminListener(adminLis),
+		}, opts...)...)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		rs.wait(t)
+	})
+	return rs
+}
+
+func listenTCP(t *testing.T) net.Listener {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return lis
+}
+
+// get returns the status code of the response, or zero if the request fails.
+func get(url string) int {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+// waitStatus waits until the url responds with the given status code.
+func waitStatus(t *testing.T, url string, code int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for get(url) != code {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not respond with %d", url, code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// wait waits until Start returns.
+func (rs *runningService) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-rs.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("service did not stop")
+	}
+}
+
+func TestStart(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})
+	svc := &testService{rest: mux}
+	rs := startService(t, svc, map[string]string{
+		"LOG_LEVEL":          "warn",
+		"SERVER_DRAIN_DELAY": "500ms",
+	}, WithoutSignals())
+
+	waitStatus(t, "http://"+rs.adminAddr+"/readyz", http.StatusOK)
+	if code := get("http://" + rs.restAddr + "/"); code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+
+	// The request in flight when the shutdown begins is completed.
+	slow := make(chan int, 1)
+	go func() { slow <- get("http://" + rs.restAddr + "/slow") }()
+	<-started
+	rs.stop()
+
+	// During the drain phase the service reports that it is not ready, but
+	// keeps serving new requests.
+	waitStatus(t, "http://"+rs.adminAddr+"/readyz", http.StatusServiceUnavailable)
+	if code := get("http://" + rs.restAddr + "/"); code != http.StatusOK {
+		t.Errorf("got status %d during the drain phase, want %d", code, http.StatusOK)
+	}
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("got status %d for the request in flight, want %d", code, http.StatusOK)
+	}
+
+	rs.wait(t)
+	if _, err := net.Dial("tcp", rs.restAddr); err == nil {
+		t.Error("rest server still listening after shutdown")
+	}
+	if !svc.closed.Load() || !svc.bus.closed.Load() {
+		t.Errorf("got service closed %v and bus closed %v, want both closed",
+			svc.closed.Load(), svc.bus.closed.Load())
+	}
+}
+
+func TestStartShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	svc := &testService{rest: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done() // never finishes on its own
+	})}
+	rs := startService(t, svc, map[string]string{
+		"LOG_LEVEL":               "error",
+		"SERVER_SHUTDOWN_TIMEOUT": "100ms",
+	}, WithoutSignals())
+	waitStatus(t, "http://"+rs.adminAddr+"/readyz", http.StatusOK)
+
+	stuck := make(chan int, 1)
+	go func() { stuck <- get("http://" + rs.restAddr + "/") }()
+	<-started
+	start := time.Now()
+	rs.stop()
+	rs.wait(t)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("shutdown took %v with a timeout of 100ms", d)
+	}
+	if code := <-stuck; code != 0 {
+		t.Errorf("got status %d for the interrupted request, want a failed request", code)
+	}
+}
+
+func TestStartInitError(t *testing.T) {
+	svc := &testService{
+		rest:    http.NotFoundHandler(),
+		initErr: errors.New("no database"), //nolint:goerr113 // test
+	}
+	rs := startService(t, svc, map[string]string{"LOG_LEVEL": "error"}, WithoutSignals())
+	rs.wait(t)
+
+	// The provided listeners are closed, and nothing was initialized that
+	// needs to be closed.
+	if _, err := net.Dial("tcp", rs.restAddr); err == nil {
+		t.Error("rest listener not closed")
+	}
+	if svc.closed.Load() || svc.bus.closed.Load() {
+		t.Error("service closed although it failed to initialize")
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The states sent to the service manager, see sd_notify(3).
+const (
+	sdReady    = "READY=1"
+	sdStopping = "STOPPING=1"
+	sdWatchdog = "WATCHDOG=1"
+)
+
+// sdNotify sends the state to the service manager. It does nothing if the
+// service is not run by systemd with a notification socket.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("create notify socket: %w", err)
+	}
+	defer unix.Close(fd) //nolint:errcheck // intentional
+	// Abstract socket addresses start with "@", which is handled by unix.
+	if err := unix.Sendto(fd, []byte(state), 0, &unix.SockaddrUnix{Name: addr}); err != nil {
+		return fmt.Errorf("send to notify socket: %w", err)
+	}
+	return nil
+}
+
+// notifySystemd sends the state to the service manager and logs any errors.
+func notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		slog.Error("failed to notify systemd", slog.String("error", err.Error()))
+	}
+}
+
+// sdWatchdogInterval returns the interval at which the service manager must be
+// notified that the service is alive, or zero if the watchdog is disabled. The
+// interval is half of the watchdog timeout, as recommended by systemd.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2 //nolint:gomnd // half the timeout
+}
+
+// watchdog notifies the service manager that the service is alive at the
+// watchdog interval, until done is closed.
+func watchdog(done <-chan struct{}) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			notifySystemd(sdWatchdog)
+		case <-done:
+			return
+		}
+	}
+}
+
+// inheritSystemd adds the listeners passed by systemd socket activation, see
+// sd_listen_fds(3). The listeners are matched to the servers by the names set
+// with FileDescriptorName= in the socket unit.
+func (ls *listeners) inheritSystemd() error {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return fmt.Errorf("%w: LISTEN_FDS: %v", ErrInvalidConfig, err)
+	}
+	// All names are checked before any file is inherited, so that no file
+	// is left half inherited.
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		if i >= len(names) || names[i] == "" {
+			return fmt.Errorf("%w: LISTEN_FDNAMES: no name for file descriptor %d",
+				ErrInvalidConfig, listenFDsStart+i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if err := ls.inherit(names[i], listenFDsStart+i); err != nil {
+			return err
+		}
+	}
+
+	// The variables describe the files of this process only, so they must
+	// not leak into processes started by the service.
+	_ = os.Unsetenv("LISTEN_PID")     //nolint:errcheck // intentional
+	_ = os.Unsetenv("LISTEN_FDS")     //nolint:errcheck // intentional
+	_ = os.Unsetenv("LISTEN_FDNAMES") //nolint:errcheck // intentional
+	return nil
+}
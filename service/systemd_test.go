@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenNotify opens a fake notification socket of the service manager and
+// sets NOTIFY_SOCKET to its address.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify returns the next state sent to the notification socket.
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 1024)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatalf("read notification: %v", err)
+	}
+	return string(b[:n])
+}
+
+func TestSDNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify(sdReady); err != nil {
+		t.Errorf("notify without a socket: %v", err)
+	}
+
+	conn := listenNotify(t)
+	if err := sdNotify(sdReady); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if got := readNotify(t, conn); got != sdReady {
+		t.Errorf("got state %q, want %q", got, sdReady)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if err := sdNotify(sdReady); err == nil {
+		t.Error("notify to a missing socket succeeded")
+	}
+}
+
+func TestSDNotifyAbstract(t *testing.T) {
+	name := "@service-test-" + strconv.Itoa(os.Getpid())
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("abstract sockets not supported: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", name)
+	if err := sdNotify(sdStopping); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if got := readNotify(t, conn); got != sdStopping {
+		t.Errorf("got state %q, want %q", got, sdStopping)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for name, tc := range map[string]struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		"disabled":      {},
+		"invalid":       {usec: "soon"},
+		"enabled":       {usec: "20000", want: 10 * time.Millisecond},
+		"this process":  {usec: "20000", pid: pid, want: 10 * time.Millisecond},
+		"other process": {usec: "20000", pid: "1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tc.usec)
+			t.Setenv("WATCHDOG_PID", tc.pid)
+			if got := sdWatchdogInterval(); got != tc.want {
+				t.Errorf("got interval %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		watchdog(done)
+		close(stopped)
+	}()
+	for i := 0; i < 2; i++ {
+		if got := readNotify(t, conn); got != sdWatchdog {
+			t.Errorf("got state %q, want %q", got, sdWatchdog)
+		}
+	}
+	close(done)
+	<-stopped
+}
+
+func TestInheritSystemdErrors(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for name, tc := range map[string]struct {
+		pid, fds, names string
+		wantErr         bool
+	}{
+		"other process":    {pid: "1", fds: "x"},
+		"invalid count":    {pid: pid, fds: "x", wantErr: true},
+		"missing name":     {pid: pid, fds: "1", wantErr: true},
+		"missing 2nd name": {pid: pid, fds: "2", names: "rest", wantErr: true},
+		"empty name":       {pid: pid, fds: "2", names: ":admin", wantErr: true},
+		"no listeners":     {pid: pid, fds: "0"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tc.pid)
+			t.Setenv("LISTEN_FDS", tc.fds)
+			t.Setenv("LISTEN_FDNAMES", tc.names)
+			ls := &listeners{inherited: make(map[string]net.Listener)}
+			err := ls.inheritSystemd()
+			if tc.wantErr != errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("got error %v, want %v: %v", err, ErrInvalidConfig, tc.wantErr)
+			}
+			if len(ls.inherited) != 0 {
+				t.Errorf("got inherited listeners %v", ls.inherited)
+			}
+		})
+	}
+}
+
+// socketActivationEnv marks the process started by [TestSocketActivation].
+const socketActivationEnv = "SERVICE_TEST_SOCKET_ACTIVATION"
+
+// runSocketActivated is the process started by [TestSocketActivation]. It runs
+// the service on the listeners passed by the test, until a request to "/exit".
+func runSocketActivated() {
+	// The test cannot know the pid of this process in advance.
+	_ = os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	Start(&testService{rest: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "socket activated")
+		if r.URL.Path == "/exit" {
+			cancel()
+		}
+	})}, WithContext(ctx), WithoutSignals(), WithEnv(map[string]string{"LOG_LEVEL": "error"}))
+	os.Exit(0)
+}
+
+func TestSocketActivation(t *testing.T) {
+	if os.Getenv(socketActivationEnv) != "" {
+		runSocketActivated()
+	}
+
+	// The listeners are passed as file descriptors 3 and 4, as done by
+	// systemd.
+	restLis, adminLis := listenTCP(t), listenTCP(t)
+	var files []*os.File
+	for _, lis := range []net.Listener{restLis, adminLis} {
+		f, err := lis.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatalf("listener file: %v", err)
+		}
+		defer f.Close()
+		files = append(files, f)
+		_ = lis.Close()
+	}
+	conn := listenNotify(t)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSocketActivation$") //nolint:gosec // the test binary
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		socketActivationEnv+"=1",
+		"LISTEN_FDS=2",
+		"LISTEN_FDNAMES=rest:admin",
+	)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start process: %v", err)
+	}
+	defer func() { _ = cmd.Process.Kill() }()
+
+	want := sdReady + "\nMAINPID=" + strconv.Itoa(cmd.Process.Pid)
+	if got := readNotify(t, conn); got != want {
+		t.Errorf("got state %q, want %q", got, want)
+	}
+	if code := get("http://" + adminLis.Addr().String() + "/readyz"); code != http.StatusOK {
+		t.Errorf("got readiness %d, want %d", code, http.StatusOK)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if got := body(t, client, "http://"+restLis.Addr().String()+"/exit"); got != "socket activated" {
+		t.Errorf("got response %q, want socket activated", got)
+	}
+	if got := readNotify(t, conn); !strings.HasPrefix(got, sdStopping) {
+		t.Errorf("got state %q, want %q", got, sdStopping)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("process failed: %v", err)
+	}
+}
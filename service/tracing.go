@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/eventscompass/service-framework/tracing"
+)
+
+// setupTracer creates a new tracer as described by the config and installs it
+// as the default tracer.
+func setupTracer(cfg *TracingConfig) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch strings.ToLower(cfg.Exporter) {
+	case "none", "":
+	case "otlp":
+		name := cfg.ServiceName
+		if name == "" {
+			name = filepath.Base(os.Args[0])
+		}
+		exporter = tracing.NewOTLPExporter(cfg.Endpoint, name, nil)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+
+	t := tracing.NewTracer(
+		exporter,
+		tracing.WithSampleRatio(cfg.SampleRatio),
+		tracing.WithBatching(512, 5*time.Second), //nolint:gomnd // reasonable defaults
+	)
+	tracing.SetDefault(t)
+	return t, nil
+}
+
+// traceHTTP wraps the given handler starting a server span for every request.
+// The trace context is extracted from the request headers.
+func traceHTTP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, route := withRoute(r)
+		ctx := tracing.Extract(r.Context(), tracing.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, r.Method, tracing.WithKind(tracing.SpanKindServer))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+
+		pattern := routePattern(route)
+		code := rec.statusCode()
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(
+			slog.String("http.request.method", r.Method),
+			slog.String("http.route", pattern),
+			slog.String("url.path", r.URL.Path),
+			slog.Int("http.response.status_code", code),
+		)
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(code))
+		}
+	})
+}
+
+// TracingUnaryInterceptor returns a unary server interceptor that starts a
+// server span for every rpc. The trace context is extracted from the incoming
+// metadata. Install it when constructing the grpc server of the service:
+//
+//	grpc.NewServer(grpc.ChainUnaryInterceptor(service.TracingUnaryInterceptor()))
+//
+// Servers created with [NewGRPCServer] have it installed already.
+func TracingUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		ctx, span := startServerSpan(ctx, info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		endRPCSpan(span, err)
+		return resp, err
+	}
+}
+
+// TracingStreamInterceptor returns a stream server interceptor that starts a
+// server span for every streaming rpc. The trace context is extracted from the
+// incoming metadata. Install it when constructing the grpc server of the
+// service:
+//
+//	grpc.NewServer(grpc.ChainStreamInterceptor(service.TracingStreamInterceptor()))
+//
+// Servers created with [NewGRPCServer] have it installed already.
+func TracingStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := startServerSpan(ss.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// TracingUnaryClientInterceptor returns a unary client interceptor that starts
+// a client span for every rpc and propagates it through the outgoing metadata.
+// Install it when dialing other services:
+//
+//	grpc.Dial(addr, grpc.WithChainUnaryInterceptor(service.TracingUnaryClientInterceptor()))
+func TracingUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := startClientSpan(ctx, method)
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// TracingStreamClientInterceptor returns a stream client interceptor that
+// starts a client span for every streaming rpc and propagates it through the
+// outgoing metadata. The span ends once the stream is established. Install it
+// when dialing other services:
+//
+//	grpc.Dial(addr, grpc.WithChainStreamInterceptor(service.TracingStreamClientInterceptor()))
+func TracingStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := startClientSpan(ctx, method)
+		defer span.End()
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		endRPCSpan(span, err)
+		return cs, err
+	}
+}
+
+func startServerSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = tracing.Extract(ctx, metadataCarrier(md))
+	return tracing.Start(ctx, strings.TrimPrefix(method, "/"),
+		tracing.WithKind(tracing.SpanKindServer),
+		tracing.WithAttributes(slog.String("rpc.system", "grpc"), slog.String("rpc.method", method)),
+	)
+}
+
+func startClientSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, strings.TrimPrefix(method, "/"),
+		tracing.WithKind(tracing.SpanKindClient),
+		tracing.WithAttributes(slog.String("rpc.system", "grpc"), slog.String("rpc.method", method)),
+	)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	tracing.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func endRPCSpan(span *tracing.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(slog.String("rpc.grpc.status_code", code.String()))
+	span.RecordError(err)
+}
+
+// metadataCarrier adapts [metadata.MD] to the [tracing.Carrier] interface.
+type metadataCarrier metadata.MD
+
+// Get implements the [tracing.Carrier] interface.
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Set implements the [tracing.Carrier] interface.
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// contextStream is a [grpc.ServerStream] with a custom context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context //nolint:containedctx // overrides the stream context
+}
+
+// Context implements the [grpc.ServerStream] interface.
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
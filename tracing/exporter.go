@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	// ExportSpans exports a batch of spans.
+	ExportSpans(_ context.Context, spans []*SpanData) error
+
+	// Shutdown flushes any pending spans and releases all
+	// associated resources.
+	Shutdown(_ context.Context) error
+}
+
+// InMemoryExporter is an [Exporter] that keeps the spans in memory. It is meant
+// to be used in tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+var _ Exporter = (*InMemoryExporter)(nil)
+
+// NewInMemoryExporter creates a new empty [InMemoryExporter].
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpans implements the [Exporter] interface.
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range spans {
+		e.spans = append(e.spans, *s)
+	}
+	return nil
+}
+
+// Shutdown implements the [Exporter] interface.
+func (e *InMemoryExporter) Shutdown(_ context.Context) error { return nil }
+
+// Spans returns the exported spans in the order in which they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset drops all the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// batcher buffers spans and exports them in batches in the background.
+type batcher struct {
+	exporter Exporter
+	size     int
+	spans    chan *SpanData
+	done     chan struct{}
+
+	// mu guards the spans channel from being used after it is
+	// closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newBatcher(exporter Exporter, size int, interval time.Duration) *batcher {
+	b := &batcher{
+		exporter: exporter,
+		size:     size,
+		// Buffer a few batches. If the exporter cannot keep up, then spans
+		// are dropped instead of blocking the application.
+		spans: make(chan *SpanData, 4*size), //nolint:gomnd // see above
+		done:  make(chan struct{}),
+	}
+	go b.run(interval)
+	return b
+}
+
+func (b *batcher) add(s *SpanData) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	select {
+	case b.spans <- s:
+	default:
+		slog.Debug("dropping span, export queue is full")
+	}
+}
+
+func (b *batcher) run(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, b.size)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := b.exporter.ExportSpans(context.Background(), batch); err != nil {
+			slog.Warn("failed to export spans", slog.String("error", err.Error()))
+		}
+		batch = make([]*SpanData, 0, b.size)
+	}
+
+	for {
+		select {
+		case s, ok := <-b.spans:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, s); len(batch) >= b.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// stop flushes the buffered spans and waits for the export to finish, or for
+// the context to be done. Spans added after stop is called are dropped.
+func (b *batcher) stop(ctx context.Context) {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.spans)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+	}
+}
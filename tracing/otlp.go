@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLPExporter is an [Exporter] that sends spans to an OpenTelemetry collector
+// using the OTLP/HTTP protocol with JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp.
+type OTLPExporter struct {
+	endpoint string
+	headers  map[string]string
+	resource []otlpKeyValue
+	client   *http.Client
+}
+
+var _ Exporter = (*OTLPExporter)(nil)
+
+// NewOTLPExporter creates a new [OTLPExporter]. The endpoint is the base url of
+// the collector, e.g. "http://localhost:4318", and the spans are sent to its
+// "/v1/traces" path. The service name is reported as the "service.name"
+// resource attribute. The given headers are added to every export request, e.g.
+// for authentication.
+func NewOTLPExporter(endpoint, serviceName string, headers map[string]string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:  headers,
+		resource: []otlpKeyValue{otlpAttr(slog.String("service.name", serviceName))},
+		client:   &http.Client{Timeout: 10 * time.Second}, //nolint:gomnd // reasonable default
+	}
+}
+
+// ExportSpans implements the [Exporter] interface.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: e.resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/eventscompass/service-framework"},
+			Spans: make([]otlpSpan, 0, len(spans)),
+		}},
+	}}}
+	scope := &req.ResourceSpans[0].ScopeSpans[0]
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, newOTLPSpan(s))
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal spans: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("send spans: %w", err)
+	}
+	defer resp.Body.Close()               //nolint:errcheck // intentional
+	_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck // drain to reuse the conn
+	if resp.StatusCode/100 != 2 {         //nolint:gomnd // 2xx
+		return fmt.Errorf("send spans: collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown implements the [Exporter] interface.
+func (e *OTLPExporter) Shutdown(_ context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// The following types model the JSON encoding of the OTLP trace export request.
+// Note that trace and span ids are hex encoded, and 64-bit integers are encoded
+// as strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPSpan(s *SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.SpanContext.TraceID.String(),
+		SpanID:            s.SpanContext.SpanID.String(),
+		TraceState:        s.SpanContext.TraceState,
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+	}
+	if s.Parent.IsValid() {
+		span.ParentSpanID = s.Parent.SpanID.String()
+	}
+	for _, a := range s.Attributes {
+		span.Attributes = append(span.Attributes, otlpAttr(a))
+	}
+	return span
+}
+
+func otlpAttr(a slog.Attr) otlpKeyValue {
+	kv := otlpKeyValue{Key: a.Key}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindBool:
+		b := v.Bool()
+		kv.Value.BoolValue = &b
+	case slog.KindInt64:
+		i := strconv.FormatInt(v.Int64(), 10)
+		kv.Value.IntValue = &i
+	case slog.KindUint64:
+		i := strconv.FormatUint(v.Uint64(), 10)
+		kv.Value.IntValue = &i
+	case slog.KindFloat64:
+		f := v.Float64()
+		kv.Value.DoubleValue = &f
+	default:
+		s := v.String()
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
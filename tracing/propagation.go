@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eventscompass/service-framework/internal/errs"
+)
+
+// The names of the W3C trace context headers.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// Carrier is the medium that carries the trace context across process
+// boundaries, e.g. http headers or message headers.
+type Carrier interface {
+	// Get returns the value for the given key, or an empty
+	// string if the key is missing.
+	Get(key string) string
+
+	// Set sets the value for the given key.
+	Set(key, value string)
+}
+
+// Inject writes the span context carried by ctx into the carrier. It does
+// nothing if ctx does not carry a valid span context.
+func Inject(ctx context.Context, c Carrier) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	c.Set(TraceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		c.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Extract reads the span context from the carrier and returns a copy of ctx
+// carrying it, see [ContextWithRemoteSpanContext]. If the carrier does not
+// carry a valid span context then ctx is returned unchanged.
+func Extract(ctx context.Context, c Carrier) context.Context {
+	sc, err := ParseTraceparent(c.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = c.Get(TracestateHeader)
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// FormatTraceparent formats the span context as a traceparent header value.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. This function returns
+// service.ErrBadRequest in case the value is malformed.
+func ParseTraceparent(v string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("%w: invalid traceparent %q", errs.ErrBadRequest, v)
+	}
+	// Future versions may append fields, but version 00 has exactly four.
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("%w: invalid traceparent %q", errs.ErrBadRequest, v)
+	}
+
+	var flags [1]byte
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("%w: invalid trace id: %v", errs.ErrBadRequest, err)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("%w: invalid span id: %v", errs.ErrBadRequest, err)
+	}
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("%w: invalid trace flags: %v", errs.ErrBadRequest, err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%w: invalid traceparent %q", errs.ErrBadRequest, v)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return fmt.Errorf("%q has wrong format", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err //nolint:wrapcheck // intentional
+}
+
+// HeaderCarrier adapts [http.Header] to the [Carrier] interface.
+type HeaderCarrier http.Header
+
+// Get implements the [Carrier] interface.
+func (c HeaderCarrier) Get(key string) string { return http.Header(c).Get(key) }
+
+// Set implements the [Carrier] interface.
+func (c HeaderCarrier) Set(key, value string) { http.Header(c).Set(key, value) }
+
+// MapCarrier adapts a map to the [Carrier] interface.
+type MapCarrier map[string]string
+
+// Get implements the [Carrier] interface.
+func (c MapCarrier) Get(key string) string { return c[key] }
+
+// Set implements the [Carrier] interface.
+func (c MapCarrier) Set(key, value string) { c[key] = value }
+
+// Transport is an [http.RoundTripper] that starts a client span for every
+// request and propagates it through the request headers.
+type Transport struct {
+	// Base is the underlying round tripper. If nil, then
+	// [http.DefaultTransport] is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the [http.RoundTripper] interface.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Start(r.Context(), "HTTP "+r.Method, WithKind(SpanKindClient))
+	defer span.End()
+
+	r = r.Clone(ctx)
+	Inject(ctx, HeaderCarrier(r.Header))
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err //nolint:wrapcheck // intentional
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(StatusError, resp.Status)
+	}
+	return resp, nil
+}
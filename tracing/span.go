@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// TraceID is the identifier of a trace.
+type TraceID [16]byte
+
+// IsValid reports whether the trace id is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// String returns the hex encoding of the trace id.
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID is the identifier of a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether the span id is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// String returns the hex encoding of the span id.
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext holds the part of a span that is propagated across process
+// boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+
+	// Sampled reports whether the trace is recorded and exported.
+	Sampled bool
+
+	// TraceState is the vendor specific trace state, which is
+	// propagated as is.
+	TraceState string
+
+	// Remote reports whether the span context was propagated
+	// from a remote parent.
+	Remote bool
+}
+
+// IsValid reports whether the span context has valid trace and span ids.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship between a span, its parent and its
+// children.
+type SpanKind int
+
+// The span kinds match the ones used by OpenTelemetry.
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+	SpanKindProducer
+	SpanKindConsumer
+)
+
+// StatusCode is the status of a finished span.
+type StatusCode int
+
+// The status codes match the ones used by OpenTelemetry.
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// SpanData is a read-only snapshot of a finished span, as it is passed to the
+// [Exporter].
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanContext
+	Start         time.Time
+	End           time.Time
+	Attributes    []slog.Attr
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span represents a single operation within a trace. A span must be ended by
+// calling [Span.End]. All methods are safe to be called on a nil span, which
+// makes it easy to disable tracing.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the span context of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext // immutable
+}
+
+// SetName changes the name of the span.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttributes sets the given attributes on the span.
+func (s *Span) SetAttributes(attrs ...slog.Attr) {
+	if s == nil || !s.data.SpanContext.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = code
+	s.data.StatusMessage = msg
+}
+
+// RecordError marks the span as failed with the given error. It does nothing
+// if err is nil.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End ends the span. The span is exported if it is sampled. Calling End more
+// than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.export(&data)
+	}
+}
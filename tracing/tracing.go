@@ -0,0 +1,225 @@
+// Package tracing implements distributed tracing. Spans are propagated across
+// process boundaries using the W3C trace context format, see
+// https://www.w3.org/TR/trace-context/, and are exported using an [Exporter].
+//
+// A span is started from a context, and the returned context carries the span
+// so that child spans can be started from it:
+//
+//	ctx, span := tracing.Start(ctx, "book event")
+//	defer span.End()
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer creates spans and hands the finished ones to an [Exporter].
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+	batcher  *batcher
+}
+
+// TracerOption configures a [Tracer].
+type TracerOption func(*Tracer)
+
+// WithSampleRatio sets the ratio of root spans that are sampled. Child spans
+// follow the sampling decision of their parent. The default ratio is 1.
+func WithSampleRatio(ratio float64) TracerOption {
+	return func(t *Tracer) { t.ratio = ratio }
+}
+
+// WithBatching configures the tracer to export spans in batches of up to size
+// spans, or at least once every interval. Without batching spans are exported
+// synchronously when they end, which is what you want for the
+// [InMemoryExporter]. Batching is skipped if the tracer has no exporter.
+func WithBatching(size int, interval time.Duration) TracerOption {
+	return func(t *Tracer) {
+		if t.exporter != nil {
+			t.batcher = newBatcher(t.exporter, size, interval)
+		}
+	}
+}
+
+// NewTracer creates a new [Tracer] that exports spans using the given exporter.
+// If the exporter is nil, then spans are still created and propagated, but are
+// never exported.
+func NewTracer(exporter Exporter, opts ...TracerOption) *Tracer {
+	t := &Tracer{exporter: exporter, ratio: 1}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Start starts a new span. If the context carries a span, or a remote span
+// context, then the new span is its child. The returned context carries the new
+// span.
+func (t *Tracer) Start(
+	ctx context.Context,
+	name string,
+	opts ...SpanOption,
+) (context.Context, *Span) {
+	cfg := spanConfig{kind: SpanKindInternal}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, TraceState: parent.TraceState}
+	if parent.IsValid() {
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+	sc.SpanID = newSpanID()
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			Kind:        cfg.kind,
+			SpanContext: sc,
+			Parent:      parent,
+			Start:       time.Now(),
+			Attributes:  cfg.attrs,
+		},
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Shutdown exports all the buffered spans and shuts down the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.batcher != nil {
+		t.batcher.stop(ctx)
+	}
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx) //nolint:wrapcheck // intentional
+}
+
+// sample decides whether a new trace is sampled based on its id.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.ratio >= 1:
+		return true
+	case t.ratio <= 0:
+		return false
+	default:
+		bound := uint64(t.ratio * math.MaxUint64)
+		return binary.BigEndian.Uint64(id[8:]) < bound
+	}
+}
+
+func (t *Tracer) export(s *SpanData) {
+	switch {
+	case t.exporter == nil:
+	case t.batcher != nil:
+		t.batcher.add(s)
+	default:
+		if err := t.exporter.ExportSpans(context.Background(), []*SpanData{s}); err != nil {
+			slog.Warn("failed to export span", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// SpanOption configures a span when it is started.
+type SpanOption func(*spanConfig)
+
+type spanConfig struct {
+	kind  SpanKind
+	attrs []slog.Attr
+}
+
+// WithKind sets the kind of the span. The default kind is [SpanKindInternal].
+func WithKind(kind SpanKind) SpanOption {
+	return func(c *spanConfig) { c.kind = kind }
+}
+
+// WithAttributes sets the given attributes on the span.
+func WithAttributes(attrs ...slog.Attr) SpanOption {
+	return func(c *spanConfig) { c.attrs = append(c.attrs, attrs...) }
+}
+
+var (
+	// defaultTracer is set with [SetDefault]. Until then
+	// [noopTracer] is used.
+	defaultTracer atomic.Pointer[Tracer]
+
+	// noopTracer propagates the trace context, but does not
+	// export spans.
+	noopTracer = NewTracer(nil)
+)
+
+// SetDefault makes t the default tracer, which is used by [Start].
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the default tracer.
+func Default() *Tracer {
+	if t := defaultTracer.Load(); t != nil {
+		return t
+	}
+	return noopTracer
+}
+
+// Start starts a new span using the default tracer, see [Tracer.Start].
+func Start(ctx context.Context, name string, opts ...SpanOption) (context.Context, *Span) {
+	return Default().Start(ctx, name, opts...)
+}
+
+type (
+	spanKey       struct{}
+	remoteSpanKey struct{}
+)
+
+// SpanFromContext returns the span carried by the context, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SpanContextFromContext returns the span context of the span carried by the
+// context. If there is no span, then the remote span context carried by the
+// context is returned, see [ContextWithRemoteSpanContext].
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteSpanKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx that carries the given
+// span context, received from a remote process. Spans started from the
+// returned context become its children.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	ctx = context.WithValue(ctx, spanKey{}, (*Span)(nil))
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:]) //nolint:errcheck // never fails
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:]) //nolint:errcheck // never fails
+	}
+	return id
+}
@@ -0,0 +1,325 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eventscompass/service-framework/internal/errs"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	for name, tc := range map[string]struct {
+		value   string
+		sampled bool
+		wantErr bool
+	}{
+		"sampled":           {value: "00-" + traceID + "-" + spanID + "-01", sampled: true},
+		"not sampled":       {value: "00-" + traceID + "-" + spanID + "-00"},
+		"other flags":       {value: "00-" + traceID + "-" + spanID + "-03", sampled: true},
+		"surrounding space": {value: " 00-" + traceID + "-" + spanID + "-01 ", sampled: true},
+		"future version":    {value: "cc-" + traceID + "-" + spanID + "-01-extra", sampled: true},
+		"empty":             {value: "", wantErr: true},
+		"version ff":        {value: "ff-" + traceID + "-" + spanID + "-01", wantErr: true},
+		"version 00 extra":  {value: "00-" + traceID + "-" + spanID + "-01-extra", wantErr: true},
+		"upper case":        {value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", wantErr: true},
+		"short trace id":    {value: "00-4bf92f35-" + spanID + "-01", wantErr: true},
+		"bad span id":       {value: "00-" + traceID + "-00f067aa0ba902bz-01", wantErr: true},
+		"bad flags":         {value: "00-" + traceID + "-" + spanID + "-1", wantErr: true},
+		"zero trace id":     {value: "00-00000000000000000000000000000000-" + spanID + "-01", wantErr: true},
+		"zero span id":      {value: "00-" + traceID + "-0000000000000000-01", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tc.value)
+			if tc.wantErr {
+				if !errors.Is(err, errs.ErrBadRequest) {
+					t.Errorf("got error %v, want %v", err, errs.ErrBadRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tc.sampled {
+				t.Errorf("got span context %+v", sc)
+			}
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	// The trace state of the remote parent is propagated as is.
+	in := MapCarrier{
+		TraceparentHeader: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TracestateHeader:  "vendor=opaque,other=1",
+	}
+	ctx := Extract(context.Background(), in)
+	remote := SpanContextFromContext(ctx)
+	if !remote.Remote || remote.TraceState != in[TracestateHeader] {
+		t.Fatalf("got remote span context %+v", remote)
+	}
+
+	ctx, span := tracer.Start(ctx, "child")
+	out := MapCarrier{}
+	Inject(ctx, out)
+	span.End()
+
+	sc := span.SpanContext()
+	if sc.TraceID != remote.TraceID || sc.SpanID == remote.SpanID || !sc.Sampled {
+		t.Errorf("got span context %+v, want child of %+v", sc, remote)
+	}
+	if want := FormatTraceparent(sc); out[TraceparentHeader] != want {
+		t.Errorf("got traceparent %q, want %q", out[TraceparentHeader], want)
+	}
+	if out[TracestateHeader] != in[TracestateHeader] {
+		t.Errorf("got tracestate %q, want %q", out[TracestateHeader], in[TracestateHeader])
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].Parent != remote {
+		t.Errorf("got spans %+v, want one child of %+v", spans, remote)
+	}
+
+	// An invalid traceparent leaves the context unchanged, and the tracestate
+	// is ignored without a traceparent.
+	in = MapCarrier{TraceparentHeader: "garbage", TracestateHeader: "vendor=opaque"}
+	ctx = Extract(context.Background(), in)
+	if sc := SpanContextFromContext(ctx); sc.IsValid() || sc.TraceState != "" {
+		t.Errorf("got span context %+v from invalid carrier", sc)
+	}
+
+	// Nothing is injected without a span.
+	out = MapCarrier{}
+	Inject(context.Background(), out)
+	if len(out) != 0 {
+		t.Errorf("got carrier %v, want empty", out)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter, WithSampleRatio(0))
+
+	// Child spans follow the decision of the root span.
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttributes(slog.String("key", "value"))
+	child.End()
+	root.End()
+	if root.SpanContext().Sampled || child.SpanContext().Sampled {
+		t.Error("spans sampled with ratio 0")
+	}
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Errorf("got %d exported spans, want 0", len(spans))
+	}
+
+	// A sampled remote parent overrides the ratio.
+	ctx = ContextWithRemoteSpanContext(context.Background(),
+		SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true})
+	_, span := tracer.Start(ctx, "server")
+	span.End()
+	span.End() // ending twice has no effect
+	if spans := exporter.Spans(); len(spans) != 1 {
+		t.Errorf("got %d exported spans, want 1", len(spans))
+	}
+}
+
+// blockingExporter records the exported batches. The exports block until
+// release is closed.
+type blockingExporter struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (e *blockingExporter) ExportSpans(_ context.Context, spans []*SpanData) error {
+	<-e.release
+	names := make([]string, 0, len(spans))
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.batches = append(e.batches, names)
+	return nil
+}
+
+func (e *blockingExporter) Shutdown(_ context.Context) error { return nil }
+
+func (e *blockingExporter) exported() [][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([][]string(nil), e.batches...)
+}
+
+func TestBatching(t *testing.T) {
+	exporter := &blockingExporter{release: make(chan struct{})}
+	close(exporter.release)
+	tracer := NewTracer(exporter, WithBatching(2, time.Hour))
+
+	// Full batches are exported right away, and the rest on shutdown.
+	for _, name := range []string{"a", "b", "c"} {
+		_, span := tracer.Start(context.Background(), name)
+		span.End()
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(exporter.exported()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	got, _ := json.Marshal(exporter.exported())
+	if string(got) != `[["a","b"],["c"]]` {
+		t.Errorf("got batches %s", got)
+	}
+
+	// Spans ended after shutdown are dropped.
+	_, span := tracer.Start(context.Background(), "late")
+	span.End()
+	if n := len(exporter.exported()); n != 2 {
+		t.Errorf("got %d batches after shutdown, want 2", n)
+	}
+}
+
+func TestBatchingInterval(t *testing.T) {
+	exporter := &blockingExporter{release: make(chan struct{})}
+	close(exporter.release)
+	tracer := NewTracer(exporter, WithBatching(100, 10*time.Millisecond))
+	defer tracer.Shutdown(context.Background()) //nolint:errcheck // test
+
+	_, span := tracer.Start(context.Background(), "a")
+	span.End()
+	deadline := time.Now().Add(time.Second)
+	for len(exporter.exported()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("span not exported after the interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchingStop(t *testing.T) {
+	// The export never finishes, thus shutdown returns when the context is
+	// done. Spans are dropped instead of blocking once the queue is full.
+	exporter := &blockingExporter{release: make(chan struct{})}
+	defer close(exporter.release)
+	tracer := NewTracer(exporter, WithBatching(1, time.Hour))
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "span")
+		span.End()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("shutdown returned before the export finished")
+	}
+}
+
+func TestBatchingWithoutExporter(t *testing.T) {
+	tracer := NewTracer(nil, WithBatching(512, time.Second))
+	if tracer.batcher != nil {
+		t.Error("batcher started without an exporter")
+	}
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		got     otlpRequest
+		headers http.Header
+		path    string
+		status  = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, headers = r.URL.Path, r.Header
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := NewOTLPExporter(srv.URL+"/", "booking", map[string]string{"Authorization": "Bearer token"})
+	defer e.Shutdown(context.Background()) //nolint:errcheck // test
+
+	start := time.Unix(1700000000, 5)
+	parent := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	span := &SpanData{
+		Name:        "GET /events",
+		Kind:        SpanKindServer,
+		SpanContext: SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), TraceState: "vendor=1"},
+		Parent:      parent,
+		Start:       start,
+		End:         start.Add(time.Second),
+		Attributes: []slog.Attr{
+			slog.String("http.route", "/events"),
+			slog.Int("http.response.status_code", 500),
+			slog.Bool("retry", true),
+			slog.Float64("ratio", 0.5),
+		},
+		Status:        StatusError,
+		StatusMessage: "Internal Server Error",
+	}
+	if err := e.ExportSpans(context.Background(), []*SpanData{span}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	if path != "/v1/traces" {
+		t.Errorf("got path %q, want /v1/traces", path)
+	}
+	if headers.Get("Content-Type") != "application/json" || headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("got headers %v", headers)
+	}
+	res := got.ResourceSpans[0]
+	if a := res.Resource.Attributes[0]; a.Key != "service.name" || *a.Value.StringValue != "booking" {
+		t.Errorf("got resource attribute %+v", a)
+	}
+	s := res.ScopeSpans[0].Spans[0]
+	if s.TraceID != parent.TraceID.String() || s.ParentSpanID != parent.SpanID.String() ||
+		s.SpanID != span.SpanContext.SpanID.String() || s.TraceState != "vendor=1" {
+		t.Errorf("got ids %+v", s)
+	}
+	if s.Name != span.Name || s.Kind != SpanKindServer || s.Status.Code != StatusError ||
+		s.Status.Message != span.StatusMessage {
+		t.Errorf("got span %+v", s)
+	}
+	if s.StartTimeUnixNano != "1700000000000000005" || s.EndTimeUnixNano != "1700000001000000005" {
+		t.Errorf("got times %s and %s", s.StartTimeUnixNano, s.EndTimeUnixNano)
+	}
+	attrs, _ := json.Marshal(s.Attributes)
+	want := `[{"key":"http.route","value":{"stringValue":"/events"}},` +
+		`{"key":"http.response.status_code","value":{"intValue":"500"}},` +
+		`{"key":"retry","value":{"boolValue":true}},` +
+		`{"key":"ratio","value":{"doubleValue":0.5}}]`
+	if string(attrs) != want {
+		t.Errorf("got attributes %s, want %s", attrs, want)
+	}
+
+	// A failed export is reported.
+	status = http.StatusServiceUnavailable
+	if err := e.ExportSpans(context.Background(), []*SpanData{span}); err == nil {
+		t.Error("export succeeded, want error")
+	}
+}